@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var flagCacheFormat string
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Inspect the Herald cache",
+}
+
+var cacheLsCmd = &cobra.Command{
+	Use:   "ls [vault/item]",
+	Short: "List cached secrets with metadata and value fingerprints (never values)",
+	Long: `List cache entries: tier, provider, policy, age, expiry, value size and a
+SHA-256 fingerprint prefix. Compare fingerprints to tell whether two stacks,
+or a stack before and after a rotation, got the same value.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runCacheLs,
+}
+
+func init() {
+	cacheLsCmd.Flags().StringVar(&flagCacheFormat, "format", "text", "Output format: text or json")
+	cacheLsCmd.Flags().StringVar(&flagURL, "url", envOrDefault("HERALD_URL", "http://herald:8765"), "Herald service URL")
+	cacheLsCmd.Flags().StringVar(&flagToken, "token", os.Getenv("HERALD_API_TOKEN"), "Herald API bearer token")
+	addTLSFlags(cacheLsCmd)
+	cacheCmd.AddCommand(cacheLsCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
+
+type cacheEntry struct {
+	Key         string    `json:"key"`
+	Tier        string    `json:"tier"`
+	Provider    string    `json:"provider,omitempty"`
+	Policy      string    `json:"policy,omitempty"`
+	CreatedAt   time.Time `json:"created_at,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at,omitempty"`
+	Expired     bool      `json:"expired"`
+	AgeSeconds  int64     `json:"age_seconds"`
+	Size        int       `json:"size"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	KeyVersion  uint32    `json:"key_version,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+type cacheListResponse struct {
+	Entries []cacheEntry `json:"entries"`
+	Count   int          `json:"count"`
+}
+
+func runCacheLs(cmd *cobra.Command, args []string) error {
+	if flagCacheFormat != "text" && flagCacheFormat != "json" {
+		return fmt.Errorf("--format must be 'text' or 'json'")
+	}
+	path := "/v1/cache"
+	if len(args) == 1 {
+		vault, item, ok := strings.Cut(strings.Trim(args[0], "/"), "/")
+		if !ok || vault == "" || item == "" || strings.Contains(item, "/") {
+			return fmt.Errorf("expected vault/item, got %q", args[0])
+		}
+		path += "/" + url.PathEscape(vault) + "/" + url.PathEscape(item)
+	}
+
+	var resp cacheListResponse
+	if err := getJSON(path, &resp); err != nil {
+		return err
+	}
+
+	if flagCacheFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(resp)
+	}
+	if resp.Count == 0 {
+		fmt.Println("cache is empty")
+		return nil
+	}
+	fmt.Printf("%-40s  %-9s  %-9s  %-20s  %8s  %-10s  %6s  %s\n",
+		"KEY", "TIER", "POLICY", "PROVIDER", "AGE", "EXPIRES", "SIZE", "FINGERPRINT")
+	for _, e := range resp.Entries {
+		if e.Error != "" {
+			fmt.Printf("%-40s  %-9s  error: %s\n", e.Key, e.Tier, e.Error)
+			continue
+		}
+		expires := "in " + shortDuration(time.Until(e.ExpiresAt))
+		if e.Expired {
+			expires = "expired"
+		}
+		fmt.Printf("%-40s  %-9s  %-9s  %-20s  %8s  %-10s  %6d  %s\n",
+			e.Key, e.Tier, e.Policy, e.Provider, shortDuration(time.Duration(e.AgeSeconds)*time.Second),
+			expires, e.Size, e.Fingerprint)
+	}
+	fmt.Printf("%d entries\n", resp.Count)
+	return nil
+}
+
+// shortDuration formats d with second precision, e.g. 1h2m3s.
+func shortDuration(d time.Duration) string {
+	return d.Truncate(time.Second).String()
+}
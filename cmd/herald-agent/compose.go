@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/elabx-org/herald/internal/compose"
+	"github.com/elabx-org/herald/internal/fsutil"
+)
+
+var (
+	flagCompose       string
+	flagComposeOutput string
+	flagOutDir        string
+)
+
+type composeSyncResponse struct {
+	Resolved   int                 `json:"resolved"`
+	CacheHits  int                 `json:"cache_hits"`
+	StaleHits  int                 `json:"stale_hits"`
+	Failed     int                 `json:"failed"`
+	DurationMs int64               `json:"duration_ms"`
+	Services   map[string][]string `json:"services"`
+	Override   string              `json:"override"`
+	EnvFiles   map[string]string   `json:"env_files"`
+	Refs       []refReport         `json:"refs"`
+}
+
+// formatExt is the file extension of per-service env files in each format.
+var formatExt = map[string]string{
+	"env":     ".env",
+	"json":    ".json",
+	"yaml":    ".yaml",
+	"shell":   ".sh",
+	"systemd": ".env",
+	"k8s":     ".yaml",
+	"docker":  ".env",
+}
+
+// runComposeSync reads a compose file and the env_files it references (paths
+// relative to the compose file's directory), asks Herald to resolve every
+// op:// ref, and writes either a compose override or per-service env files.
+func runComposeSync() error {
+	if flagComposeOutput != "override" && flagComposeOutput != "env_files" {
+		return fmt.Errorf("--compose-output must be 'override' or 'env_files'")
+	}
+	if flagComposeOutput == "env_files" && flagOutDir == "" && !flagDryRun {
+		return fmt.Errorf("--out-dir is required with --compose-output=env_files")
+	}
+
+	data, err := os.ReadFile(flagCompose)
+	if err != nil {
+		return fmt.Errorf("read compose file: %w", err)
+	}
+	file, err := compose.Parse(data)
+	if err != nil {
+		return err
+	}
+
+	baseDir := filepath.Dir(flagCompose)
+	envFiles := make(map[string]string)
+	for _, ef := range file.EnvFilePaths() {
+		p := ef.Path
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(baseDir, p)
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			if os.IsNotExist(err) && !ef.Required {
+				continue
+			}
+			return fmt.Errorf("read env_file %s: %w", ef.Path, err)
+		}
+		envFiles[ef.Path] = string(content)
+	}
+
+	payload := map[string]interface{}{
+		"stack":           flagStack,
+		"compose_content": string(data),
+		"env_files":       envFiles,
+		"output":          flagComposeOutput,
+		"bypass_cache":    true,
+		"mode":            flagMode,
+		"format":          flagFormat,
+	}
+
+	var resp composeSyncResponse
+	err = withRetries(func() error {
+		return postJSON("/v1/materialize/compose", payload, &resp)
+	})
+	printRefReport(resp.Refs)
+	if err != nil {
+		return err
+	}
+
+	if flagDryRun {
+		fmt.Fprintf(os.Stderr, "herald-agent: dry run — services=%d resolved=%d cache_hits=%d stale_hits=%d failed=%d duration_ms=%d\n",
+			len(resp.Services), resp.Resolved, resp.CacheHits, resp.StaleHits, resp.Failed, resp.DurationMs)
+	}
+	if err := checkFailed(resp.Failed); err != nil {
+		return err
+	}
+	if flagDryRun {
+		return nil
+	}
+
+	if flagComposeOutput == "env_files" {
+		services := make([]string, 0, len(resp.EnvFiles))
+		for svc := range resp.EnvFiles {
+			services = append(services, svc)
+		}
+		sort.Strings(services)
+		for _, svc := range services {
+			path := filepath.Join(flagOutDir, svc+formatExt[flagFormat])
+			if err := fsutil.WriteFileAtomic(path, []byte(resp.EnvFiles[svc]), 0600, -1, -1); err != nil {
+				return fmt.Errorf("write env file for service %s: %w", svc, err)
+			}
+			fmt.Fprintf(os.Stderr, "herald-agent: %s secrets written to %s\n", svc, path)
+		}
+		return nil
+	}
+
+	if flagOut == "-" {
+		fmt.Print(resp.Override)
+		return nil
+	}
+	if err := fsutil.WriteFileAtomic(flagOut, []byte(resp.Override), 0600, -1, -1); err != nil {
+		return fmt.Errorf("write compose override: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "herald-agent: compose override written to %s\n", flagOut)
+	return nil
+}
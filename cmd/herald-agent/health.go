@@ -19,6 +19,7 @@ var healthCmd = &cobra.Command{
 func init() {
 	healthCmd.Flags().StringVar(&flagURL, "url", envOrDefault("HERALD_URL", "http://herald:8765"), "Herald service URL")
 	healthCmd.Flags().StringVar(&flagToken, "token", os.Getenv("HERALD_API_TOKEN"), "Herald API bearer token")
+	addTLSFlags(healthCmd)
 	rootCmd.AddCommand(healthCmd)
 }
 
@@ -46,7 +47,10 @@ func runHealth(cmd *cobra.Command, args []string) error {
 		req.Header.Set("Authorization", "Bearer "+flagToken)
 	}
 
-	client := &http.Client{Timeout: 10 * time.Second}
+	client, err := newClient(10 * time.Second)
+	if err != nil {
+		return err
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "herald-agent: cannot reach herald: %v\n", err)
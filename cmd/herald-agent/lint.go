@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/elabx-org/herald/internal/lint"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagLintFormat string
+	flagLintStrict bool
+)
+
+var lintCmd = &cobra.Command{
+	Use:   "lint [file...]",
+	Short: "Scan env files for plaintext secrets and malformed op:// refs (exits 1 on findings)",
+	Long: `Scan env files locally for likely plaintext secrets — known token prefixes
+(ghp_, sk-, xoxb-, ...), private key headers, URLs with literal passwords and
+high-entropy values — and for op:// refs that Herald would silently skip.
+
+No Herald server is required, so this is suitable as a pre-commit hook or CI gate.
+Use - to read from stdin. Exits 1 if any error-severity finding is reported
+(or any finding at all with --strict).`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runLint,
+}
+
+func init() {
+	lintCmd.Flags().StringVar(&flagLintFormat, "format", "text", "Output format: text or json")
+	lintCmd.Flags().BoolVar(&flagLintStrict, "strict", false, "Fail on warnings as well as errors")
+	rootCmd.AddCommand(lintCmd)
+}
+
+type lintFileResult struct {
+	Path     string         `json:"path"`
+	Findings []lint.Finding `json:"findings"`
+}
+
+func runLint(cmd *cobra.Command, args []string) error {
+	if flagLintFormat != "text" && flagLintFormat != "json" {
+		return fmt.Errorf("--format must be 'text' or 'json'")
+	}
+
+	var results []lintFileResult
+	total, errCount := 0, 0
+	for _, path := range args {
+		content, err := readEnvContent(path)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", path, err)
+		}
+		findings, err := lint.Scan(strings.NewReader(content))
+		if err != nil {
+			return fmt.Errorf("scan %s: %w", path, err)
+		}
+		if findings == nil {
+			findings = []lint.Finding{}
+		}
+		for _, f := range findings {
+			if f.Severity == lint.SeverityError {
+				errCount++
+			}
+		}
+		total += len(findings)
+		results = append(results, lintFileResult{Path: path, Findings: findings})
+	}
+
+	if flagLintFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(map[string]interface{}{"files": results, "count": total, "errors": errCount}); err != nil {
+			return err
+		}
+	} else {
+		for _, r := range results {
+			for _, f := range r.Findings {
+				key := ""
+				if f.Key != "" {
+					key = " " + f.Key + ":"
+				}
+				fmt.Printf("%s:%d: %s [%s]%s %s\n", r.Path, f.Line, f.Severity, f.Rule, key, f.Message)
+			}
+		}
+	}
+
+	if errCount > 0 || (flagLintStrict && total > 0) {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("lint: %d finding(s), %d error(s)", total, errCount)
+	}
+	return nil
+}
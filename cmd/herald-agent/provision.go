@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -38,6 +39,7 @@ func init() {
 	provisionCmd.Flags().StringArrayVar(&flagProvFields, "field", nil, "Field spec: name[:value=VALUE][:concealed]")
 	provisionCmd.Flags().StringVar(&flagURL, "url", envOrDefault("HERALD_URL", "http://herald:8765"), "Herald service URL")
 	provisionCmd.Flags().StringVar(&flagToken, "token", "", "Herald API bearer token")
+	addTLSFlags(provisionCmd)
 	provisionCmd.MarkFlagRequired("vault")
 	provisionCmd.MarkFlagRequired("item")
 	provisionCmd.MarkFlagRequired("field")
@@ -89,7 +91,10 @@ func runProvision(cmd *cobra.Command, args []string) error {
 		req.Header.Set("Authorization", "Bearer "+flagToken)
 	}
 
-	client := &http.Client{Timeout: 90 * time.Second}
+	client, err := newClient(90 * time.Second)
+	if err != nil {
+		return err
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("connect to herald: %w", err)
@@ -97,6 +102,10 @@ func runProvision(cmd *cobra.Command, args []string) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if e := decodeError(data, nil); e.Error != "" {
+			return fmt.Errorf("herald returned HTTP %d: %s", resp.StatusCode, e.Error)
+		}
 		return fmt.Errorf("herald returned HTTP %d", resp.StatusCode)
 	}
 
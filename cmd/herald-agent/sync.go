@@ -8,8 +8,10 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/elabx-org/herald/internal/fsutil"
 	"github.com/spf13/cobra"
 )
 
@@ -21,6 +23,13 @@ var (
 	flagRetries int
 	flagEnvFile string
 	flagDryRun  bool
+	flagMode    string
+	flagFormat  string
+
+	flagSecretsDir  string
+	flagSecretsUID  int
+	flagSecretsGID  int
+	flagSecretsMode string
 )
 
 var syncCmd = &cobra.Command{
@@ -34,9 +43,19 @@ func init() {
 	syncCmd.Flags().StringVar(&flagOut, "out", "-", "Output path: '-' prints to stdout, or an absolute file path")
 	syncCmd.Flags().StringVar(&flagURL, "url", envOrDefault("HERALD_URL", "http://herald:8765"), "Herald service URL")
 	syncCmd.Flags().StringVar(&flagToken, "token", os.Getenv("HERALD_API_TOKEN"), "Herald API bearer token")
+	addTLSFlags(syncCmd)
 	syncCmd.Flags().IntVar(&flagRetries, "retries", 3, "Number of retries on failure")
 	syncCmd.Flags().StringVar(&flagEnvFile, "env-file", "", "Path to env file to scan for op:// refs (use - for stdin)")
 	syncCmd.Flags().BoolVar(&flagDryRun, "dry-run", false, "Resolve secrets and report stats without writing output")
+	syncCmd.Flags().StringVar(&flagMode, "mode", "strict", "Failure mode: 'strict' fails on any unresolved ref; 'lenient' writes what resolved and exits 0")
+	syncCmd.Flags().StringVar(&flagFormat, "format", "env", "Output format: env, json, yaml, shell, systemd, k8s or docker")
+	syncCmd.Flags().StringVar(&flagSecretsDir, "secrets-dir", "", "Write each secret to its own file in this directory (on the Herald host) and output *_FILE references instead of values")
+	syncCmd.Flags().IntVar(&flagSecretsUID, "secrets-uid", -1, "Owner uid for files in --secrets-dir (-1 leaves unchanged)")
+	syncCmd.Flags().IntVar(&flagSecretsGID, "secrets-gid", -1, "Group gid for files in --secrets-dir (-1 leaves unchanged)")
+	syncCmd.Flags().StringVar(&flagSecretsMode, "secrets-mode", "0400", "Octal permission for files in --secrets-dir")
+	syncCmd.Flags().StringVar(&flagCompose, "compose", "", "Path to a compose.yaml to scan for op:// refs in environment, env_file and x-herald blocks")
+	syncCmd.Flags().StringVar(&flagComposeOutput, "compose-output", "override", "Compose output: 'override' (compose override file) or 'env_files' (one env file per service)")
+	syncCmd.Flags().StringVar(&flagOutDir, "out-dir", "", "Directory for per-service env files (<service>.env, or the --format extension) when --compose-output=env_files")
 	syncCmd.MarkFlagRequired("stack")
 	rootCmd.AddCommand(syncCmd)
 }
@@ -49,60 +68,71 @@ func envOrDefault(key, def string) string {
 }
 
 func runSync(cmd *cobra.Command, args []string) error {
+	if flagMode != "strict" && flagMode != "lenient" {
+		return fmt.Errorf("--mode must be 'strict' or 'lenient'")
+	}
+	if flagCompose != "" {
+		if flagSecretsDir != "" {
+			return fmt.Errorf("--secrets-dir cannot be combined with --compose")
+		}
+		if flagFormat != "env" && flagComposeOutput != "env_files" {
+			return fmt.Errorf("--format needs --compose-output=env_files when combined with --compose")
+		}
+		return runComposeSync()
+	}
+
 	envContent, err := readEnvContent(flagEnvFile)
 	if err != nil {
 		return fmt.Errorf("read env file: %w", err)
 	}
 
-	outPath := flagOut
-	if outPath == "-" || flagDryRun {
-		outPath = ""
-	}
-
 	payload := map[string]interface{}{
 		"stack":        flagStack,
-		"out_path":     outPath,
 		"env_content":  envContent,
 		"bypass_cache": true,
+		"mode":         flagMode,
+		"format":       flagFormat,
 	}
-
-	var lastErr error
-	var resp syncResponse
-	for attempt := 0; attempt <= flagRetries; attempt++ {
-		if attempt > 0 {
-			fmt.Fprintf(os.Stderr, "herald-agent: retry %d/%d after error: %v\n", attempt, flagRetries, lastErr)
-			time.Sleep(time.Duration(attempt*2) * time.Second)
+	if flagSecretsDir != "" {
+		payload["secrets_dir"] = flagSecretsDir
+		payload["secrets_mode"] = flagSecretsMode
+		if flagSecretsUID >= 0 {
+			payload["secrets_uid"] = flagSecretsUID
 		}
-
-		resp, lastErr = doSync(payload)
-		if lastErr == nil {
-			break
-		}
-		var permErr *permanentError
-		if errors.As(lastErr, &permErr) {
-			fmt.Fprintf(os.Stderr, "herald-agent: permanent error (no retry): %v\n", lastErr)
-			break
+		if flagSecretsGID >= 0 {
+			payload["secrets_gid"] = flagSecretsGID
 		}
 	}
-	if lastErr != nil {
-		fmt.Fprintf(os.Stderr, "herald-agent: failed after %d retries: %v\n", flagRetries, lastErr)
-		return lastErr
+
+	var resp syncResponse
+	err = withRetries(func() error {
+		var err error
+		resp, err = doSync(payload)
+		return err
+	})
+	printRefReport(resp.Refs)
+	if err != nil {
+		return err
 	}
 
 	if flagDryRun {
 		fmt.Fprintf(os.Stderr, "herald-agent: dry run — resolved=%d cache_hits=%d stale_hits=%d failed=%d duration_ms=%d\n",
 			resp.Resolved, resp.CacheHits, resp.StaleHits, resp.Failed, resp.DurationMs)
-		if resp.Failed > 0 {
-			fmt.Fprintf(os.Stderr, "herald-agent: WARNING: %d secret(s) failed to resolve\n", resp.Failed)
-			return fmt.Errorf("%d secret(s) failed to resolve", resp.Failed)
-		}
+	}
+	if err := checkFailed(resp.Failed); err != nil {
+		return err
+	}
+	if flagDryRun {
 		return nil
 	}
 
+	for _, f := range resp.SecretFiles {
+		fmt.Fprintf(os.Stderr, "herald-agent: %s written to %s\n", f.Key, f.Path)
+	}
 	if flagOut == "-" {
 		fmt.Print(resp.Content)
 	} else {
-		if err := os.WriteFile(flagOut, []byte(resp.Content), 0600); err != nil {
+		if err := fsutil.WriteFileAtomic(flagOut, []byte(resp.Content), 0600, -1, -1); err != nil {
 			return fmt.Errorf("write output file: %w", err)
 		}
 		fmt.Fprintf(os.Stderr, "herald-agent: secrets written to %s\n", flagOut)
@@ -110,6 +140,30 @@ func runSync(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// withRetries calls fn up to flagRetries+1 times with linear backoff, stopping
+// early on success or a permanentError.
+func withRetries(fn func() error) error {
+	var lastErr error
+	for attempt := 0; attempt <= flagRetries; attempt++ {
+		if attempt > 0 {
+			fmt.Fprintf(os.Stderr, "herald-agent: retry %d/%d after error: %v\n", attempt, flagRetries, lastErr)
+			time.Sleep(time.Duration(attempt*2) * time.Second)
+		}
+
+		lastErr = fn()
+		if lastErr == nil {
+			return nil
+		}
+		var permErr *permanentError
+		if errors.As(lastErr, &permErr) {
+			fmt.Fprintf(os.Stderr, "herald-agent: permanent error (no retry): %v\n", lastErr)
+			break
+		}
+	}
+	fmt.Fprintf(os.Stderr, "herald-agent: failed after %d retries: %v\n", flagRetries, lastErr)
+	return lastErr
+}
+
 // readEnvContent reads env file content from a path, stdin ("-"), or returns empty string.
 func readEnvContent(path string) (string, error) {
 	if path == "" {
@@ -129,15 +183,63 @@ func readEnvContent(path string) (string, error) {
 }
 
 type syncResponse struct {
-	Content    string `json:"content"`
-	Resolved   int    `json:"resolved"`
-	CacheHits  int    `json:"cache_hits"`
-	StaleHits  int    `json:"stale_hits"`
-	Failed     int    `json:"failed"`
-	DurationMs int64  `json:"duration_ms"`
+	Content    string      `json:"content"`
+	Resolved   int         `json:"resolved"`
+	CacheHits  int         `json:"cache_hits"`
+	StaleHits  int         `json:"stale_hits"`
+	Failed     int         `json:"failed"`
+	DurationMs int64       `json:"duration_ms"`
+	Refs       []refReport `json:"refs"`
+
+	SecretFiles []struct {
+		Key  string `json:"key"`
+		Path string `json:"path"`
+	} `json:"secret_files"`
+}
+
+// refReport mirrors materialize.RefReport: how each op:// ref was served.
+type refReport struct {
+	Ref        string   `json:"ref"`
+	Keys       []string `json:"keys"`
+	Outcome    string   `json:"outcome"`
+	Provider   string   `json:"provider"`
+	ErrorClass string   `json:"error_class"`
+	Error      string   `json:"error"`
+}
+
+// printRefReport writes one line per ref to stderr. Values are never included.
+func printRefReport(refs []refReport) {
+	if len(refs) == 0 {
+		return
+	}
+	fmt.Fprintln(os.Stderr, "herald-agent: ref report:")
+	for _, r := range refs {
+		line := fmt.Sprintf("  %-8s  %-30s  %s", r.Outcome, strings.Join(r.Keys, ","), r.Ref)
+		if r.Provider != "" {
+			line += "  (" + r.Provider + ")"
+		}
+		if r.ErrorClass != "" {
+			line += "  [" + r.ErrorClass + "] " + r.Error
+		}
+		fmt.Fprintln(os.Stderr, line)
+	}
 }
 
-// permanentError wraps errors that should not be retried (e.g. 4xx responses).
+// checkFailed applies --mode to a response's failure count: strict turns any
+// failure into an error, lenient only warns.
+func checkFailed(failed int) error {
+	if failed == 0 {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "herald-agent: WARNING: %d secret(s) failed to resolve\n", failed)
+	if flagMode == "lenient" {
+		return nil
+	}
+	return fmt.Errorf("%d secret(s) failed to resolve", failed)
+}
+
+// permanentError wraps errors that should not be retried, as decided by the
+// response's error code.
 type permanentError struct {
 	err error
 }
@@ -145,38 +247,118 @@ type permanentError struct {
 func (e *permanentError) Error() string { return e.err.Error() }
 func (e *permanentError) Unwrap() error { return e.err }
 
+// doSync calls /v1/materialize/env. The response is returned even on error,
+// since a failed strict materialization still carries the per-ref report.
 func doSync(payload map[string]interface{}) (syncResponse, error) {
+	var sr syncResponse
+	err := postJSON("/v1/materialize/env", payload, &sr)
+	return sr, err
+}
+
+// postJSON POSTs payload to a Herald endpoint and decodes the JSON response
+// into out. Error responses are decoded into out too when they are JSON, so
+// callers can surface structured details. Errors whose code is not
+// retryable are returned as permanentError.
+func postJSON(path string, payload interface{}, out interface{}) error {
 	body, err := json.Marshal(payload)
 	if err != nil {
-		return syncResponse{}, fmt.Errorf("marshal payload: %w", err)
+		return fmt.Errorf("marshal payload: %w", err)
 	}
-	req, err := http.NewRequest(http.MethodPost, flagURL+"/v1/materialize/env", bytes.NewReader(body))
+	req, err := http.NewRequest(http.MethodPost, flagURL+path, bytes.NewReader(body))
 	if err != nil {
-		return syncResponse{}, err
+		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	return doJSON(req, out)
+}
+
+// getJSON GETs a Herald endpoint and decodes the JSON response into out, with
+// the same error handling as postJSON.
+func getJSON(path string, out interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, flagURL+path, nil)
+	if err != nil {
+		return err
+	}
+	return doJSON(req, out)
+}
+
+// deleteJSON DELETEs a Herald endpoint and decodes the JSON response into
+// out, with the same error handling as postJSON.
+func deleteJSON(path string, out interface{}) error {
+	req, err := http.NewRequest(http.MethodDelete, flagURL+path, nil)
+	if err != nil {
+		return err
+	}
+	return doJSON(req, out)
+}
+
+func doJSON(req *http.Request, out interface{}) error {
 	if flagToken != "" {
 		req.Header.Set("Authorization", "Bearer "+flagToken)
 	}
 
-	client := &http.Client{Timeout: 30 * time.Second}
+	client, err := newClient(30 * time.Second)
+	if err != nil {
+		return &permanentError{err: err}
+	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return syncResponse{}, fmt.Errorf("connect to herald: %w", err)
+		return fmt.Errorf("connect to herald: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode/100 != 2 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		e := decodeError(data, out)
 		err := fmt.Errorf("herald returned HTTP %d", resp.StatusCode)
-		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
-			return syncResponse{}, &permanentError{err: err}
+		switch {
+		case e.Code != "" && e.RequestID != "":
+			err = fmt.Errorf("herald returned HTTP %d (%s, request %s): %s", resp.StatusCode, e.Code, e.RequestID, e.Error)
+		case e.Code != "":
+			err = fmt.Errorf("herald returned HTTP %d (%s): %s", resp.StatusCode, e.Code, e.Error)
+		case e.Error != "":
+			err = fmt.Errorf("herald returned HTTP %d: %s", resp.StatusCode, e.Error)
+		}
+		if !e.retryable(resp.StatusCode) {
+			return &permanentError{err: err}
 		}
-		return syncResponse{}, err
+		return err
 	}
 
-	var sr syncResponse
-	if err := json.NewDecoder(resp.Body).Decode(&sr); err != nil {
-		return syncResponse{}, fmt.Errorf("decode response: %w", err)
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// apiError is Herald's JSON error envelope.
+type apiError struct {
+	Error     string `json:"error"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id"`
+	Retryable bool   `json:"retryable"`
+}
+
+// retryable reports whether the request may succeed if repeated, as the
+// server says in the envelope. Responses without an envelope (older servers,
+// proxies) fall back to the status: only 5xx is retried.
+func (e apiError) retryable(status int) bool {
+	if e.Code != "" {
+		return e.Retryable
+	}
+	return status >= 500
+}
+
+// decodeError extracts the error envelope from an error response body. JSON
+// bodies are also decoded into a non-nil out (best effort); other bodies
+// become the message, trimmed.
+func decodeError(data []byte, out interface{}) apiError {
+	var e apiError
+	if json.Unmarshal(data, &e) == nil {
+		if out != nil {
+			json.Unmarshal(data, out)
+		}
+		return e
 	}
-	return sr, nil
+	return apiError{Error: strings.TrimSpace(string(data))}
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagCA   string
+	flagCert string
+	flagKey  string
+)
+
+// addTLSFlags registers the TLS flags on a command that talks to Herald.
+func addTLSFlags(c *cobra.Command) {
+	c.Flags().StringVar(&flagCA, "ca", os.Getenv("HERALD_CA"), "CA certificate (PEM) to verify Herald's TLS certificate (default: system roots)")
+	c.Flags().StringVar(&flagCert, "cert", os.Getenv("HERALD_CERT"), "Client certificate (PEM) for mutual TLS")
+	c.Flags().StringVar(&flagKey, "key", os.Getenv("HERALD_KEY"), "Client private key (PEM) for mutual TLS")
+}
+
+// newClient returns an HTTP client for Herald configured from the TLS flags.
+func newClient(timeout time.Duration) (*http.Client, error) {
+	if flagCA == "" && flagCert == "" && flagKey == "" {
+		return &http.Client{Timeout: timeout}, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if flagCA != "" {
+		data, err := os.ReadFile(flagCA)
+		if err != nil {
+			return nil, fmt.Errorf("read --ca: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("--ca %s contains no PEM certificates", flagCA)
+		}
+	}
+	if (flagCert == "") != (flagKey == "") {
+		return nil, errors.New("--cert and --key must be given together")
+	}
+	if flagCert != "" {
+		cert, err := tls.LoadX509KeyPair(flagCert, flagKey)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
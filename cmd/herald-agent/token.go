@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagTokenRoles  []string
+	flagTokenStacks []string
+	flagTokenVaults []string
+)
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage named Herald API tokens (requires an admin token)",
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create NAME",
+	Short: "Create a named token and print its secret (shown once)",
+	Long: `Create a named API token. Roles: materialize, provision, rotate, admin,
+read-only (admin implies all others). --stack and --vault take glob patterns
+and restrict the token to matching stacks and vaults; without them the token
+covers every stack and vault.`,
+	Example: `  herald-agent token create deploy-web --role materialize --stack 'web-*' --vault HomeLab`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    runTokenCreate,
+}
+
+var tokenLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List named tokens (secrets are never shown)",
+	Args:  cobra.NoArgs,
+	RunE:  runTokenLs,
+}
+
+var tokenRevokeCmd = &cobra.Command{
+	Use:   "revoke NAME",
+	Short: "Revoke a named token immediately",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runTokenRevoke,
+}
+
+func init() {
+	tokenCreateCmd.Flags().StringSliceVar(&flagTokenRoles, "role", nil, "Role to grant (repeatable)")
+	tokenCreateCmd.Flags().StringSliceVar(&flagTokenStacks, "stack", nil, "Allowed stack glob (repeatable; default all)")
+	tokenCreateCmd.Flags().StringSliceVar(&flagTokenVaults, "vault", nil, "Allowed vault glob (repeatable; default all)")
+	tokenCreateCmd.MarkFlagRequired("role")
+	for _, c := range []*cobra.Command{tokenCreateCmd, tokenLsCmd, tokenRevokeCmd} {
+		c.Flags().StringVar(&flagURL, "url", envOrDefault("HERALD_URL", "http://herald:8765"), "Herald service URL")
+		c.Flags().StringVar(&flagToken, "token", os.Getenv("HERALD_API_TOKEN"), "Herald API bearer token (admin)")
+		addTLSFlags(c)
+		tokenCmd.AddCommand(c)
+	}
+	rootCmd.AddCommand(tokenCmd)
+}
+
+type apiToken struct {
+	Name      string    `json:"name"`
+	Roles     []string  `json:"roles"`
+	Stacks    []string  `json:"stacks,omitempty"`
+	Vaults    []string  `json:"vaults,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Token     string    `json:"token,omitempty"`
+}
+
+func runTokenCreate(cmd *cobra.Command, args []string) error {
+	var t apiToken
+	err := postJSON("/v1/tokens", map[string]interface{}{
+		"name":   args[0],
+		"roles":  flagTokenRoles,
+		"stacks": flagTokenStacks,
+		"vaults": flagTokenVaults,
+	}, &t)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "herald-agent: created token %s — store it now, it cannot be shown again\n", t.Name)
+	fmt.Println(t.Token)
+	return nil
+}
+
+func runTokenLs(cmd *cobra.Command, args []string) error {
+	var resp struct {
+		Tokens []apiToken `json:"tokens"`
+	}
+	if err := getJSON("/v1/tokens", &resp); err != nil {
+		return err
+	}
+	if len(resp.Tokens) == 0 {
+		fmt.Println("no named tokens")
+		return nil
+	}
+	fmt.Printf("%-24s  %-30s  %-20s  %-20s  %s\n", "NAME", "ROLES", "STACKS", "VAULTS", "CREATED")
+	for _, t := range resp.Tokens {
+		fmt.Printf("%-24s  %-30s  %-20s  %-20s  %s\n", t.Name, strings.Join(t.Roles, ","),
+			scopeList(t.Stacks), scopeList(t.Vaults), t.CreatedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func runTokenRevoke(cmd *cobra.Command, args []string) error {
+	var resp map[string]interface{}
+	if err := deleteJSON("/v1/tokens/"+url.PathEscape(args[0]), &resp); err != nil {
+		return err
+	}
+	fmt.Printf("revoked token %s\n", args[0])
+	return nil
+}
+
+// scopeList formats a token's stack or vault globs; none means unrestricted.
+func scopeList(globs []string) string {
+	if len(globs) == 0 {
+		return "*"
+	}
+	return strings.Join(globs, ",")
+}
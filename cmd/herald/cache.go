@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/elabx-org/herald/internal/cache"
+	"github.com/elabx-org/herald/internal/config"
+	"github.com/elabx-org/herald/internal/kek"
+	"github.com/elabx-org/herald/internal/provider"
+	"github.com/elabx-org/herald/internal/resolver"
+)
+
+// cacheEnabled reports whether a passphrase or KEK is configured.
+func cacheEnabled(cfg *config.Config) bool {
+	return cfg.Cache.EncryptionKey != "" || cfg.Cache.KEK.Type != ""
+}
+
+// loadKEK returns the configured key-encryption key, or nil when the cache key
+// is derived from HERALD_CACHE_KEY. The returned func releases it.
+func loadKEK(cfg *config.Config, mgr *provider.Manager) (cache.KEK, func(), error) {
+	c := cfg.Cache.KEK
+	switch c.Type {
+	case "":
+		return nil, func() {}, nil
+	case kek.TypeFile:
+		k, err := kek.FromFile(c.File)
+		return k, func() {}, err
+	case kek.TypeOnePassword:
+		ref, err := resolver.ParseOpURI(c.OpRef)
+		if err != nil {
+			return nil, nil, fmt.Errorf("kek: %w", err)
+		}
+		if mgr == nil {
+			return nil, nil, fmt.Errorf("kek: no providers to resolve %s", c.OpRef)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		value, _, err := mgr.Resolve(ctx, ref.Vault, ref.Item, ref.Field)
+		if err != nil {
+			return nil, nil, fmt.Errorf("kek: resolve %s: %w", c.OpRef, err)
+		}
+		k, err := kek.FromSecret(c.OpRef, value)
+		return k, func() {}, err
+	case kek.TypePKCS11:
+		k, err := kek.OpenPKCS11(kek.PKCS11Config{
+			Module:     c.PKCS11.Module,
+			TokenLabel: c.PKCS11.TokenLabel,
+			KeyLabel:   c.PKCS11.KeyLabel,
+			PIN:        c.PKCS11.PIN,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return k, func() { k.Close() }, nil
+	default:
+		return nil, nil, fmt.Errorf("kek: unknown type %q (want file, pkcs11 or 1password)", c.Type)
+	}
+}
+
+// openStore opens the persistent cache: envelope-encrypted under k when set,
+// otherwise keyed by HERALD_CACHE_KEY. With a KEK, HERALD_CACHE_KEY and the
+// previous keys still unlock entries written in passphrase mode.
+func openStore(cfg *config.Config, k cache.KEK) (*cache.Store, error) {
+	if k == nil {
+		return cache.New(cfg.Cache.DataPath, cfg.Cache.EncryptionKey, cfg.Cache.PreviousKeys...)
+	}
+	var previous []string
+	if cfg.Cache.EncryptionKey != "" {
+		previous = append(previous, cfg.Cache.EncryptionKey)
+	}
+	return cache.NewWithKEK(cfg.Cache.DataPath, k, append(previous, cfg.Cache.PreviousKeys...)...)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/elabx-org/herald/internal/cache"
+	"github.com/elabx-org/herald/internal/config"
+	"github.com/elabx-org/herald/internal/kek"
+	"github.com/elabx-org/herald/internal/provider"
+)
+
+// runCommand handles maintenance subcommands (`herald <command> ...`). They
+// run instead of the server; commands that open the cache need the server
+// stopped, since bbolt allows a single process at a time.
+func runCommand(cfg *config.Config, args []string) error {
+	switch args[0] {
+	case "cache":
+		return runCache(cfg, args[1:])
+	case "snapshot":
+		return runSnapshot(cfg, args[1:])
+	case "pki":
+		return runPKI(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q (available: cache rekey, snapshot restore, pki init, pki client)", args[0])
+	}
+}
+
+func runCache(cfg *config.Config, args []string) error {
+	if len(args) == 0 || args[0] != "rekey" {
+		return errors.New("usage: herald cache rekey [--new-key-file path]")
+	}
+
+	fs := flag.NewFlagSet("cache rekey", flag.ContinueOnError)
+	keyFile := fs.String("new-key-file", "", "file containing the new passphrase (default: $HERALD_CACHE_NEW_KEY)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	newKey := os.Getenv("HERALD_CACHE_NEW_KEY")
+	if *keyFile != "" {
+		data, err := os.ReadFile(*keyFile)
+		if err != nil {
+			return err
+		}
+		newKey = strings.TrimSpace(string(data))
+	}
+	if !cacheEnabled(cfg) {
+		return errors.New("neither HERALD_CACHE_KEY nor cache.kek set — nothing to rekey")
+	}
+
+	k, closeKEK, err := commandKEK(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeKEK()
+	store, err := openStore(cfg, k)
+	if err != nil {
+		return fmt.Errorf("open cache %s: %w", cfg.Cache.DataPath, err)
+	}
+	defer store.Close()
+	// Directory-tier entries are re-encrypted too; a tier left out would be
+	// undecryptable under the new key.
+	for policy, dir := range map[string]string{cache.PolicyTmpfs: cfg.Cache.TmpfsPath, cache.PolicyFile: cfg.Cache.FilePath} {
+		if dir == "" {
+			continue
+		}
+		if err := store.SetDir(policy, dir); err != nil {
+			return fmt.Errorf("%s cache directory %s: %w", policy, dir, err)
+		}
+	}
+
+	// Without a new passphrase, rotate to a fresh data key under the current
+	// passphrase or KEK; this also moves passphrase-mode entries under a
+	// newly configured KEK.
+	var res cache.RekeyResult
+	if newKey != "" {
+		res, err = store.Rekey(newKey)
+	} else {
+		res, err = store.RotateDataKey()
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("cache rekeyed to key version %d: %d entries re-encrypted, %d undecryptable entries dropped\n",
+		res.Version, res.Reencrypted, res.Dropped)
+	if newKey != "" {
+		fmt.Println("set HERALD_CACHE_KEY to the new passphrase before starting herald")
+	}
+	return nil
+}
+
+func runSnapshot(cfg *config.Config, args []string) error {
+	if len(args) != 2 || args[0] != "restore" {
+		return errors.New("usage: herald snapshot restore <file>")
+	}
+	if !cacheEnabled(cfg) {
+		return errors.New("neither HERALD_CACHE_KEY nor cache.kek set — cannot decrypt the snapshot")
+	}
+	f, err := os.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	k, closeKEK, err := commandKEK(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeKEK()
+	_, statErr := os.Stat(cfg.Cache.DataPath)
+	var info cache.SnapshotInfo
+	if k == nil {
+		info, err = cache.Restore(cfg.Cache.DataPath, f, cfg.Cache.EncryptionKey, cfg.Cache.PreviousKeys...)
+	} else {
+		var previous []string
+		if cfg.Cache.EncryptionKey != "" {
+			previous = append(previous, cfg.Cache.EncryptionKey)
+		}
+		info, err = cache.RestoreWithKEK(cfg.Cache.DataPath, f, k, append(previous, cfg.Cache.PreviousKeys...)...)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %s from snapshot taken %s (%d bytes, key version %d)\n",
+		cfg.Cache.DataPath, info.CreatedAt.Format(time.RFC3339), info.Size, info.KeyVersion)
+	if statErr == nil {
+		fmt.Printf("previous file kept as %s.pre-restore\n", cfg.Cache.DataPath)
+	}
+	return nil
+}
+
+// commandKEK loads the configured KEK for a maintenance command, connecting
+// to the providers first when it lives in 1Password.
+func commandKEK(cfg *config.Config) (cache.KEK, func(), error) {
+	var mgr *provider.Manager
+	if cfg.Cache.KEK.Type == kek.TypeOnePassword {
+		var err error
+		if mgr, err = provider.FromConfig(cfg.Providers); err != nil {
+			return nil, nil, err
+		}
+	}
+	return loadKEK(cfg, mgr)
+}
@@ -11,7 +11,10 @@ import (
 	"github.com/elabx-org/herald/internal/audit"
 	"github.com/elabx-org/herald/internal/cache"
 	"github.com/elabx-org/herald/internal/config"
+	"github.com/elabx-org/herald/internal/jwtauth"
 	"github.com/elabx-org/herald/internal/komodo"
+	"github.com/elabx-org/herald/internal/materialize"
+	"github.com/elabx-org/herald/internal/policy"
 	"github.com/elabx-org/herald/internal/provider"
 	"github.com/elabx-org/herald/internal/provisioner"
 	"github.com/rs/zerolog"
@@ -27,6 +30,13 @@ func main() {
 		log.Fatal().Err(err).Msg("failed to load config")
 	}
 
+	if len(os.Args) > 1 {
+		if err := runCommand(cfg, os.Args[1:]); err != nil {
+			log.Fatal().Err(err).Msg(os.Args[1] + " failed")
+		}
+		return
+	}
+
 	// Startup validation — surface common misconfigurations early
 	if len(cfg.Providers) == 0 {
 		log.Warn().Msg("no secret providers configured — all materialize calls will fail")
@@ -51,18 +61,69 @@ func main() {
 		log.Fatal().Err(err).Msg("failed to create provider manager")
 	}
 
+	if !materialize.ValidStaleMode(cfg.Cache.Stale.Mode) {
+		log.Fatal().Str("mode", cfg.Cache.Stale.Mode).Msg("cache.stale.mode must be rate_limited, unavailable or never")
+	}
+
 	srv := api.NewServer(cfg, mgr)
 
-	if cfg.Cache.EncryptionKey != "" {
-		store, err := cache.New(cfg.Cache.DataPath, cfg.Cache.EncryptionKey)
+	if cfg.JWT.JWKSFile != "" || cfg.JWT.JWKSURL != "" {
+		keys, err := jwtauth.NewKeySet(cfg.JWT.JWKSFile, cfg.JWT.JWKSURL, time.Duration(cfg.JWT.RefreshInterval)*time.Second)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to load JWKS")
+		}
+		if cfg.JWT.Audience == "" {
+			log.Warn().Msg("jwt.audience not set — JWTs issued for other services are accepted")
+		}
+		srv.SetJWTVerifier(jwtauth.NewVerifier(keys, cfg.JWT.Issuer, cfg.JWT.Audience, time.Duration(cfg.JWT.Leeway)*time.Second))
+		log.Info().Str("issuer", cfg.JWT.Issuer).Strs("roles", cfg.JWT.Roles).Msg("jwt workload identity enabled")
+	}
+
+	if cfg.Materialize.AccessPolicy != "" {
+		p, err := policy.Load(cfg.Materialize.AccessPolicy)
 		if err != nil {
-			log.Fatal().Err(err).Str("path", cfg.Cache.DataPath).Msg("failed to initialize cache")
+			log.Fatal().Err(err).Msg("failed to load access policy")
+		}
+		srv.SetAccessPolicy(p)
+		log.Info().Str("path", cfg.Materialize.AccessPolicy).Msg("access policy loaded")
+	}
+
+	var store *cache.Store
+	if cacheEnabled(cfg) {
+		// Fail closed: without the KEK the cache cannot be opened, and only an
+		// explicit opt-in trades persistence for availability.
+		k, closeKEK, err := loadKEK(cfg, mgr)
+		switch {
+		case err != nil && !cfg.Cache.AllowDegraded:
+			log.Fatal().Err(err).Str("type", cfg.Cache.KEK.Type).Msg("cache KEK unavailable — refusing to start (set cache.allow_degraded for memory-only mode)")
+		case err != nil:
+			log.Error().Err(err).Str("type", cfg.Cache.KEK.Type).Msg("cache KEK unavailable — running degraded, memory-only cache")
+			store = cache.NewMemoryOnly()
+		default:
+			defer closeKEK()
+			store, err = openStore(cfg, k)
+			if err != nil {
+				log.Fatal().Err(err).Str("path", cfg.Cache.DataPath).Msg("failed to initialize cache")
+			}
 		}
 		defer store.Close()
+	}
+	if store != nil && !store.MemoryOnly() {
+		for policy, dir := range map[string]string{cache.PolicyTmpfs: cfg.Cache.TmpfsPath, cache.PolicyFile: cfg.Cache.FilePath} {
+			if dir == "" {
+				continue
+			}
+			if err := store.SetDir(policy, dir); err != nil {
+				log.Warn().Err(err).Str("policy", policy).Msg("cache: directory tier unavailable — falling back")
+			}
+		}
+		log.Info().Str("path", cfg.Cache.DataPath).Int("ttl", cfg.Cache.DefaultTTL).Str("kek", cfg.Cache.KEK.Type).Uint32("key_version", store.KeyVersion()).Msg("cache initialized")
+	}
+	if store != nil {
+		store.SetMemoryLimits(cfg.Cache.MemoryMaxEntries, cfg.Cache.MemoryMaxBytes)
 		srv.SetCache(store)
-		log.Info().Str("path", cfg.Cache.DataPath).Int("ttl", cfg.Cache.DefaultTTL).Msg("cache initialized")
 	} else {
-		log.Warn().Msg("HERALD_CACHE_KEY not set — cache disabled, secrets fetched fresh on every request")
+		log.Warn().Msg("neither HERALD_CACHE_KEY nor cache.kek set — cache disabled, secrets fetched fresh on every request")
 	}
 
 	// Wire auditor
@@ -123,7 +184,28 @@ func main() {
 		}()
 	}
 
+	if store != nil && cfg.Cache.SweepInterval > 0 {
+		retain := time.Duration(cfg.Cache.RetainExpired) * time.Second
+		go func() {
+			t := time.NewTicker(time.Duration(cfg.Cache.SweepInterval) * time.Second)
+			defer t.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+					if n := store.Sweep(retain); n > 0 {
+						log.Debug().Int("removed", n).Msg("cache: swept expired entries")
+					}
+				}
+			}
+		}()
+	}
+
 	go srv.StartHealthWatcher(ctx)
+	go srv.StartRefresher(ctx)
+	go srv.WarmUp(ctx)
+	go srv.StartChangeDetector(ctx)
 
 	if err := srv.Start(ctx); err != nil {
 		log.Fatal().Err(err).Msg("server exited with error")
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/elabx-org/herald/internal/pki"
+)
+
+const pkiUsage = "usage: herald pki init [--dir path] [--host name,...] | herald pki client NAME [--dir path]"
+
+// runPKI manages a small local CA for homelab TLS: `init` creates the CA and
+// a server certificate, `client` issues a client certificate for mutual TLS.
+func runPKI(args []string) error {
+	if len(args) == 0 {
+		return errors.New(pkiUsage)
+	}
+	fs := flag.NewFlagSet("pki "+args[0], flag.ContinueOnError)
+	dir := fs.String("dir", "/data/pki", "directory for the CA and issued certificates")
+
+	switch args[0] {
+	case "init":
+		hosts := fs.String("host", "herald,localhost,127.0.0.1", "comma-separated DNS names and IPs for the server certificate")
+		name := fs.String("name", "Herald local CA", "CA common name")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		var sans []string
+		for _, h := range strings.Split(*hosts, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				sans = append(sans, h)
+			}
+		}
+		if err := pki.Init(*dir, *name); err != nil {
+			return err
+		}
+		if err := pki.IssueServer(*dir, "server", sans); err != nil {
+			return err
+		}
+		fmt.Printf("created CA %s and server certificate for %s in %s\n", pki.CACert, strings.Join(sans, ", "), *dir)
+		fmt.Printf("set HERALD_TLS_CERT_FILE=%s HERALD_TLS_KEY_FILE=%s HERALD_TLS_CLIENT_CA_FILE=%s\n",
+			filepath.Join(*dir, "server.crt"), filepath.Join(*dir, "server.key"), filepath.Join(*dir, pki.CACert))
+		fmt.Println("keep ca.key offline if you can; it is only needed to issue certificates")
+		return nil
+	case "client":
+		if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+			return errors.New(pkiUsage)
+		}
+		if err := fs.Parse(args[2:]); err != nil {
+			return err
+		}
+		if err := pki.IssueClient(*dir, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("issued client certificate %s.crt (CN=%s) in %s\n", args[1], args[1], *dir)
+		return nil
+	default:
+		return errors.New(pkiUsage)
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"strconv"
@@ -25,15 +26,26 @@ func (s *Server) handleAuditReal(w http.ResponseWriter, r *http.Request) {
 
 	entries, err := s.auditor.Query(opts)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, err.Error())
 		return
 	}
-	if entries == nil {
-		entries = []audit.Entry{}
+	t := requestToken(r.Context())
+	visible := []audit.Entry{}
+	for _, e := range entries {
+		if e.Stack == "" || t.allowsStack(e.Stack) {
+			visible = append(visible, e)
+		}
 	}
+	entries = visible
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"entries": entries,
 		"count":   len(entries),
 	})
 }
+
+// logAudit records e with the name of the API token behind ctx.
+func (s *Server) logAudit(ctx context.Context, e audit.Entry) {
+	e.Token = tokenName(ctx)
+	s.auditor.Log(e)
+}
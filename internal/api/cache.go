@@ -0,0 +1,167 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elabx-org/herald/internal/cache"
+	"github.com/go-chi/chi/v5"
+	"github.com/gobwas/glob"
+	"github.com/rs/zerolog/log"
+)
+
+type rekeyRequest struct {
+	NewKey string `json:"new_key"`
+}
+
+// handleCacheRekey re-encrypts the cache under a new passphrase, or under a
+// fresh data key from the current passphrase or KEK when new_key is empty.
+// The running server keeps using the new key; HERALD_CACHE_KEY must be
+// updated before the next restart.
+func (s *Server) handleCacheRekey(w http.ResponseWriter, r *http.Request) {
+	if s.cache == nil {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "cache disabled")
+		return
+	}
+	if s.cache.MemoryOnly() {
+		writeError(w, r, http.StatusConflict, CodeConflict, "cache is memory-only")
+		return
+	}
+	var req rekeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "invalid request body")
+		return
+	}
+
+	var res cache.RekeyResult
+	var err error
+	if req.NewKey != "" {
+		res, err = s.cache.Rekey(req.NewKey)
+	} else {
+		res, err = s.cache.RotateDataKey()
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("cache: rekey failed")
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "rekey failed: "+err.Error())
+		return
+	}
+	log.Info().Uint32("key_version", res.Version).Int("reencrypted", res.Reencrypted).Int("dropped", res.Dropped).Msg("cache: rekeyed")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":      "ok",
+		"key_version": res.Version,
+		"reencrypted": res.Reencrypted,
+		"dropped":     res.Dropped,
+	})
+}
+
+// cacheEntryView is a cache entry as listed by GET /v1/cache: metadata and a
+// value fingerprint, never the value itself.
+type cacheEntryView struct {
+	cache.EntryInfo
+	AgeSeconds int64 `json:"age_seconds"`
+}
+
+// handleCacheList lists cache entries with metadata, optionally narrowed to
+// one vault item by GET /v1/cache/{vault}/{item}.
+func (s *Server) handleCacheList(w http.ResponseWriter, r *http.Request) {
+	if s.cache == nil {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "cache disabled")
+		return
+	}
+	var match func(string) bool
+	if vault, item := chi.URLParam(r, "vault"), chi.URLParam(r, "item"); vault != "" {
+		prefix := vault + "/" + item + "/"
+		match = func(k string) bool { return strings.HasPrefix(k, prefix) }
+	}
+
+	t := requestToken(r.Context())
+	entries := []cacheEntryView{}
+	for _, info := range s.cache.List(match) {
+		if vault, _, _ := strings.Cut(info.Key, "/"); !t.allowsVault(vault) {
+			continue
+		}
+		v := cacheEntryView{EntryInfo: info}
+		if !info.CreatedAt.IsZero() {
+			v.AgeSeconds = int64(time.Since(info.CreatedAt).Seconds())
+		}
+		entries = append(entries, v)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": entries,
+		"count":   len(entries),
+	})
+}
+
+// handleCacheInvalidate removes the cache entries whose vault, item and field
+// match the glob patterns in the query (each defaults to *), reports the
+// indexed stacks that reference them and, with redeploy=true, redeploys those
+// stacks.
+func (s *Server) handleCacheInvalidate(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	t := requestToken(r.Context())
+	if vault := q.Get("vault"); t.vaultRestricted() && (vault == "" || glob.QuoteMeta(vault) != vault || !t.allowsVault(vault)) {
+		writeError(w, r, http.StatusForbidden, CodeForbidden, "forbidden: vault-restricted tokens must name an allowed vault literally")
+		return
+	}
+	var globs [3]glob.Glob
+	for i, param := range []string{"vault", "item", "field"} {
+		pattern := q.Get(param)
+		if pattern == "" {
+			pattern = "*"
+		}
+		g, err := glob.Compile(pattern)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeBadRequest, "invalid "+param+" pattern: "+err.Error())
+			return
+		}
+		globs[i] = g
+	}
+	match := func(vault, item, field string) bool {
+		return globs[0].Match(vault) && globs[1].Match(item) && globs[2].Match(field)
+	}
+	// Stack-restricted tokens only drop entries their own stacks reference.
+	if t.stackRestricted() {
+		keys := s.index.CacheKeysFor(t.allowsStack)
+		pattern := match
+		match = func(vault, item, field string) bool {
+			return keys[vault+"/"+item+"/"+field] && pattern(vault, item, field)
+		}
+	}
+
+	deleted := 0
+	if s.cache != nil {
+		deleted = s.cache.InvalidateMatching(match)
+	}
+	stacks := []string{}
+	for _, stack := range s.index.StacksMatching(match) {
+		if t.allowsStack(stack) {
+			stacks = append(stacks, stack)
+		}
+	}
+	s.publish(EventCacheInvalidate, stacks, map[string]interface{}{
+		"scope": "pattern", "vault": q.Get("vault"), "item": q.Get("item"), "field": q.Get("field"), "entries_deleted": deleted,
+	})
+	redeployed := []string{}
+	if q.Get("redeploy") == "true" {
+		secret := fmt.Sprintf("%s/%s/%s", q.Get("vault"), q.Get("item"), q.Get("field"))
+		redeployed = s.redeployStacks(r.Context(), stacks, secret, "cache-invalidate")
+	}
+	log.Info().Str("vault", q.Get("vault")).Str("item", q.Get("item")).Str("field", q.Get("field")).
+		Int("entries_deleted", deleted).Strs("stacks_affected", stacks).Msg("cache: invalidated by pattern")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":            "ok",
+		"entries_deleted":   deleted,
+		"stacks_affected":   stacks,
+		"stacks_redeployed": redeployed,
+	})
+}
@@ -0,0 +1,141 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/elabx-org/herald/internal/api"
+	"github.com/elabx-org/herald/internal/cache"
+	"github.com/elabx-org/herald/internal/config"
+	"github.com/elabx-org/herald/internal/provider"
+)
+
+func TestCacheInvalidatePattern(t *testing.T) {
+	store, err := cache.New(t.TempDir()+"/cache.db", "test-passphrase")
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	defer store.Close()
+
+	cfg := &config.Config{}
+	cfg.Cache.DefaultPolicy = cache.PolicyMemory
+	cfg.Cache.DefaultTTL = 3600
+	srv := api.NewServer(cfg, provider.NewManager([]provider.Provider{&countingProvider{value: "v"}}))
+	srv.SetCache(store)
+
+	for stack, env := range map[string]string{
+		"web":   `A=op://Infra/app-web/password\nB=op://Infra/db/password\n`,
+		"api":   `A=op://Infra/app-api/token\n`,
+		"other": `A=op://HomeLab/app-web/password\n`,
+	} {
+		body := `{"stack":"` + stack + `","env_content":"` + env + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/v1/materialize/env", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		srv.Router().ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("materialize %s status = %d: %s", stack, w.Code, w.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/v1/cache?vault=Infra&item=app-*", nil)
+	w := httptest.NewRecorder()
+	srv.Router().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("DELETE status = %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		EntriesDeleted int      `json:"entries_deleted"`
+		StacksAffected []string `json:"stacks_affected"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.EntriesDeleted != 2 || strings.Join(resp.StacksAffected, ",") != "api,web" {
+		t.Errorf("response = %+v, want 2 entries deleted for stacks api,web", resp)
+	}
+	for key, want := range map[string]bool{
+		"Infra/app-web/password":   false,
+		"Infra/app-api/token":      false,
+		"Infra/db/password":        true,
+		"HomeLab/app-web/password": true,
+	} {
+		if _, err := store.Get(key); (err == nil) != want {
+			t.Errorf("%s cached = %v, want %v", key, err == nil, want)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/v1/cache?item=[", nil)
+	w = httptest.NewRecorder()
+	srv.Router().ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid pattern status = %d, want 400", w.Code)
+	}
+}
+
+func TestStackScopedInvalidation(t *testing.T) {
+	store, err := cache.New(t.TempDir()+"/cache.db", "test-passphrase")
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	defer store.Close()
+
+	cfg := &config.Config{}
+	cfg.APIToken = "master-token"
+	cfg.Cache.DefaultPolicy = cache.PolicyMemory
+	cfg.Cache.DefaultTTL = 3600
+	srv := api.NewServer(cfg, provider.NewManager([]provider.Provider{&countingProvider{value: "v"}}))
+	srv.SetCache(store)
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.Router().ServeHTTP(w, req)
+		return w
+	}
+
+	for stack, env := range map[string]string{
+		"web": `A=op://Infra/web/key\nB=op://Infra/shared/password\n`,
+		"api": `A=op://Infra/api/token\nB=op://Infra/shared/password\n`,
+	} {
+		if w := do(http.MethodPost, "/v1/materialize/env", "master-token", `{"stack":"`+stack+`","env_content":"`+env+`"}`); w.Code != http.StatusOK {
+			t.Fatalf("materialize %s status = %d: %s", stack, w.Code, w.Body.String())
+		}
+	}
+	w := do(http.MethodPost, "/v1/tokens", "master-token", `{"name":"rotate-web","roles":["rotate"],"stacks":["web"]}`)
+	var created struct {
+		Token string `json:"token"`
+	}
+	json.NewDecoder(w.Body).Decode(&created)
+
+	// Pattern invalidation only reaches entries the token's stacks use.
+	if w := do(http.MethodDelete, "/v1/cache?vault=Infra&item=*", created.Token, ""); w.Code != http.StatusOK {
+		t.Fatalf("DELETE status = %d: %s", w.Code, w.Body.String())
+	}
+	for key, want := range map[string]bool{
+		"Infra/web/key":         false,
+		"Infra/shared/password": false,
+		"Infra/api/token":       true,
+	} {
+		if _, err := store.Get(key); (err == nil) != want {
+			t.Errorf("%s cached = %v, want %v", key, err == nil, want)
+		}
+	}
+
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/v1/rotate/Infra/web", http.StatusOK},
+		{"/v1/rotate/Infra/shared", http.StatusForbidden},  // also used by api
+		{"/v1/rotate/Infra/api", http.StatusForbidden},     // only used by api
+		{"/v1/rotate/Infra/unknown", http.StatusForbidden}, // used by no stack
+	} {
+		if w := do(http.MethodPost, tc.path, created.Token, ""); w.Code != tc.want {
+			t.Errorf("POST %s status = %d, want %d (%s)", tc.path, w.Code, tc.want, w.Body.String())
+		}
+	}
+	if _, err := store.Get("Infra/api/token"); err != nil {
+		t.Errorf("Infra/api/token invalidated by an out-of-scope rotation")
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elabx-org/herald/internal/materialize"
+	"github.com/elabx-org/herald/internal/resolver"
+	"github.com/rs/zerolog/log"
+)
+
+// changeDetector remembers the hash of the last value seen for each ref, so
+// changes are noticed whether or not the cache already holds the new value.
+type changeDetector struct {
+	mu   sync.Mutex // serializes passes
+	seen map[string][sha256.Size]byte
+}
+
+// StartChangeDetector polls watched vaults every change_detection.interval
+// seconds and rotates items whose value changed in the provider. No-op unless
+// change detection is enabled.
+func (s *Server) StartChangeDetector(ctx context.Context) {
+	cd := s.cfg.ChangeDetection
+	if !cd.Enabled || s.manager == nil || cd.Interval <= 0 {
+		return
+	}
+	interval := time.Duration(cd.Interval) * time.Second
+	log.Info().Dur("interval", interval).Strs("vaults", cd.Vaults).Bool("redeploy", cd.Redeploy).Msg("change detector started")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.DetectChanges(ctx)
+		}
+	}
+}
+
+// DetectChanges runs one poll: it re-resolves every indexed ref in a watched
+// vault, compares its hash with the value seen on the previous poll (or, on
+// the first poll of a ref, the cached value), and runs the rotation flow for each item with a changed
+// field. It returns the rotated items as vault/item. A ref seen for the first
+// time with nothing cached only records a baseline.
+func (s *Server) DetectChanges(ctx context.Context) []string {
+	if s.manager == nil {
+		return nil
+	}
+	s.changes.mu.Lock()
+	defer s.changes.mu.Unlock()
+	if s.changes.seen == nil {
+		s.changes.seen = make(map[string][sha256.Size]byte)
+	}
+	cd := s.cfg.ChangeDetection
+
+	changed := make(map[[2]string]bool)
+	for _, rawURI := range s.index.RefsSyncedSince(time.Time{}) {
+		ref, err := resolver.ParseOpURI(rawURI)
+		if err != nil || !s.watchedVault(ref.Vault) || changed[[2]string{ref.Vault, ref.Item}] {
+			continue
+		}
+		if !s.pace.wait(ctx) {
+			break
+		}
+		val, _, err := s.resolveBackground(ctx, ref)
+		if err != nil {
+			if materialize.ClassifyError(err) == materialize.ErrClassRateLimited {
+				log.Warn().Err(err).Msg("change detector: provider rate limited — ending poll early")
+				break
+			}
+			log.Warn().Err(err).Str("ref", rawURI).Msg("change detector: resolve failed")
+			continue
+		}
+
+		cacheKey := fmt.Sprintf("%s/%s/%s", ref.Vault, ref.Item, ref.Field)
+		sum := sha256.Sum256([]byte(val))
+		// Prefer our own baseline: the cache may already hold the new value
+		// (written by refresh-ahead or another stack's materialize).
+		baseline, known := s.changes.seen[cacheKey]
+		if !known && s.cache != nil {
+			if entry, err := s.cache.GetStale(cacheKey); err == nil {
+				baseline, known = sha256.Sum256([]byte(entry.Value)), true
+			}
+		}
+		s.changes.seen[cacheKey] = sum
+		if known && baseline != sum {
+			changed[[2]string{ref.Vault, ref.Item}] = true
+		}
+	}
+
+	var rotated []string
+	for item := range changed {
+		rotated = append(rotated, item[0]+"/"+item[1])
+	}
+	sort.Strings(rotated)
+	for _, name := range rotated {
+		vault, item, _ := strings.Cut(name, "/")
+		res := s.rotate(ctx, vault, item, "change-detection", cd.Redeploy)
+		s.statChanges.Add(1)
+		log.Info().Str("vault", vault).Str("item", item).Int("cache_invalidated", res.CacheInvalidated).
+			Strs("stacks_redeployed", res.StacksRedeployed).Msg("change detector: item changed in provider")
+	}
+	return rotated
+}
+
+// watchedVault reports whether change detection covers vault. Vaults opt in
+// by name; "*" watches every vault.
+func (s *Server) watchedVault(vault string) bool {
+	for _, v := range s.cfg.ChangeDetection.Vaults {
+		if v == "*" || v == vault {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elabx-org/herald/internal/api"
+	"github.com/elabx-org/herald/internal/cache"
+	"github.com/elabx-org/herald/internal/config"
+	"github.com/elabx-org/herald/internal/provider"
+)
+
+func TestDetectChanges(t *testing.T) {
+	store, err := cache.New(t.TempDir()+"/cache.db", "test-passphrase")
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	defer store.Close()
+
+	cfg := &config.Config{}
+	cfg.Cache.DefaultPolicy = cache.PolicyMemory
+	cfg.Cache.DefaultTTL = 3600
+	cfg.ChangeDetection.Vaults = []string{"vault"}
+	p := &countingProvider{value: "v1"}
+	srv := api.NewServer(cfg, provider.NewManager([]provider.Provider{p}))
+	srv.SetCache(store)
+
+	body := `{"stack":"myapp","env_content":"A=op://vault/app/a\nB=op://other/db/b\n"}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/materialize/env", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	srv.Router().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("materialize status = %d: %s", w.Code, w.Body.String())
+	}
+
+	if got := srv.DetectChanges(context.Background()); len(got) != 0 {
+		t.Errorf("DetectChanges() with unchanged values = %v, want none", got)
+	}
+
+	p.value = "v2"
+	got := srv.DetectChanges(context.Background())
+	if len(got) != 1 || got[0] != "vault/app" {
+		t.Fatalf("DetectChanges() = %v, want [vault/app] (other vault not watched)", got)
+	}
+	if _, err := store.GetStale("vault/app/a"); err == nil {
+		t.Error("changed item still cached, want invalidated")
+	}
+	if _, err := store.GetStale("other/db/b"); err != nil {
+		t.Errorf("unwatched vault entry invalidated: %v", err)
+	}
+
+	// The new value becomes the baseline: no second rotation.
+	if got := srv.DetectChanges(context.Background()); len(got) != 0 {
+		t.Errorf("DetectChanges() after rotation = %v, want none", got)
+	}
+
+	// A change already written to the cache (by refresh-ahead or another
+	// stack's materialize) is still reported.
+	p.value = "v3"
+	store.Set("vault/app/a", &cache.Entry{Value: "v3", Policy: cache.PolicyMemory, ExpiresAt: time.Now().Add(time.Hour)})
+	if got := srv.DetectChanges(context.Background()); len(got) != 1 || got[0] != "vault/app" {
+		t.Errorf("DetectChanges() with the new value cached = %v, want [vault/app]", got)
+	}
+}
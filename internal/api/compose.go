@@ -0,0 +1,178 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/elabx-org/herald/internal/compose"
+	"github.com/elabx-org/herald/internal/format"
+	"github.com/elabx-org/herald/internal/materialize"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	composeOutputOverride = "override"
+	composeOutputEnvFiles = "env_files"
+)
+
+type materializeComposeRequest struct {
+	Stack          string            `json:"stack"`
+	ComposeContent string            `json:"compose_content"`     // raw compose.yaml
+	EnvFiles       map[string]string `json:"env_files,omitempty"` // env_file path (as written in compose) -> content
+	Output         string            `json:"output,omitempty"`    // "override" (default) or "env_files"
+	BypassCache    bool              `json:"bypass_cache"`
+	Mode           string            `json:"mode,omitempty"`   // "strict" (default) or "lenient"
+	Format         string            `json:"format,omitempty"` // format of env_files output; see format.Formats (default "env")
+}
+
+type materializeComposeResponse struct {
+	materializeSummary
+	Format   string              `json:"format"`
+	Services map[string][]string `json:"services"`            // service -> env var names with refs
+	Override string              `json:"override,omitempty"`  // compose override YAML
+	EnvFiles map[string]string   `json:"env_files,omitempty"` // service -> resolved env file content, in format
+
+	*apiError // set when strict mode aborted
+}
+
+func (s *Server) handleMaterializeCompose(w http.ResponseWriter, r *http.Request) {
+	var req materializeComposeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "invalid request body")
+		return
+	}
+	if req.Stack == "" {
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "stack is required")
+		return
+	}
+	if req.Output == "" {
+		req.Output = composeOutputOverride
+	}
+	if req.Output != composeOutputOverride && req.Output != composeOutputEnvFiles {
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "output must be \"override\" or \"env_files\"")
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = materialize.ModeStrict
+	}
+	if req.Mode != materialize.ModeStrict && req.Mode != materialize.ModeLenient {
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "mode must be \"strict\" or \"lenient\"")
+		return
+	}
+	if req.Format == "" {
+		req.Format = format.Env
+	}
+	if !format.Valid(req.Format) {
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "format must be one of: "+strings.Join(format.Formats, ", "))
+		return
+	}
+	if req.Format != format.Env && req.Output != composeOutputEnvFiles {
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "format applies only to output \"env_files\"")
+		return
+	}
+
+	file, err := compose.Parse([]byte(req.ComposeContent))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	secrets, err := file.Secrets(req.EnvFiles)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	refs, serviceRefs, err := compose.Refs(secrets)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeRefInvalid, "failed to scan compose file: "+err.Error())
+		return
+	}
+
+	if !authorizeRefs(w, r, req.Stack, refs) {
+		return
+	}
+	keys := compose.RefKeys(secrets)
+	if !s.enforceAccessPolicy(w, r, req.Stack, refs, keys) {
+		return
+	}
+
+	refOptions, err := compose.RefOptions([]byte(req.ComposeContent), req.EnvFiles)
+	if err == nil {
+		err = validateRefOptions(refOptions)
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeRefInvalid, "invalid herald annotation: "+err.Error())
+		return
+	}
+
+	services := make(map[string][]string, len(secrets))
+	for svc, vars := range secrets {
+		for _, v := range vars {
+			services[svc] = append(services[svc], v.Key)
+		}
+	}
+
+	resp := materializeComposeResponse{materializeSummary: newMaterializeSummary(req.Mode, nil), Format: req.Format, Services: services}
+	if len(refs) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Error().Err(err).Str("stack", req.Stack).Msg("materialize compose: encode response failed")
+		}
+		return
+	}
+
+	result, status, apiErr := s.runMaterialize(r, materializeJob{
+		stack:       req.Stack,
+		kind:        "compose",
+		mode:        req.Mode,
+		format:      req.Format,
+		bypassCache: req.BypassCache,
+		refs:        refs,
+		refOptions:  refOptions,
+		delivery:    []string{"compose_" + req.Output},
+		serviceRefs: serviceRefs,
+		resolve: func(ctx context.Context, mat *materialize.EnvMaterializer) (*materialize.Result, error) {
+			resolved, result, err := mat.ResolveRefs(ctx, refs)
+			result.SetKeys(keys)
+			if err != nil {
+				return result, err
+			}
+			resp.Override, resp.EnvFiles, err = renderCompose(req, secrets, resolved)
+			return result, err
+		},
+	})
+	if result == nil {
+		writeError(w, r, status, apiErr.Code, apiErr.Error)
+		return
+	}
+	resp.materializeSummary = newMaterializeSummary(req.Mode, result)
+	resp.apiError = apiErr
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Error().Err(err).Str("stack", req.Stack).Msg("materialize compose: encode response failed")
+	}
+}
+
+// renderCompose builds the requested compose output from resolved values:
+// an override file, or one env file per service in the requested format.
+func renderCompose(req materializeComposeRequest, secrets map[string][]compose.EnvVar, resolved map[string]string) (string, map[string]string, error) {
+	if req.Output == composeOutputOverride {
+		override, err := compose.Override(secrets, resolved)
+		return override, nil, err
+	}
+	if req.Format == format.Env {
+		return "", compose.EnvFiles(secrets, resolved), nil
+	}
+	envFiles := make(map[string]string, len(secrets))
+	for svc, vars := range compose.ServiceVars(secrets, resolved) {
+		content, err := format.Render(req.Format, req.Stack+"-"+svc, vars)
+		if err != nil {
+			return "", nil, fmt.Errorf("render %s for service %s: %w", req.Format, svc, err)
+		}
+		envFiles[svc] = content
+	}
+	return "", envFiles, nil
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/elabx-org/herald/internal/materialize"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Error codes carried by every error response. Clients should branch on the
+// code, not the message or HTTP status.
+const (
+	CodeBadRequest          = "bad_request"          // malformed body or invalid parameter
+	CodeRefInvalid          = "ref_invalid"          // an op:// ref or herald annotation could not be parsed
+	CodeUnauthorized        = "unauthorized"         // missing or invalid credentials, or the provider rejected Herald's
+	CodeForbidden           = "forbidden"            // credentials lack the role, stack or vault
+	CodePolicyDenied        = "policy_denied"        // the access policy or output allowlist rejected the request
+	CodeNotFound            = "not_found"            // stack, token or secret does not exist
+	CodeConflict            = "conflict"             // the request does not fit the server's current mode
+	CodeRateLimited         = "rate_limited"         // the provider is rate limiting; retry later
+	CodeTimeout             = "timeout"              // the provider did not answer in time
+	CodeProviderUnavailable = "provider_unavailable" // the provider could not be reached
+	CodeUnavailable         = "unavailable"          // the feature is not configured on this server
+	CodeInternal            = "internal"             // anything else
+)
+
+// retryableCodes are the failures a client may retry unchanged.
+var retryableCodes = map[string]bool{
+	CodeRateLimited:         true,
+	CodeTimeout:             true,
+	CodeProviderUnavailable: true,
+	CodeInternal:            true,
+}
+
+// apiError is the JSON error envelope. Responses that carry a partial result
+// (strict-mode materialize failures) embed it next to their other fields.
+type apiError struct {
+	Error     string `json:"error"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+	Retryable bool   `json:"retryable"`
+}
+
+func newAPIError(r *http.Request, code, msg string) *apiError {
+	return &apiError{
+		Error:     msg,
+		Code:      code,
+		RequestID: middleware.GetReqID(r.Context()),
+		Retryable: retryableCodes[code],
+	}
+}
+
+// writeError sends a JSON error envelope with status.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(newAPIError(r, code, msg))
+}
+
+// resolveErrorCode returns the error code for a failed materialization: that
+// of the first failed ref.
+func resolveErrorCode(result *materialize.Result) string {
+	for _, ref := range result.Refs {
+		if ref.Outcome == materialize.OutcomeFailed {
+			return errorCode(ref.ErrorClass)
+		}
+	}
+	return CodeInternal
+}
+
+// providerErrorStatus returns the HTTP status for a failure reported by a
+// provider, so a permanent error does not look like a server fault.
+func providerErrorStatus(code string) int {
+	switch code {
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeUnauthorized:
+		// Herald's own provider credentials were rejected, not the caller's.
+		return http.StatusForbidden
+	case CodeRateLimited:
+		return http.StatusTooManyRequests
+	case CodeTimeout:
+		return http.StatusGatewayTimeout
+	case CodeProviderUnavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// errorCode maps a provider error class to an error code.
+func errorCode(class string) string {
+	switch class {
+	case materialize.ErrClassNotFound:
+		return CodeNotFound
+	case materialize.ErrClassRateLimited:
+		return CodeRateLimited
+	case materialize.ErrClassUnauthorized:
+		return CodeUnauthorized
+	case materialize.ErrClassTimeout:
+		return CodeTimeout
+	case materialize.ErrClassUnavailable:
+		return CodeProviderUnavailable
+	}
+	return CodeInternal
+}
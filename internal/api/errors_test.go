@@ -0,0 +1,71 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/elabx-org/herald/internal/api"
+	"github.com/elabx-org/herald/internal/config"
+	"github.com/elabx-org/herald/internal/provider"
+)
+
+type failingProvider struct{ err error }
+
+func (p *failingProvider) Name() string  { return "failing" }
+func (p *failingProvider) Priority() int { return 1 }
+func (p *failingProvider) Type() string  { return "mock" }
+func (p *failingProvider) Resolve(ctx context.Context, vault, item, field string) (string, error) {
+	return "", p.err
+}
+func (p *failingProvider) Healthy(ctx context.Context) (bool, int64, error) { return true, 1, nil }
+
+func TestErrorEnvelope(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.APIToken = "secret"
+	p := &failingProvider{err: errors.New("rate limit exceeded")}
+	srv := api.NewServer(cfg, provider.NewManager([]provider.Provider{p}))
+
+	tests := []struct {
+		name, method, path, token, body string
+		status                          int
+		code                            string
+		retryable                       bool
+	}{
+		{"no token", http.MethodGet, "/v1/inventory", "", "", http.StatusUnauthorized, api.CodeUnauthorized, false},
+		{"unknown route", http.MethodGet, "/v1/nope", "secret", "", http.StatusNotFound, api.CodeNotFound, false},
+		{"bad body", http.MethodPost, "/v1/materialize/env", "secret", "{", http.StatusBadRequest, api.CodeBadRequest, false},
+		{"unknown stack", http.MethodGet, "/v1/inventory/missing", "secret", "", http.StatusNotFound, api.CodeNotFound, false},
+		{"provider rate limited", http.MethodPost, "/v1/materialize/env", "secret",
+			`{"stack":"myapp","env_content":"A=op://Vault/item/field\n"}`, http.StatusTooManyRequests, api.CodeRateLimited, true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		srv.Router().ServeHTTP(w, req)
+
+		var body struct {
+			Error     string `json:"error"`
+			Code      string `json:"code"`
+			RequestID string `json:"request_id"`
+			Retryable bool   `json:"retryable"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s: body %q is not JSON: %v", tt.name, w.Body.String(), err)
+			continue
+		}
+		if w.Code != tt.status || body.Code != tt.code || body.Retryable != tt.retryable {
+			t.Errorf("%s: got %d %q retryable=%v, want %d %q retryable=%v", tt.name, w.Code, body.Code, body.Retryable, tt.status, tt.code, tt.retryable)
+		}
+		if body.Error == "" || body.RequestID == "" {
+			t.Errorf("%s: envelope %+v lacks a message or request ID", tt.name, body)
+		}
+	}
+}
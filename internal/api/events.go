@@ -0,0 +1,273 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elabx-org/herald/internal/materialize"
+)
+
+// Event types streamed by GET /v1/events.
+const (
+	EventMaterialize     = "materialize"
+	EventCacheInvalidate = "cache_invalidate"
+	EventRotate          = "rotate"
+	EventRedeploy        = "redeploy"
+	EventProviderHealth  = "provider_health"
+	EventTokenExpiry     = "token_expiry"
+)
+
+// EventTypes lists every event type.
+var EventTypes = []string{EventMaterialize, EventCacheInvalidate, EventRotate, EventRedeploy, EventProviderHealth, EventTokenExpiry}
+
+const (
+	defaultEventBuffer = 1000
+	eventHeartbeat     = 15 * time.Second
+)
+
+// Event is one entry of the activity stream. It never carries secret values.
+type Event struct {
+	ID     uint64                 `json:"id"`
+	Type   string                 `json:"type"`
+	Time   time.Time              `json:"ts"`
+	Stacks []string               `json:"stacks,omitempty"` // stacks the event concerns; empty for server-wide events
+	Data   map[string]interface{} `json:"data,omitempty"`
+}
+
+// eventBus keeps the most recent events in a bounded ring, so clients can
+// resume with Last-Event-ID, and wakes subscribers when new ones arrive.
+type eventBus struct {
+	mu     sync.Mutex
+	size   int
+	ring   []Event // oldest first
+	nextID uint64
+	subs   map[chan struct{}]struct{}
+}
+
+func newEventBus(size int) *eventBus {
+	if size <= 0 {
+		size = defaultEventBuffer
+	}
+	return &eventBus{size: size, nextID: 1, subs: make(map[chan struct{}]struct{})}
+}
+
+// publish assigns e an ID and timestamp, buffers it and notifies subscribers.
+func (b *eventBus) publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e.ID = b.nextID
+	b.nextID++
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if len(b.ring) == b.size {
+		copy(b.ring, b.ring[1:])
+		b.ring[len(b.ring)-1] = e
+	} else {
+		b.ring = append(b.ring, e)
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- struct{}{}:
+		default: // already notified
+		}
+	}
+}
+
+// since returns the buffered events after id. An id the bus never issued
+// (from before a restart) replays the whole buffer.
+func (b *eventBus) since(id uint64) []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if id >= b.nextID {
+		id = 0
+	}
+	i, _ := slices.BinarySearchFunc(b.ring, id+1, func(e Event, target uint64) int {
+		switch {
+		case e.ID < target:
+			return -1
+		case e.ID > target:
+			return 1
+		}
+		return 0
+	})
+	return slices.Clone(b.ring[i:])
+}
+
+// lastID returns the ID of the most recent event, or 0.
+func (b *eventBus) lastID() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.nextID - 1
+}
+
+// subscribe returns a channel signalled after each publish, and a function
+// that unsubscribes it.
+func (b *eventBus) subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+// publish records an activity event.
+func (s *Server) publish(typ string, stacks []string, data map[string]interface{}) {
+	s.events.publish(Event{Type: typ, Stacks: stacks, Data: data})
+}
+
+// publishMaterialize records a materialize completion. code and err are set
+// when it failed.
+func (s *Server) publishMaterialize(ctx context.Context, stack, kind string, result *materialize.Result, code string, err error) {
+	data := map[string]interface{}{}
+	if kind != "" {
+		data["kind"] = kind
+	}
+	if result != nil {
+		data["resolved"] = result.Resolved
+		data["cache_hits"] = result.CacheHits
+		data["stale_hits"] = result.StaleHits
+		data["failed"] = result.Failed
+		data["duration_ms"] = result.DurationMs
+	}
+	if name := tokenName(ctx); name != "" {
+		data["token"] = name
+	}
+	if err != nil {
+		data["error"] = err.Error()
+		data["code"] = code
+	}
+	s.publish(EventMaterialize, []string{stack}, data)
+}
+
+// handleEvents streams activity as server-sent events. ?stack= and ?type=
+// take comma-separated filters; Last-Event-ID (or ?last_event_id=) replays
+// the buffered events after that ID before streaming new ones.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	stacks := splitList(q.Get("stack"))
+	types := splitList(q.Get("type"))
+	for _, typ := range types {
+		if !slices.Contains(EventTypes, typ) {
+			writeError(w, r, http.StatusBadRequest, CodeBadRequest, "unknown event type "+typ+" (want one of "+strings.Join(EventTypes, ", ")+")")
+			return
+		}
+	}
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = q.Get("last_event_id")
+	}
+
+	rc := http.NewResponseController(w)
+	// Streams outlive the server's write timeout.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "cannot stream: "+err.Error())
+		return
+	}
+
+	// Subscribe before taking the cursor so no event falls in between.
+	notify, unsubscribe := s.events.subscribe()
+	defer unsubscribe()
+	cursor := s.events.lastID()
+	if lastEventID != "" {
+		if id, err := strconv.ParseUint(lastEventID, 10, 64); err == nil {
+			cursor = id
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	t := requestToken(r.Context())
+	send := func() error {
+		for _, e := range s.events.since(cursor) {
+			cursor = e.ID
+			if e, ok := visibleEvent(e, t, stacks, types); ok {
+				data, err := json.Marshal(e)
+				if err != nil {
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+					return err
+				}
+			}
+		}
+		return rc.Flush()
+	}
+	if err := send(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-notify:
+			if err := send(); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// visibleEvent applies the token's stack scope and the request's filters.
+// Stacks outside the token's scope are removed from the event; an event left
+// with none is hidden. Server-wide events (no stacks) pass the scope check
+// but not a stack filter.
+func visibleEvent(e Event, t *apiToken, stacks, types []string) (Event, bool) {
+	if len(types) > 0 && !slices.Contains(types, e.Type) {
+		return e, false
+	}
+	if len(e.Stacks) > 0 {
+		var allowed []string
+		for _, stack := range e.Stacks {
+			if t.allowsStack(stack) {
+				allowed = append(allowed, stack)
+			}
+		}
+		if len(allowed) == 0 {
+			return e, false
+		}
+		e.Stacks = allowed
+	}
+	if len(stacks) > 0 && !slices.ContainsFunc(e.Stacks, func(stack string) bool { return slices.Contains(stacks, stack) }) {
+		return e, false
+	}
+	return e, true
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
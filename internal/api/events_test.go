@@ -0,0 +1,109 @@
+package api_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elabx-org/herald/internal/api"
+	"github.com/elabx-org/herald/internal/config"
+	"github.com/elabx-org/herald/internal/provider"
+)
+
+func TestEvents(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.APIToken = "secret"
+	cfg.Events.BufferSize = 3
+	p := &countingProvider{value: "s3cr3t-value"}
+	srv := api.NewServer(cfg, provider.NewManager([]provider.Provider{p}))
+	ts := httptest.NewServer(srv.Router())
+	defer ts.Close()
+
+	materialize := func(stack string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/v1/materialize/env",
+			strings.NewReader(`{"stack":"`+stack+`","env_content":"A=op://Vault/item/field\n"}`))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		srv.Router().ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("materialize %s status = %d: %s", stack, w.Code, w.Body.String())
+		}
+	}
+	// stream opens /v1/events and returns the first n events received.
+	stream := func(query, lastEventID string, n int, during func()) []api.Event {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/v1/events"+query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET /v1/events error = %v", err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+		}
+		if during != nil {
+			during()
+		}
+		var events []api.Event
+		sc := bufio.NewScanner(resp.Body)
+		for len(events) < n && sc.Scan() {
+			data, ok := strings.CutPrefix(sc.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			if strings.Contains(data, "s3cr3t-value") {
+				t.Errorf("event carries a secret value: %s", data)
+			}
+			var e api.Event
+			if err := json.Unmarshal([]byte(data), &e); err != nil {
+				t.Fatalf("decode event %q: %v", data, err)
+			}
+			events = append(events, e)
+		}
+		if len(events) < n {
+			t.Fatalf("got %d events, want %d", len(events), n)
+		}
+		return events
+	}
+
+	materialize("a")
+	materialize("b")
+	materialize("a")
+	materialize("b")
+
+	// The ring holds the last 3 events (IDs 2-4); resuming from before it
+	// replays what is left, filtered by stack.
+	got := stream("?stack=a", "0", 1, nil)
+	if got[0].ID != 3 || got[0].Type != api.EventMaterialize || got[0].Stacks[0] != "a" {
+		t.Errorf("replayed event = %+v, want id 3 materialize for stack a", got[0])
+	}
+	if got[0].Data["resolved"] != float64(1) || got[0].Data["kind"] != "env" {
+		t.Errorf("replayed event data = %v", got[0].Data)
+	}
+
+	// Resuming from the latest ID only delivers new events.
+	got = stream("?type=materialize", "4", 1, func() { materialize("c") })
+	if got[0].ID != 5 || got[0].Stacks[0] != "c" {
+		t.Errorf("live event = %+v, want id 5 for stack c", got[0])
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/events?type=bogus", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	srv.Router().ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unknown type status = %d, want 400", w.Code)
+	}
+}
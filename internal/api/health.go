@@ -11,6 +11,8 @@ import (
 type HealthResponse struct {
 	Status      string           `json:"status"`
 	Provisioner string           `json:"provisioner,omitempty"` // "connect", "sdk", or absent if unavailable
+	Cache       string           `json:"cache,omitempty"`       // "memory_only" when running without the cache KEK
+	WarmUp      *WarmUpStatus    `json:"warm_up,omitempty"`     // set while the startup warm-up is in progress
 	Providers   []ProviderStatus `json:"providers"`
 	Uptime      int64            `json:"uptime_seconds"`
 }
@@ -26,8 +28,19 @@ type ProviderStatus struct {
 
 var startTime = time.Now()
 
+func (s *Server) cacheMode() string {
+	if s.cache != nil && s.cache.MemoryOnly() {
+		return "memory_only"
+	}
+	return ""
+}
+
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	resp, code := s.getHealth(r)
+	if !s.ready() {
+		st := s.warmUpStatus()
+		resp.Status, resp.WarmUp, code = "warming_up", &st, http.StatusServiceUnavailable
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(resp)
@@ -85,6 +98,7 @@ func (s *Server) getHealth(r *http.Request) (HealthResponse, int) {
 	resp := HealthResponse{
 		Status:      status,
 		Provisioner: s.provisionerType(),
+		Cache:       s.cacheMode(),
 		Providers:   statuses,
 		Uptime:      int64(time.Since(startTime).Seconds()),
 	}
@@ -2,10 +2,13 @@ package api
 
 import (
 	"encoding/json"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/elabx-org/herald/internal/resolver"
 	bolt "go.etcd.io/bbolt"
 	"github.com/rs/zerolog/log"
 )
@@ -14,11 +17,14 @@ var indexBucket = []byte("index")
 
 // StackInfo contains metadata about a stack's secrets.
 type StackInfo struct {
-	SecretCount int                `json:"secret_count"`
-	Providers   []string           `json:"providers"`
-	Policies    []string           `json:"policies"`
-	LastSynced  time.Time          `json:"last_synced"`
-	ItemRefs    map[string][]string `json:"item_refs"` // item ID -> env var names
+	SecretCount int                 `json:"secret_count"`
+	Providers   []string            `json:"providers"`
+	Policies    []string            `json:"policies"`
+	LastSynced  time.Time           `json:"last_synced"`
+	ItemRefs    map[string][]string `json:"item_refs"`              // item ID -> env var names
+	ServiceRefs map[string][]string `json:"service_refs,omitempty"` // compose service -> op:// URIs
+
+	RefOptions map[string]resolver.RefOption `json:"ref_options,omitempty"` // annotated refs -> cache policy/TTL
 }
 
 // Index maintains a mapping of stacks to their secret references.
@@ -95,6 +101,30 @@ func (idx *Index) Get(stack string) (*StackInfo, bool) {
 	return &cp, true
 }
 
+// RefsSyncedSince returns the distinct op:// URIs referenced by stacks synced
+// at or after since (every stack when since is zero), sorted.
+func (idx *Index) RefsSyncedSince(since time.Time) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	seen := make(map[string]bool)
+	var refs []string
+	for _, info := range idx.stacks {
+		if info.LastSynced.Before(since) {
+			continue
+		}
+		for _, uris := range info.ItemRefs {
+			for _, uri := range uris {
+				if !seen[uri] {
+					seen[uri] = true
+					refs = append(refs, uri)
+				}
+			}
+		}
+	}
+	sort.Strings(refs)
+	return refs
+}
+
 // StacksForItem returns the list of stack names that reference the given item ID.
 func (idx *Index) StacksForItem(itemID string) []string {
 	idx.mu.RLock()
@@ -128,6 +158,48 @@ func (idx *Index) StacksForVaultAndItem(vault, itemID string) []string {
 	return stacks
 }
 
+// StacksMatching returns the stacks (sorted) with at least one ref whose
+// vault, item and field satisfy match.
+func (idx *Index) StacksMatching(match func(vault, item, field string) bool) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var stacks []string
+	for name, info := range idx.stacks {
+		for _, uris := range info.ItemRefs {
+			if slices.ContainsFunc(uris, func(uri string) bool {
+				ref, err := resolver.ParseOpURI(uri)
+				return err == nil && match(ref.Vault, ref.Item, ref.Field)
+			}) {
+				stacks = append(stacks, name)
+				break
+			}
+		}
+	}
+	sort.Strings(stacks)
+	return stacks
+}
+
+// CacheKeysFor returns the cache keys (vault/item/field) of the refs of the
+// stacks accepted by include.
+func (idx *Index) CacheKeysFor(include func(stack string) bool) map[string]bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	keys := make(map[string]bool)
+	for name, info := range idx.stacks {
+		if !include(name) {
+			continue
+		}
+		for _, uris := range info.ItemRefs {
+			for _, uri := range uris {
+				if ref, err := resolver.ParseOpURI(uri); err == nil {
+					keys[ref.Vault+"/"+ref.Item+"/"+ref.Field] = true
+				}
+			}
+		}
+	}
+	return keys
+}
+
 // Delete removes a stack from the index, persisting the removal to bbolt if available.
 func (idx *Index) Delete(stack string) {
 	idx.mu.Lock()
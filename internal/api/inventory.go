@@ -9,23 +9,25 @@ import (
 )
 
 type stackInventory struct {
-	Secrets       int        `json:"secrets"`
-	LastSynced    *time.Time `json:"last_synced,omitempty"`
-	ProvidersUsed []string   `json:"providers_used"`
-	Policies      []string   `json:"policies"`
+	Secrets       int                 `json:"secrets"`
+	LastSynced    *time.Time          `json:"last_synced,omitempty"`
+	ProvidersUsed []string            `json:"providers_used"`
+	Policies      []string            `json:"policies"`
+	Services      map[string][]string `json:"services,omitempty"` // compose service -> op:// URIs
 }
 
 func (s *Server) handleInventoryStackReal(w http.ResponseWriter, r *http.Request) {
 	stack := chi.URLParam(r, "stack")
 	info, ok := s.index.Get(stack)
-	if !ok {
-		http.Error(w, "stack not found", http.StatusNotFound)
+	if !ok || !requestToken(r.Context()).allowsStack(stack) {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "stack not found")
 		return
 	}
 	inv := stackInventory{
 		Secrets:       info.SecretCount,
 		ProvidersUsed: info.Providers,
 		Policies:      info.Policies,
+		Services:      info.ServiceRefs,
 	}
 	if !info.LastSynced.IsZero() {
 		inv.LastSynced = &info.LastSynced
@@ -38,11 +40,16 @@ func (s *Server) handleInventoryReal(w http.ResponseWriter, r *http.Request) {
 	stacks := s.index.All()
 	inventory := make(map[string]stackInventory)
 
+	t := requestToken(r.Context())
 	for stack, info := range stacks {
+		if !t.allowsStack(stack) {
+			continue
+		}
 		inv := stackInventory{
 			Secrets:       info.SecretCount,
 			ProvidersUsed: info.Providers,
 			Policies:      info.Policies,
+			Services:      info.ServiceRefs,
 		}
 		if !info.LastSynced.IsZero() {
 			inv.LastSynced = &info.LastSynced
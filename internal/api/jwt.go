@@ -0,0 +1,39 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/elabx-org/herald/internal/jwtauth"
+	"github.com/gobwas/glob"
+)
+
+// jwtTokenPrefix marks audit records of requests made with a workload JWT.
+const jwtTokenPrefix = "jwt:"
+
+// SetJWTVerifier accepts workload JWTs verified by v as bearer tokens.
+func (s *Server) SetJWTVerifier(v *jwtauth.Verifier) {
+	s.jwt = v
+}
+
+// jwtPrincipal verifies a workload JWT and returns a principal with the
+// configured roles, limited to the stacks named by the stack claim.
+func (s *Server) jwtPrincipal(token string) (*apiToken, error) {
+	claims, err := s.jwt.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	stacks := claims.Strings(s.cfg.JWT.StackClaim)
+	if len(stacks) == 0 {
+		return nil, fmt.Errorf("token has no %q claim", s.cfg.JWT.StackClaim)
+	}
+	t := &apiToken{Name: jwtTokenPrefix + claims.String("sub"), Roles: s.cfg.JWT.Roles, Stacks: stacks}
+	for _, stack := range stacks {
+		// Claims name stacks literally; they are not patterns.
+		g, err := glob.Compile(glob.QuoteMeta(stack))
+		if err != nil {
+			return nil, err
+		}
+		t.stackGlobs = append(t.stackGlobs, g)
+	}
+	return t, nil
+}
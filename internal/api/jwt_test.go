@@ -0,0 +1,81 @@
+package api_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elabx-org/herald/internal/api"
+	"github.com/elabx-org/herald/internal/config"
+	"github.com/elabx-org/herald/internal/jwtauth"
+)
+
+func TestJWTWorkloadIdentity(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	doc, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "OKP", "crv": "Ed25519", "kid": "k1", "x": b64(pub)},
+	}})
+	if err := os.WriteFile(jwksFile, doc, 0600); err != nil {
+		t.Fatal(err)
+	}
+	mint := func(claims map[string]interface{}) string {
+		header, _ := json.Marshal(map[string]string{"alg": "EdDSA", "kid": "k1"})
+		payload, _ := json.Marshal(claims)
+		signed := b64(header) + "." + b64(payload)
+		return signed + "." + b64(ed25519.Sign(priv, []byte(signed)))
+	}
+
+	cfg := &config.Config{}
+	cfg.JWT.StackClaim = "stack"
+	cfg.JWT.Roles = []string{api.RoleMaterialize}
+	srv := api.NewServer(cfg, nil)
+	keys, err := jwtauth.NewKeySet(jwksFile, "", time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeySet() error = %v", err)
+	}
+	srv.SetJWTVerifier(jwtauth.NewVerifier(keys, "", "herald", 0))
+
+	exp := time.Now().Add(5 * time.Minute).Unix()
+	do := func(method, path, token, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.Router().ServeHTTP(w, req)
+		return w.Code
+	}
+	myapp := mint(map[string]interface{}{"sub": "periphery-1", "aud": "herald", "stack": "myapp", "exp": exp})
+
+	if code := do(http.MethodPost, "/v1/materialize/env", myapp, `{"stack":"myapp","env_content":"A=1\n"}`); code != http.StatusOK {
+		t.Errorf("matching stack status = %d, want 200", code)
+	}
+	if code := do(http.MethodPost, "/v1/materialize/env", myapp, `{"stack":"other","env_content":"A=1\n"}`); code != http.StatusForbidden {
+		t.Errorf("stack outside the claim status = %d, want 403", code)
+	}
+	if code := do(http.MethodGet, "/v1/inventory", myapp, ""); code != http.StatusForbidden {
+		t.Errorf("role not granted to JWTs status = %d, want 403", code)
+	}
+
+	for name, token := range map[string]string{
+		"expired":        mint(map[string]interface{}{"aud": "herald", "stack": "myapp", "exp": time.Now().Add(-time.Minute).Unix()}),
+		"wrong audience": mint(map[string]interface{}{"aud": "other", "stack": "myapp", "exp": exp}),
+		"no stack claim": mint(map[string]interface{}{"aud": "herald", "exp": exp}),
+		"tampered":       strings.Replace(myapp, ".", ".x", 1),
+	} {
+		if code := do(http.MethodPost, "/v1/materialize/env", token, `{"stack":"myapp","env_content":"A=1\n"}`); code != http.StatusUnauthorized {
+			t.Errorf("%s token status = %d, want 401", name, code)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/elabx-org/herald/internal/lint"
+)
+
+type lintRequest struct {
+	EnvContent string `json:"env_content"`
+}
+
+type lintResponse struct {
+	Findings []lint.Finding `json:"findings"`
+	Count    int            `json:"count"`
+	Errors   int            `json:"errors"`
+}
+
+// handleLint scans env content for plaintext secrets and malformed op:// refs.
+// No provider calls are made; findings never include the offending values.
+func (s *Server) handleLint(w http.ResponseWriter, r *http.Request) {
+	var req lintRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "invalid request body")
+		return
+	}
+
+	findings, err := lint.Scan(strings.NewReader(req.EnvContent))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "failed to scan env content: "+err.Error())
+		return
+	}
+	if findings == nil {
+		findings = []lint.Finding{}
+	}
+	errCount := 0
+	for _, f := range findings {
+		if f.Severity == lint.SeverityError {
+			errCount++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lintResponse{
+		Findings: findings,
+		Count:    len(findings),
+		Errors:   errCount,
+	})
+}
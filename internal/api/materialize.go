@@ -3,97 +3,292 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/elabx-org/herald/internal/audit"
+	"github.com/elabx-org/herald/internal/cache"
+	"github.com/elabx-org/herald/internal/format"
+	"github.com/elabx-org/herald/internal/fsutil"
 	"github.com/elabx-org/herald/internal/materialize"
 	"github.com/elabx-org/herald/internal/resolver"
 	"github.com/rs/zerolog/log"
 )
 
 type materializeEnvRequest struct {
-	Stack        string `json:"stack"`
-	OutPath      string `json:"out_path"`
-	EnvContent   string `json:"env_content"`   // raw env file content with op:// refs
-	BypassCache  bool   `json:"bypass_cache"`  // if true, skip cache read+write (always fetch fresh)
+	Stack       string `json:"stack"`
+	OutPath     string `json:"out_path"`
+	EnvContent  string `json:"env_content"`            // raw env file content with op:// refs
+	BypassCache bool   `json:"bypass_cache"`           // if true, skip cache read+write (always fetch fresh)
+	Mode        string `json:"mode,omitempty"`         // "strict" (default) or "lenient"
+	SecretsDir  string `json:"secrets_dir,omitempty"`  // write one file per secret here; content references *_FILE paths
+	SecretsUID  *int   `json:"secrets_uid,omitempty"`  // owner of secret files (default: unchanged)
+	SecretsGID  *int   `json:"secrets_gid,omitempty"`  // group of secret files (default: unchanged)
+	SecretsMode string `json:"secrets_mode,omitempty"` // octal permission of secret files (default "0400")
+	Format      string `json:"format,omitempty"`       // output format of content; see format.Formats (default "env")
+}
+
+// secretsDirOptions converts the secrets_* request fields into materializer
+// options.
+func (req *materializeEnvRequest) secretsDirOptions() (materialize.SecretsDirOptions, error) {
+	opts := materialize.SecretsDirOptions{Dir: req.SecretsDir, UID: -1, GID: -1, Mode: materialize.DefaultSecretFileMode}
+	if !filepath.IsAbs(req.SecretsDir) {
+		return opts, fmt.Errorf("secrets_dir must be an absolute path")
+	}
+	if req.SecretsUID != nil {
+		opts.UID = *req.SecretsUID
+	}
+	if req.SecretsGID != nil {
+		opts.GID = *req.SecretsGID
+	}
+	if req.SecretsMode != "" {
+		mode, err := strconv.ParseUint(req.SecretsMode, 8, 32)
+		if err != nil || mode > 0777 {
+			return opts, fmt.Errorf("secrets_mode must be an octal permission such as \"0440\"")
+		}
+		opts.Mode = os.FileMode(mode)
+	}
+	return opts, nil
+}
+
+// materializeSummary is the part of a materialize response shared by the env
+// and compose endpoints.
+type materializeSummary struct {
+	Resolved   int                      `json:"resolved"`
+	CacheHits  int                      `json:"cache_hits"`
+	StaleHits  int                      `json:"stale_hits,omitempty"`
+	Failed     int                      `json:"failed"`
+	DurationMs int64                    `json:"duration_ms"`
+	Mode       string                   `json:"mode"`
+	Refs       []materialize.RefReport  `json:"refs"`
+	Provenance []materialize.Provenance `json:"provenance"`
+}
+
+func newMaterializeSummary(mode string, result *materialize.Result) materializeSummary {
+	if result == nil {
+		return materializeSummary{Mode: mode, Refs: []materialize.RefReport{}, Provenance: []materialize.Provenance{}}
+	}
+	return materializeSummary{
+		Resolved:   result.Resolved,
+		CacheHits:  result.CacheHits,
+		StaleHits:  result.StaleHits,
+		Failed:     result.Failed,
+		DurationMs: result.DurationMs,
+		Mode:       mode,
+		Refs:       result.Refs,
+		Provenance: result.Provenance(),
+	}
 }
 
 type materializeEnvResponse struct {
-	Resolved   int    `json:"resolved"`
-	CacheHits  int    `json:"cache_hits"`
-	StaleHits  int    `json:"stale_hits,omitempty"`
-	Failed     int    `json:"failed"`
-	DurationMs int64  `json:"duration_ms"`
-	OutPath    string `json:"out_path,omitempty"`
-	Content    string `json:"content"`
+	materializeSummary
+	Format      string                   `json:"format"`
+	OutPath     string                   `json:"out_path,omitempty"`
+	Content     string                   `json:"content"`
+	SecretsDir  string                   `json:"secrets_dir,omitempty"`
+	SecretFiles []materialize.SecretFile `json:"secret_files,omitempty"` // key -> file path, when secrets_dir is set
+
+	*apiError // set when strict mode aborted
 }
 
-func (s *Server) handleMaterializeEnv(w http.ResponseWriter, r *http.Request) {
-	var req materializeEnvRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
-		return
+// outputDirs returns the directories stack may write under: the global
+// allowlist plus any stack-specific entries.
+func (s *Server) outputDirs(stack string) []string {
+	dirs := append([]string(nil), s.cfg.Materialize.OutputDirs...)
+	return append(dirs, s.cfg.Materialize.StackOutputDirs[stack]...)
+}
+
+// confineOutputs checks out_path and secrets_dir against the stack's output
+// allowlist and replaces them with their symlink-resolved form, so the write
+// lands where the check looked. Rejections are logged, audited and answered
+// with 403; it returns false if the request was rejected.
+func (s *Server) confineOutputs(w http.ResponseWriter, r *http.Request, req *materializeEnvRequest, opts *materialize.SecretsDirOptions) bool {
+	allowed := s.outputDirs(req.Stack)
+	for _, target := range []struct {
+		field string
+		path  *string
+	}{
+		{"out_path", &req.OutPath},
+		{"secrets_dir", &opts.Dir},
+	} {
+		if *target.path == "" {
+			continue
+		}
+		resolved, err := fsutil.Confine(*target.path, allowed)
+		if err == nil {
+			*target.path = resolved
+			continue
+		}
+		log.Warn().Err(err).Str("stack", req.Stack).Str(target.field, *target.path).Msg("materialize: output path rejected")
+		if s.auditor != nil {
+			s.logAudit(r.Context(), audit.Entry{
+				Action:   "materialize",
+				Stack:    req.Stack,
+				Delivery: req.delivery(),
+				Error:    target.field + " rejected: " + err.Error(),
+			})
+		}
+		writeError(w, r, http.StatusForbidden, CodePolicyDenied, target.field+" rejected: "+err.Error())
+		return false
 	}
-	if req.Stack == "" {
-		http.Error(w, "stack is required", http.StatusBadRequest)
-		return
+	if opts.Dir != "" {
+		req.SecretsDir = opts.Dir
 	}
+	return true
+}
 
-	// Parse env_content for op:// references
-	refs, err := resolver.ScanEnvFile(strings.NewReader(req.EnvContent))
-	if err != nil {
-		log.Error().Err(err).Str("stack", req.Stack).Msg("materialize: failed to scan env content")
-		http.Error(w, "failed to scan env content: "+err.Error(), http.StatusBadRequest)
-		return
+// delivery describes where the materialized secrets went, for audit records.
+func (req *materializeEnvRequest) delivery() []string {
+	var d []string
+	if req.OutPath != "" {
+		d = append(d, "env_file")
+	}
+	if req.SecretsDir != "" {
+		d = append(d, "secrets_dir")
 	}
+	return d
+}
 
-	if len(refs) == 0 {
-		// No secrets — return env content unchanged
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(materializeEnvResponse{
-			OutPath: req.OutPath,
-			Content: req.EnvContent,
-		}); err != nil {
-			log.Error().Err(err).Str("stack", req.Stack).Msg("materialize: encode response failed")
+// materializeAuditEntry builds the audit record for a materialization from
+// what actually happened: the providers that served values (not merely the
+// configured ones) and per-key provenance.
+func materializeAuditEntry(stack string, result *materialize.Result) audit.Entry {
+	prov := result.Provenance()
+	entries := make([]audit.Provenance, 0, len(prov))
+	for _, p := range prov {
+		entries = append(entries, audit.Provenance{
+			Key:             p.Key,
+			Ref:             p.Ref,
+			Outcome:         p.Outcome,
+			Provider:        p.Provider,
+			CacheAgeSeconds: p.CacheAgeSeconds,
+			StaleReason:     p.StaleReason,
+		})
+	}
+	return audit.Entry{
+		Action:     "materialize",
+		Stack:      stack,
+		Provider:   strings.Join(result.Providers(), ","),
+		CacheHit:   result.CacheHits > 0 && result.Resolved == 0,
+		Stale:      result.StaleHits > 0,
+		DurationMs: result.DurationMs,
+		Provenance: entries,
+	}
+}
+
+// stalePolicy converts the cache.stale config into a materializer policy.
+func (s *Server) stalePolicy() materialize.StalePolicy {
+	cfg := s.cfg.Cache.Stale
+	p := materialize.StalePolicy{
+		Mode:        cfg.Mode,
+		MaxAge:      time.Duration(cfg.MaxAge) * time.Second,
+		VaultMaxAge: make(map[string]time.Duration, len(cfg.VaultMaxAge)),
+		RefMaxAge:   make(map[string]time.Duration, len(cfg.RefMaxAge)),
+	}
+	for vault, secs := range cfg.VaultMaxAge {
+		p.VaultMaxAge[vault] = time.Duration(secs) * time.Second
+	}
+	for ref, secs := range cfg.RefMaxAge {
+		p.RefMaxAge[ref] = time.Duration(secs) * time.Second
+	}
+	return p
+}
+
+// usedPolicies returns the cache policies refs were served under, falling
+// back to the configured default.
+func (s *Server) usedPolicies(result *materialize.Result) []string {
+	if used := result.Policies(); len(used) > 0 {
+		return used
+	}
+	return []string{s.cfg.Cache.DefaultPolicy}
+}
+
+// validateRefOptions rejects annotations naming an unknown cache policy.
+func validateRefOptions(opts map[string]resolver.RefOption) error {
+	for uri, opt := range opts {
+		if opt.Policy != "" && !cache.ValidPolicy(opt.Policy) {
+			return fmt.Errorf("%s: unknown policy %q (want one of %s)", uri, opt.Policy, strings.Join(cache.Policies, ", "))
 		}
-		return
 	}
+	return nil
+}
+
+// materializeJob is one env or compose materialization, as run by
+// runMaterialize.
+type materializeJob struct {
+	stack       string
+	kind        string // "env" or "compose", for events and logs
+	mode        string
+	format      string
+	bypassCache bool
+	refs        map[string]*resolver.SecretRef
+	refOptions  map[string]resolver.RefOption
+	delivery    []string            // where the secrets go, for audit records
+	serviceRefs map[string][]string // compose service -> refs, for the index
 
+	// resolve runs the materializer and renders the output. It returns the
+	// result even when it fails.
+	resolve func(ctx context.Context, mat *materialize.EnvMaterializer) (*materialize.Result, error)
+}
+
+// runMaterialize resolves a job's refs and records the outcome in the stats,
+// audit log and event stream; a successful run also updates the stack index.
+// It returns the result (nil if nothing could run) and, on failure, the
+// status and error to answer with.
+func (s *Server) runMaterialize(r *http.Request, job materializeJob) (*materialize.Result, int, *apiError) {
 	if s.manager == nil {
-		http.Error(w, "no secret provider configured", http.StatusServiceUnavailable)
-		return
+		return nil, http.StatusServiceUnavailable, newAPIError(r, CodeUnavailable, "no secret provider configured")
 	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
 	store := s.cache
-	if req.BypassCache {
+	if job.bypassCache {
 		store = nil
 	}
 	mat := materialize.NewEnvMaterializer(store, s.manager, s.cfg.Cache.DefaultPolicy, s.cfg.Cache.DefaultTTL)
-	content, result, err := mat.Materialize(ctx, req.Stack, refs, req.EnvContent, req.OutPath)
+	mat.SetConcurrency(s.cfg.Materialize.Concurrency)
+	mat.SetMode(job.mode)
+	mat.SetFormat(job.format)
+	mat.SetRefOptions(job.refOptions)
+	mat.SetStalePolicy(s.stalePolicy())
+	result, err := job.resolve(ctx, mat)
 	if err != nil {
-		log.Error().Err(err).Str("stack", req.Stack).Str("out", req.OutPath).Msg("materialize: failed")
-		http.Error(w, "materialize failed: "+err.Error(), http.StatusInternalServerError)
-		return
+		log.Error().Err(err).Str("stack", job.stack).Str("kind", job.kind).Strs("delivery", job.delivery).Msg("materialize: failed")
+		s.statFailed.Add(int64(result.Failed))
+		if s.auditor != nil {
+			entry := materializeAuditEntry(job.stack, result)
+			entry.Delivery = job.delivery
+			entry.Error = err.Error()
+			s.logAudit(r.Context(), entry)
+		}
+		code := resolveErrorCode(result)
+		s.publishMaterialize(r.Context(), job.stack, job.kind, result, code, err)
+		return result, providerErrorStatus(code), newAPIError(r, code, "materialize failed: "+err.Error())
 	}
 
 	// Update stack index: tracks which stacks reference which 1Password items,
 	// enabling /v1/inventory queries and /v1/rotate/{item} targeted redeployment.
 	itemRefs := make(map[string][]string)
-	for rawURI, ref := range refs {
+	for rawURI, ref := range job.refs {
 		itemRefs[ref.Item] = append(itemRefs[ref.Item], rawURI)
 	}
-	s.index.Upsert(req.Stack, &StackInfo{
-		SecretCount: len(refs),
-		Providers:   s.manager.Names(),
-		Policies:    []string{s.cfg.Cache.DefaultPolicy},
+	s.index.Upsert(job.stack, &StackInfo{
+		SecretCount: len(job.refs),
+		Providers:   s.usedProviders(result),
+		Policies:    s.usedPolicies(result),
 		LastSynced:  time.Now(),
 		ItemRefs:    itemRefs,
+		ServiceRefs: job.serviceRefs,
+		RefOptions:  job.refOptions,
 	})
+	s.updatePolicyFloors()
 
 	s.statSyncs.Add(1)
 	s.statResolved.Add(int64(result.Resolved))
@@ -102,39 +297,151 @@ func (s *Server) handleMaterializeEnv(w http.ResponseWriter, r *http.Request) {
 	s.statFailed.Add(int64(result.Failed))
 
 	if s.auditor != nil {
-		providers := s.manager.Names()
-		provider := ""
-		if len(providers) > 0 {
-			provider = providers[0]
+		entry := materializeAuditEntry(job.stack, result)
+		entry.Delivery = job.delivery
+		if result.Failed > 0 {
+			entry.Error = fmt.Sprintf("%d ref(s) failed (lenient mode)", result.Failed)
 		}
-		s.auditor.Log(audit.Entry{
-			Action:     "materialize",
-			Stack:      req.Stack,
-			Provider:   provider,
-			CacheHit:   result.CacheHits > 0 && result.Resolved == 0,
-			DurationMs: result.DurationMs,
-		})
+		s.logAudit(r.Context(), entry)
 	}
+	s.publishMaterialize(r.Context(), job.stack, job.kind, result, "", nil)
 
 	log.Info().
-		Str("stack", req.Stack).
-		Str("out", req.OutPath).
+		Str("stack", job.stack).
+		Str("kind", job.kind).
+		Strs("delivery", job.delivery).
 		Int("resolved", result.Resolved).
 		Int("cache_hits", result.CacheHits).
 		Int("stale_hits", result.StaleHits).
+		Int("failed", result.Failed).
 		Int64("duration_ms", result.DurationMs).
 		Msg("materialize: complete")
+	return result, http.StatusOK, nil
+}
 
+// usedProviders returns the providers that served a result, falling back to
+// the configured providers when nothing was served (e.g. every ref failed).
+func (s *Server) usedProviders(result *materialize.Result) []string {
+	if used := result.Providers(); len(used) > 0 {
+		return used
+	}
+	return s.manager.Names()
+}
+
+func (s *Server) handleMaterializeEnv(w http.ResponseWriter, r *http.Request) {
+	var req materializeEnvRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "invalid request body")
+		return
+	}
+	if req.Stack == "" {
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "stack is required")
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = materialize.ModeStrict
+	}
+	if req.Mode != materialize.ModeStrict && req.Mode != materialize.ModeLenient {
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "mode must be \"strict\" or \"lenient\"")
+		return
+	}
+	if req.Format == "" {
+		req.Format = format.Env
+	}
+	if !format.Valid(req.Format) {
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "format must be one of: "+strings.Join(format.Formats, ", "))
+		return
+	}
+	var secretsOpts materialize.SecretsDirOptions
+	if req.SecretsDir != "" {
+		var err error
+		if secretsOpts, err = req.secretsDirOptions(); err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeBadRequest, err.Error())
+			return
+		}
+	}
+	if !s.confineOutputs(w, r, &req, &secretsOpts) {
+		return
+	}
+
+	// Parse env_content for op:// references
+	refs, err := resolver.ScanEnvFile(strings.NewReader(req.EnvContent))
+	if err != nil {
+		log.Error().Err(err).Str("stack", req.Stack).Msg("materialize: failed to scan env content")
+		writeError(w, r, http.StatusBadRequest, CodeRefInvalid, "failed to scan env content: "+err.Error())
+		return
+	}
+
+	if !authorizeRefs(w, r, req.Stack, refs) {
+		return
+	}
+	if !s.enforceAccessPolicy(w, r, req.Stack, refs, resolver.RefKeys(req.EnvContent)) {
+		return
+	}
+
+	refOptions, err := resolver.RefOptions(req.EnvContent)
+	if err == nil {
+		err = validateRefOptions(refOptions)
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeRefInvalid, "invalid herald annotation: "+err.Error())
+		return
+	}
+
+	resp := materializeEnvResponse{
+		materializeSummary: newMaterializeSummary(req.Mode, nil),
+		Format:             req.Format,
+		OutPath:            req.OutPath,
+	}
+	if len(refs) == 0 {
+		// No secrets — return env content unchanged (converted if a format was requested)
+		resp.Content = req.EnvContent
+		if req.Format != format.Env {
+			if resp.Content, err = format.Render(req.Format, req.Stack, format.Vars(req.EnvContent, nil)); err != nil {
+				writeError(w, r, http.StatusBadRequest, CodeBadRequest, "render "+req.Format+": "+err.Error())
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Error().Err(err).Str("stack", req.Stack).Msg("materialize: encode response failed")
+		}
+		return
+	}
+
+	result, status, apiErr := s.runMaterialize(r, materializeJob{
+		stack:       req.Stack,
+		kind:        "env",
+		mode:        req.Mode,
+		format:      req.Format,
+		bypassCache: req.BypassCache,
+		refs:        refs,
+		refOptions:  refOptions,
+		delivery:    req.delivery(),
+		resolve: func(ctx context.Context, mat *materialize.EnvMaterializer) (result *materialize.Result, err error) {
+			if req.SecretsDir != "" {
+				resp.Content, resp.SecretFiles, result, err = mat.MaterializeSecretsDir(ctx, req.Stack, refs, req.EnvContent, secretsOpts, req.OutPath)
+			} else {
+				resp.Content, result, err = mat.Materialize(ctx, req.Stack, refs, req.EnvContent, req.OutPath)
+			}
+			return result, err
+		},
+	})
+	if result == nil {
+		writeError(w, r, status, apiErr.Code, apiErr.Error)
+		return
+	}
+	resp.materializeSummary = newMaterializeSummary(req.Mode, result)
+	if apiErr == nil {
+		resp.SecretsDir = req.SecretsDir
+	} else {
+		resp.Content, resp.SecretFiles = "", nil
+	}
+	// A failure still returns the per-ref report so callers can see which key failed.
+	resp.apiError = apiErr
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(materializeEnvResponse{
-		Resolved:   result.Resolved,
-		CacheHits:  result.CacheHits,
-		StaleHits:  result.StaleHits,
-		Failed:     result.Failed,
-		DurationMs: result.DurationMs,
-		OutPath:    req.OutPath,
-		Content:    content,
-	}); err != nil {
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		log.Error().Err(err).Str("stack", req.Stack).Msg("materialize: encode response failed")
 	}
 }
@@ -0,0 +1,174 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/elabx-org/herald/internal/api"
+	"github.com/elabx-org/herald/internal/audit"
+	"github.com/elabx-org/herald/internal/cache"
+	"github.com/elabx-org/herald/internal/config"
+	"github.com/elabx-org/herald/internal/policy"
+	"github.com/elabx-org/herald/internal/provider"
+)
+
+func TestMaterializeOutPathRejected(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Materialize.OutputDirs = []string{t.TempDir()}
+	srv := api.NewServer(cfg, nil)
+
+	body := strings.NewReader(`{"stack":"myapp","out_path":"/etc/herald.env","env_content":"A=1\n"}`)
+	req := httptest.NewRequest(http.MethodPost, "/v1/materialize/env", body)
+	w := httptest.NewRecorder()
+
+	srv.Router().ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+}
+
+func TestMaterializeAccessPolicy(t *testing.T) {
+	cfg := &config.Config{}
+	p := &countingProvider{value: "secret"}
+	srv := api.NewServer(cfg, provider.NewManager([]provider.Provider{p}))
+	auditor, err := audit.New(t.TempDir() + "/audit.db")
+	if err != nil {
+		t.Fatalf("audit.New() error = %v", err)
+	}
+	defer auditor.Close()
+	srv.SetAuditor(auditor)
+	pol, err := policy.Parse([]byte("stacks:\n  myapp: [HomeLab/myapp]\n"))
+	if err != nil {
+		t.Fatalf("policy.Parse() error = %v", err)
+	}
+	srv.SetAccessPolicy(pol)
+
+	materialize := func(env string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"stack": "myapp", "env_content": env})
+		req := httptest.NewRequest(http.MethodPost, "/v1/materialize/env", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		srv.Router().ServeHTTP(w, req)
+		return w
+	}
+
+	w := materialize("DB=op://HomeLab/myapp/db\nADMIN_PASSWORD=op://HomeLab/root/password\n")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "ADMIN_PASSWORD") {
+		t.Errorf("body = %q, want the offending key", w.Body.String())
+	}
+	if n := p.calls.Load(); n != 0 {
+		t.Errorf("provider calls = %d, want 0 before the policy check passes", n)
+	}
+	entries, err := auditor.Query(audit.QueryOptions{Stack: "myapp"})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("audit entries = %+v, %v; want 1", entries, err)
+	}
+	if prov := entries[0].Provenance; len(prov) != 1 || prov[0].Key != "ADMIN_PASSWORD" || prov[0].Outcome != "denied" {
+		t.Errorf("audit provenance = %+v, want ADMIN_PASSWORD denied", prov)
+	}
+
+	if w := materialize("DB=op://HomeLab/myapp/db\n"); w.Code != http.StatusOK {
+		t.Errorf("allowed ref status = %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestMaterializeSharedRefPolicy checks that a policy=none annotation in one
+// stack keeps the secret out of the cache when another stack references it
+// without one.
+func TestMaterializeSharedRefPolicy(t *testing.T) {
+	store, err := cache.New(t.TempDir()+"/cache.db", "test-passphrase")
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	defer store.Close()
+
+	cfg := &config.Config{}
+	cfg.Cache.DefaultPolicy = cache.PolicyEncrypted
+	cfg.Cache.DefaultTTL = 3600
+	srv := api.NewServer(cfg, provider.NewManager([]provider.Provider{&countingProvider{value: "v"}}))
+	srv.SetCache(store)
+	materialize := func(stack, env string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/v1/materialize/env",
+			strings.NewReader(`{"stack":"`+stack+`","env_content":"`+env+`"}`))
+		w := httptest.NewRecorder()
+		srv.Router().ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("materialize %s status = %d: %s", stack, w.Code, w.Body.String())
+		}
+	}
+
+	// The unannotated stack caches the secret first; the annotation then
+	// purges that copy and keeps later syncs of either stack from storing it.
+	materialize("plain", `DB_PW=op://Vault/db/password\n`)
+	if _, err := store.Get("Vault/db/password"); err != nil {
+		t.Fatalf("Get() before annotation error = %v", err)
+	}
+	materialize("strict", `# herald: policy=none\nDB_PW=op://Vault/db/password\n`)
+	materialize("plain", `DB_PW=op://Vault/db/password\n`)
+	if _, err := store.GetStale("Vault/db/password"); err != cache.ErrNotFound {
+		t.Errorf("GetStale() after shared policy=none error = %v, want ErrNotFound", err)
+	}
+
+	// Dropping the annotation lifts the floor.
+	materialize("strict", `DB_PW=op://Vault/db/password\n`)
+	materialize("plain", `DB_PW=op://Vault/db/password\n`)
+	if got, err := store.Get("Vault/db/password"); err != nil || got.Policy != cache.PolicyEncrypted {
+		t.Errorf("Get() after annotation removed = %+v, %v; want encrypted entry", got, err)
+	}
+}
+
+func TestMaterializeCompose(t *testing.T) {
+	store, err := cache.New(t.TempDir()+"/cache.db", "test-passphrase")
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	defer store.Close()
+
+	cfg := &config.Config{}
+	cfg.Cache.DefaultPolicy = cache.PolicyEncrypted
+	cfg.Cache.DefaultTTL = 3600
+	srv := api.NewServer(cfg, provider.NewManager([]provider.Provider{&countingProvider{value: "v"}}))
+	srv.SetCache(store)
+
+	compose := `services:\n  db:\n    environment:\n      # herald: policy=none\n      POSTGRES_PASSWORD: op://Vault/db/password\n      APP_KEY: op://Vault/app/key\n`
+	body := `{"stack":"myapp","compose_content":"` + compose + `","output":"env_files","format":"json"}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/materialize/compose", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	srv.Router().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Resolved int               `json:"resolved"`
+		EnvFiles map[string]string `json:"env_files"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	var db map[string]string
+	if err := json.Unmarshal([]byte(resp.EnvFiles["db"]), &db); err != nil || db["POSTGRES_PASSWORD"] != "v" || db["APP_KEY"] != "v" {
+		t.Errorf("db env file = %q (%v), want JSON with both keys", resp.EnvFiles["db"], err)
+	}
+
+	// The annotation is honoured: only the unannotated ref is cached.
+	if _, err := store.GetStale("Vault/db/password"); err != cache.ErrNotFound {
+		t.Errorf("annotated ref cached: GetStale() error = %v, want ErrNotFound", err)
+	}
+	if _, err := store.Get("Vault/app/key"); err != nil {
+		t.Errorf("Get(Vault/app/key) error = %v", err)
+	}
+
+	body = `{"stack":"myapp","compose_content":"` + compose + `","format":"json"}`
+	req = httptest.NewRequest(http.MethodPost, "/v1/materialize/compose", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	srv.Router().ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("format with override output status = %d, want 400", w.Code)
+	}
+}
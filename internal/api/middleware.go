@@ -1,22 +1,58 @@
 package api
 
 import (
+	"context"
+	"crypto/subtle"
 	"net/http"
 	"strings"
+
+	"github.com/elabx-org/herald/internal/jwtauth"
+	"github.com/rs/zerolog/log"
 )
 
+// bearerAuth authenticates requests with HERALD_API_TOKEN, a named token, a
+// workload JWT or, when no Authorization header is sent, a mapped client
+// certificate. Authentication is disabled only while none of these exists.
 func (s *Server) bearerAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if s.cfg.APIToken == "" {
+		if s.cfg.APIToken == "" && !s.tokens.any() && len(s.certIDs) == 0 && s.jwt == nil {
 			next.ServeHTTP(w, r)
 			return
 		}
 		auth := r.Header.Get("Authorization")
 		token := strings.TrimPrefix(auth, "Bearer ")
-		if token != s.cfg.APIToken {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		var t *apiToken
+		if s.cfg.APIToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.APIToken)) == 1 {
+			t = masterToken
+		} else {
+			t = s.tokens.lookup(token)
+		}
+		if t == nil && s.jwt != nil && jwtauth.LooksLikeJWT(token) {
+			var err error
+			if t, err = s.jwtPrincipal(token); err != nil {
+				log.Warn().Err(err).Str("remote", r.RemoteAddr).Msg("jwt: token rejected")
+			}
+		}
+		if t == nil && auth == "" {
+			t = s.certPrincipal(r)
+		}
+		if t == nil {
+			writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "unauthorized")
 			return
 		}
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenCtxKey{}, t)))
 	})
 }
+
+// requireRole rejects requests whose token lacks role.
+func (s *Server) requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !requestToken(r.Context()).hasRole(role) {
+				writeError(w, r, http.StatusForbidden, CodeForbidden, "forbidden: token lacks the "+role+" role")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
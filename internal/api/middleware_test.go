@@ -1,8 +1,10 @@
 package api_test
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/elabx-org/herald/internal/api"
@@ -56,3 +58,55 @@ func TestAuthMiddlewareWithToken(t *testing.T) {
 		t.Errorf("status = %d, want 200", w.Code)
 	}
 }
+
+func TestScopedTokens(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.APIToken = "master-token"
+	srv := api.NewServer(cfg, nil)
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.Router().ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/v1/tokens", "master-token",
+		`{"name":"deploy-web","roles":["materialize"],"stacks":["web-*"],"vaults":["Infra"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create token status = %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Token string `json:"token"`
+	}
+	json.NewDecoder(w.Body).Decode(&created)
+	if !strings.HasPrefix(created.Token, "hrld_") {
+		t.Fatalf("created token = %q, want hrld_ prefix", created.Token)
+	}
+	if w := do(http.MethodGet, "/v1/tokens", "master-token", ""); strings.Contains(w.Body.String(), created.Token) {
+		t.Error("token list exposes the secret")
+	}
+
+	for _, tc := range []struct {
+		name, method, path, body string
+		want                     int
+	}{
+		{"allowed stack and vault", http.MethodPost, "/v1/materialize/env", `{"stack":"web-1","env_content":"A=op://Infra/app/a\n"}`, http.StatusServiceUnavailable},
+		{"other stack", http.MethodPost, "/v1/materialize/env", `{"stack":"db","env_content":"A=op://Infra/app/a\n"}`, http.StatusForbidden},
+		{"other vault", http.MethodPost, "/v1/materialize/env", `{"stack":"web-1","env_content":"A=op://HomeLab/app/a\n"}`, http.StatusForbidden},
+		{"read-only endpoint", http.MethodGet, "/v1/audit", "", http.StatusForbidden},
+		{"cache flush", http.MethodDelete, "/v1/cache", "", http.StatusForbidden},
+		{"token admin", http.MethodGet, "/v1/tokens", "", http.StatusForbidden},
+	} {
+		if w := do(tc.method, tc.path, created.Token, tc.body); w.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d (%s)", tc.name, w.Code, tc.want, w.Body.String())
+		}
+	}
+
+	if w := do(http.MethodDelete, "/v1/tokens/deploy-web", "master-token", ""); w.Code != http.StatusOK {
+		t.Fatalf("revoke status = %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/v1/materialize/env", created.Token, `{"stack":"web-1"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token status = %d, want 401", w.Code)
+	}
+}
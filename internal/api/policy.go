@@ -0,0 +1,52 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/elabx-org/herald/internal/audit"
+	"github.com/elabx-org/herald/internal/policy"
+	"github.com/elabx-org/herald/internal/resolver"
+	"github.com/rs/zerolog/log"
+)
+
+// SetAccessPolicy restricts the vaults and items each stack may reference.
+func (s *Server) SetAccessPolicy(p *policy.Policy) {
+	s.policy = p
+}
+
+// enforceAccessPolicy rejects, with 403, a materialization whose refs fall
+// outside the stack's access policy. Denials are audited with the offending
+// keys. It returns false if the request was rejected.
+func (s *Server) enforceAccessPolicy(w http.ResponseWriter, r *http.Request, stack string, refs map[string]*resolver.SecretRef, keys map[string][]string) bool {
+	denials := s.policy.Check(stack, refs, keys)
+	if len(denials) == 0 {
+		return true
+	}
+
+	var (
+		msgs []string
+		prov []audit.Provenance
+	)
+	for _, d := range denials {
+		msgs = append(msgs, fmt.Sprintf("%s (%s)", strings.Join(d.Keys, ", "), d.Ref))
+		for _, key := range d.Keys {
+			prov = append(prov, audit.Provenance{Key: key, Ref: d.Ref, Outcome: "denied"})
+		}
+	}
+	msg := "access policy denies stack " + stack + ": " + strings.Join(msgs, "; ")
+	log.Warn().Str("stack", stack).Int("denied", len(denials)).Msg("materialize: " + msg)
+	if s.auditor != nil {
+		s.logAudit(r.Context(), audit.Entry{
+			Action:     "materialize",
+			Stack:      stack,
+			Error:      msg,
+			Provenance: prov,
+		})
+	}
+	s.publishMaterialize(r.Context(), stack, "", nil, CodePolicyDenied, errors.New(msg))
+	writeError(w, r, http.StatusForbidden, CodePolicyDenied, "forbidden: "+msg)
+	return false
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/elabx-org/herald/internal/materialize"
 	"github.com/elabx-org/herald/internal/provisioner"
 	"github.com/rs/zerolog/log"
 )
@@ -31,25 +32,29 @@ type provisionResponse struct {
 func (s *Server) handleProvision(w http.ResponseWriter, r *http.Request) {
 	p := s.prov
 	if p == nil {
-		http.Error(w, "provisioning unavailable: OP_PROVISION_TOKEN not configured", http.StatusServiceUnavailable)
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "provisioning unavailable: OP_PROVISION_TOKEN not configured")
 		return
 	}
 
 	var req provisionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "invalid request body")
 		return
 	}
 	if req.Vault == "" {
-		http.Error(w, "vault is required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "vault is required")
 		return
 	}
 	if req.Item == "" {
-		http.Error(w, "item is required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "item is required")
+		return
+	}
+	if !requestToken(r.Context()).allowsVault(req.Vault) {
+		writeError(w, r, http.StatusForbidden, CodeForbidden, "forbidden: token not allowed for vault "+req.Vault)
 		return
 	}
 	if len(req.Fields) == 0 {
-		http.Error(w, "at least one field is required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "at least one field is required")
 		return
 	}
 
@@ -72,7 +77,8 @@ func (s *Server) handleProvision(w http.ResponseWriter, r *http.Request) {
 	})
 	if err != nil {
 		log.Error().Err(err).Str("vault", req.Vault).Str("item", req.Item).Msg("provision: failed")
-		http.Error(w, "provision failed: "+err.Error(), http.StatusInternalServerError)
+		code := errorCode(materialize.ClassifyError(err))
+		writeError(w, r, providerErrorStatus(code), code, "provision failed: "+err.Error())
 		return
 	}
 
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/elabx-org/herald/internal/cache"
+	"github.com/elabx-org/herald/internal/config"
+	"github.com/elabx-org/herald/internal/materialize"
+	"github.com/elabx-org/herald/internal/resolver"
+	"github.com/rs/zerolog/log"
+)
+
+// StartRefresher refreshes cached secrets nearing expiry every
+// cache.refresh_ahead.interval seconds, so deploy-time materializations hit a
+// warm cache. No-op unless refresh-ahead is enabled and a cache is wired.
+func (s *Server) StartRefresher(ctx context.Context) {
+	ra := s.cfg.Cache.RefreshAhead
+	if !ra.Enabled || s.cache == nil || s.manager == nil || ra.Interval <= 0 {
+		return
+	}
+	interval := time.Duration(ra.Interval) * time.Second
+	log.Info().Dur("interval", interval).Float64("window", ra.Window).Int("max_per_minute", ra.MaxPerMinute).Msg("cache refresher started")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n := s.RefreshAhead(ctx); n > 0 {
+				log.Debug().Int("refreshed", n).Msg("refresher: pass complete")
+			}
+		}
+	}
+}
+
+// RefreshAhead runs one refresh pass and returns how many entries it
+// refreshed. Only refs of stacks synced within cache.refresh_ahead.unused_days
+// are considered, and an entry is refreshed once less than window (a fraction
+// of its TTL) remains. Provider calls share the background budget (see
+// backgroundRate); a rate-limited provider ends the pass early.
+func (s *Server) RefreshAhead(ctx context.Context) int {
+	if s.cache == nil || s.manager == nil {
+		return 0
+	}
+	ra := s.cfg.Cache.RefreshAhead
+	var since time.Time
+	if ra.UnusedDays > 0 {
+		since = time.Now().Add(-time.Duration(ra.UnusedDays) * 24 * time.Hour)
+	}
+	refreshed := 0
+	for _, rawURI := range s.index.RefsSyncedSince(since) {
+		ref, err := resolver.ParseOpURI(rawURI)
+		if err != nil {
+			continue
+		}
+		cacheKey := fmt.Sprintf("%s/%s/%s", ref.Vault, ref.Item, ref.Field)
+		entry, err := s.cache.GetStale(cacheKey)
+		if err != nil || !dueForRefresh(entry, ra.Window) {
+			continue
+		}
+
+		if !s.pace.wait(ctx) {
+			return refreshed
+		}
+
+		val, providerName, err := s.resolveBackground(ctx, ref)
+		if err != nil {
+			s.statRefreshFailed.Add(1)
+			if materialize.ClassifyError(err) == materialize.ErrClassRateLimited {
+				log.Warn().Err(err).Msg("refresher: provider rate limited — ending pass early")
+				return refreshed
+			}
+			log.Warn().Err(err).Str("key", cacheKey).Msg("refresher: refresh failed")
+			continue
+		}
+		if err := s.cache.Set(cacheKey, &cache.Entry{
+			Value:     val,
+			Provider:  providerName,
+			Policy:    entry.Policy,
+			ExpiresAt: time.Now().Add(entry.ExpiresAt.Sub(entry.CreatedAt)),
+		}); err != nil {
+			log.Warn().Err(err).Str("key", cacheKey).Msg("refresher: cache write failed")
+			continue
+		}
+		s.statRefreshed.Add(1)
+		refreshed++
+	}
+	return refreshed
+}
+
+// dueForRefresh reports whether less than window of the entry's TTL remains
+// (including already expired entries). Entries without a known TTL are left
+// alone.
+func dueForRefresh(e *cache.Entry, window float64) bool {
+	if e.CreatedAt.IsZero() || e.Policy == cache.PolicyNone {
+		return false
+	}
+	ttl := e.ExpiresAt.Sub(e.CreatedAt)
+	if ttl <= 0 {
+		return false
+	}
+	return time.Until(e.ExpiresAt) <= time.Duration(float64(ttl)*window)
+}
+
+// backgroundResolveTimeout bounds each provider call of a background pass, so
+// one hung call costs a single ref rather than the whole pass.
+const backgroundResolveTimeout = 30 * time.Second
+
+// resolveBackground resolves ref for refresh-ahead, warm-up or change
+// detection. Callers wait on s.pace first.
+func (s *Server) resolveBackground(ctx context.Context, ref *resolver.SecretRef) (string, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, backgroundResolveTimeout)
+	defer cancel()
+	return s.manager.Resolve(ctx, ref.Vault, ref.Item, ref.Field)
+}
+
+// backgroundRate is the provider call budget shared by refresh-ahead, warm-up
+// and change detection: the smallest max_per_minute among the enabled ones,
+// so their combined rate never exceeds any configured cap. 0 is unlimited.
+func backgroundRate(cfg *config.Config) int {
+	rate := 0
+	for _, c := range []struct {
+		enabled bool
+		max     int
+	}{
+		{cfg.Cache.RefreshAhead.Enabled, cfg.Cache.RefreshAhead.MaxPerMinute},
+		{cfg.Cache.WarmUp.Enabled, cfg.Cache.WarmUp.MaxPerMinute},
+		{cfg.ChangeDetection.Enabled, cfg.ChangeDetection.MaxPerMinute},
+	} {
+		if c.enabled && c.max > 0 && (rate == 0 || c.max < rate) {
+			rate = c.max
+		}
+	}
+	return rate
+}
+
+// pacer spaces successive calls so at most perMinute happen per minute. It is
+// safe for concurrent use; concurrent callers take consecutive slots.
+type pacer struct {
+	gap time.Duration
+
+	mu   sync.Mutex
+	next time.Time // earliest start of the next call
+}
+
+func newPacer(perMinute int) *pacer {
+	p := &pacer{}
+	if perMinute > 0 {
+		p.gap = time.Minute / time.Duration(perMinute)
+	}
+	return p
+}
+
+// wait blocks until the next call is allowed. It returns false if ctx is
+// cancelled first.
+func (p *pacer) wait(ctx context.Context) bool {
+	if p.gap <= 0 {
+		return true
+	}
+	p.mu.Lock()
+	at := time.Now()
+	if p.next.After(at) {
+		at = p.next
+	}
+	p.next = at.Add(p.gap)
+	p.mu.Unlock()
+	if wait := time.Until(at); wait > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(wait):
+		}
+	}
+	return true
+}
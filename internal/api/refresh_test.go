@@ -0,0 +1,86 @@
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elabx-org/herald/internal/api"
+	"github.com/elabx-org/herald/internal/cache"
+	"github.com/elabx-org/herald/internal/config"
+	"github.com/elabx-org/herald/internal/provider"
+)
+
+type countingProvider struct {
+	value string
+	calls atomic.Int64
+}
+
+func (p *countingProvider) Name() string  { return "counting" }
+func (p *countingProvider) Priority() int { return 1 }
+func (p *countingProvider) Type() string  { return "mock" }
+func (p *countingProvider) Resolve(ctx context.Context, vault, item, field string) (string, error) {
+	p.calls.Add(1)
+	return p.value, nil
+}
+func (p *countingProvider) Healthy(ctx context.Context) (bool, int64, error) { return true, 1, nil }
+
+func TestRefreshAhead(t *testing.T) {
+	store, err := cache.New(t.TempDir()+"/cache.db", "test-passphrase")
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	defer store.Close()
+
+	cfg := &config.Config{}
+	cfg.Cache.DefaultPolicy = cache.PolicyMemory
+	cfg.Cache.DefaultTTL = 3600
+	cfg.Cache.RefreshAhead.Window = 0.2
+	p := &countingProvider{value: "v1"}
+	srv := api.NewServer(cfg, provider.NewManager([]provider.Provider{p}))
+	srv.SetCache(store)
+
+	body := `{"stack":"myapp","env_content":"A=op://vault/item/a\nB=op://vault/item/b\n"}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/materialize/env", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	srv.Router().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("materialize status = %d: %s", w.Code, w.Body.String())
+	}
+
+	// Fresh entries are left alone.
+	if n := srv.RefreshAhead(context.Background()); n != 0 {
+		t.Errorf("RefreshAhead() on fresh cache = %d, want 0", n)
+	}
+
+	// Age A into the last 20% of its TTL.
+	store.Set("vault/item/a", &cache.Entry{
+		Value:     "v1",
+		Policy:    cache.PolicyMemory,
+		CreatedAt: time.Now().Add(-55 * time.Minute),
+		ExpiresAt: time.Now().Add(5 * time.Minute),
+	})
+	p.value = "v2"
+	calls := p.calls.Load()
+	if n := srv.RefreshAhead(context.Background()); n != 1 {
+		t.Errorf("RefreshAhead() = %d, want 1", n)
+	}
+	if got := p.calls.Load() - calls; got != 1 {
+		t.Errorf("provider calls = %d, want 1", got)
+	}
+	entry, err := store.Get("vault/item/a")
+	if err != nil || entry.Value != "v2" || time.Until(entry.ExpiresAt) < 59*time.Minute {
+		t.Errorf("refreshed entry = %+v, %v; want v2 with a full TTL", entry, err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/stats", nil)
+	w = httptest.NewRecorder()
+	srv.Router().ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"total_refreshed":1`) {
+		t.Errorf("stats = %s, want total_refreshed 1", w.Body.String())
+	}
+}
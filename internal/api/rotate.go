@@ -29,6 +29,39 @@ func (s *Server) handleRotateVaultItemReal(w http.ResponseWriter, r *http.Reques
 }
 
 func (s *Server) doRotate(w http.ResponseWriter, r *http.Request, vault, itemID string) {
+	t := requestToken(r.Context())
+	if vault == "" && t.vaultRestricted() {
+		writeError(w, r, http.StatusForbidden, CodeForbidden, "forbidden: vault-restricted tokens must use /v1/rotate/{vault}/{itemID}")
+		return
+	}
+	if vault != "" && !t.allowsVault(vault) {
+		writeError(w, r, http.StatusForbidden, CodeForbidden, "forbidden: token not allowed for vault "+vault)
+		return
+	}
+	// Rotation invalidates and redeploys for every stack using the item, so
+	// a stack-restricted token must cover all of them.
+	if t.stackRestricted() {
+		stacks := s.stacksUsingItem(vault, itemID)
+		if len(stacks) == 0 {
+			writeError(w, r, http.StatusForbidden, CodeForbidden, "forbidden: item "+itemID+" is not used by any stack the token may act on")
+			return
+		}
+		for _, stack := range stacks {
+			if !t.allowsStack(stack) {
+				writeError(w, r, http.StatusForbidden, CodeForbidden, "forbidden: item "+itemID+" is also used by stack "+stack+" outside the token's scope")
+				return
+			}
+		}
+	}
+	resp := s.rotate(r.Context(), vault, itemID, "rotation-webhook", true)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// rotate invalidates the cache entries of an item (in any vault when vault is
+// empty) and, if redeploy is set, redeploys the stacks that reference it via
+// Komodo. triggeredBy is recorded in the audit log.
+func (s *Server) rotate(ctx context.Context, vault, itemID, triggeredBy string, redeploy bool) rotateResponse {
 	// Invalidate cache entries for this item
 	invalidated := 0
 	if s.cache != nil {
@@ -39,46 +72,65 @@ func (s *Server) doRotate(w http.ResponseWriter, r *http.Request, vault, itemID
 		}
 	}
 
-	// Detach from the HTTP request context so that Komodo deploys are not cancelled
+	// Find stacks that reference this item and redeploy
+	stacks := s.stacksUsingItem(vault, itemID)
+	s.publish(EventRotate, stacks, map[string]interface{}{
+		"vault": vault, "item": itemID, "entries_invalidated": invalidated, "triggered_by": triggeredBy,
+	})
+	redeployed := []string{}
+	if redeploy {
+		redeployed = s.redeployStacks(ctx, stacks, itemID, triggeredBy)
+	}
+	return rotateResponse{
+		ItemID:           itemID,
+		CacheInvalidated: invalidated,
+		StacksRedeployed: redeployed,
+	}
+}
+
+// stacksUsingItem returns the indexed stacks that reference itemID (in any
+// vault when vault is empty).
+func (s *Server) stacksUsingItem(vault, itemID string) []string {
+	if vault != "" {
+		return s.index.StacksForVaultAndItem(vault, itemID)
+	}
+	return s.index.StacksForItem(itemID)
+}
+
+// redeployStacks redeploys stacks via Komodo after their secrets were
+// invalidated, audits each redeploy as a rotation of secret, and returns the
+// stacks redeployed successfully. No-op without Komodo.
+func (s *Server) redeployStacks(ctx context.Context, stacks []string, secret, triggeredBy string) []string {
+	redeployed := []string{}
+	if s.komodo == nil {
+		return redeployed
+	}
+	// Detach from the caller's context so that Komodo deploys are not cancelled
 	// if the caller disconnects before all stacks finish redeploying.
-	deployCtx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Minute)
+	deployCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Minute)
 	defer cancel()
 
-	// Find stacks that reference this item and redeploy
-	var redeployed []string
-	if s.komodo != nil {
-		var stacks []string
-		if vault != "" {
-			stacks = s.index.StacksForVaultAndItem(vault, itemID)
-		} else {
-			stacks = s.index.StacksForItem(itemID)
+	for _, stack := range stacks {
+		if err := s.komodo.DeployStack(deployCtx, stack); err != nil {
+			log.Error().Err(err).Str("stack", stack).Msg("failed to redeploy after rotation")
+			s.publish(EventRedeploy, []string{stack}, map[string]interface{}{
+				"status": "failed", "error": err.Error(), "secret": secret, "triggered_by": triggeredBy,
+			})
+			continue
 		}
-		for _, stack := range stacks {
-			if err := s.komodo.DeployStack(deployCtx, stack); err != nil {
-				log.Error().Err(err).Str("stack", stack).Msg("failed to redeploy after rotation")
-				continue
-			}
-			redeployed = append(redeployed, stack)
-			if s.auditor != nil {
-				s.auditor.Log(audit.Entry{
-					Action:      "rotate",
-					Stack:       stack,
-					Secret:      itemID,
-					TriggeredBy: "rotation-webhook",
-					Timestamp:   time.Now().UTC(),
-				})
-			}
+		redeployed = append(redeployed, stack)
+		s.publish(EventRedeploy, []string{stack}, map[string]interface{}{
+			"status": "ok", "secret": secret, "triggered_by": triggeredBy,
+		})
+		if s.auditor != nil {
+			s.logAudit(ctx, audit.Entry{
+				Action:      "rotate",
+				Stack:       stack,
+				Secret:      secret,
+				TriggeredBy: triggeredBy,
+				Timestamp:   time.Now().UTC(),
+			})
 		}
 	}
-
-	if redeployed == nil {
-		redeployed = []string{}
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(rotateResponse{
-		ItemID:           itemID,
-		CacheInvalidated: invalidated,
-		StacksRedeployed: redeployed,
-	})
+	return redeployed
 }
@@ -11,7 +11,9 @@ import (
 	"github.com/elabx-org/herald/internal/audit"
 	"github.com/elabx-org/herald/internal/cache"
 	"github.com/elabx-org/herald/internal/config"
+	"github.com/elabx-org/herald/internal/jwtauth"
 	"github.com/elabx-org/herald/internal/komodo"
+	"github.com/elabx-org/herald/internal/policy"
 	"github.com/elabx-org/herald/internal/provider"
 	"github.com/elabx-org/herald/internal/provisioner"
 	"github.com/go-chi/chi/v5"
@@ -30,6 +32,10 @@ type Server struct {
 	komodo  *komodo.Client
 	prov    provisioner.Provisionable
 	index   *Index
+	tokens  *tokenStore
+	policy  *policy.Policy // nil allows every stack every ref
+	certIDs []certIdentity // client certificate identities, set by TLSConfig
+	jwt     *jwtauth.Verifier
 
 	healthMu        sync.RWMutex
 	healthCached    *HealthResponse
@@ -41,6 +47,18 @@ type Server struct {
 	statCacheHits  atomic.Int64
 	statStaleHits  atomic.Int64
 	statFailed     atomic.Int64
+
+	statRefreshed     atomic.Int64 // entries refreshed ahead of expiry
+	statRefreshFailed atomic.Int64
+
+	statChanges atomic.Int64 // items rotated by the change detector
+
+	floorsMu sync.Mutex // serializes updatePolicyFloors
+
+	warmUp  warmUpTracker
+	changes changeDetector
+	events  *eventBus
+	pace    *pacer // provider budget shared by the background resolvers
 }
 
 func NewServer(cfg *config.Config, manager *provider.Manager) *Server {
@@ -48,6 +66,9 @@ func NewServer(cfg *config.Config, manager *provider.Manager) *Server {
 		cfg:     cfg,
 		manager: manager,
 		index:   NewIndex(),
+		tokens:  newTokenStore(),
+		events:  newEventBus(cfg.Events.BufferSize),
+		pace:    newPacer(backgroundRate(cfg)),
 	}
 	s.router = chi.NewRouter()
 	s.router.Use(middleware.RequestID)
@@ -68,6 +89,8 @@ func (s *Server) SetAuditor(a *audit.Logger) {
 func (s *Server) SetCache(c *cache.Store) {
 	s.cache = c
 	s.index.SetDB(c.DB())
+	s.tokens.SetDB(c.DB())
+	s.updatePolicyFloors()
 }
 
 func (s *Server) SetKomodo(k *komodo.Client) {
@@ -90,6 +113,13 @@ func (s *Server) provisionerType() string {
 }
 
 func (s *Server) mountRoutes() {
+	s.router.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "no route for "+r.Method+" "+r.URL.Path)
+	})
+	s.router.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeBadRequest, "method "+r.Method+" not allowed for "+r.URL.Path)
+	})
+
 	// Public (no auth)
 	s.router.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -97,8 +127,10 @@ func (s *Server) mountRoutes() {
 	})
 	s.router.Get("/v1/health", s.handleHealth)
 	s.router.Get("/v1/stats", s.handleStats)
+	s.router.Get("/v1/ready", s.handleReady)
 
-	// Protected routes (bearer token required when APIToken is set)
+	// Protected routes (bearer token, JWT or client certificate required when
+	// APIToken, named tokens, a JWKS or certificate identities are set)
 	s.router.Group(func(r chi.Router) {
 		r.Use(s.bearerAuth)
 		r.Use(func(next http.Handler) http.Handler {
@@ -107,23 +139,47 @@ func (s *Server) mountRoutes() {
 				next.ServeHTTP(w, r)
 			})
 		})
-		r.Post("/v1/materialize/env", s.handleMaterializeEnv)
-		r.Post("/v1/provision", s.handleProvision)
-		r.Get("/v1/audit", s.handleAudit)
-		r.Get("/v1/inventory", s.handleInventory)
-		r.Get("/v1/inventory/{stack}", s.handleInventoryStack)
-		r.Post("/v1/rotate/{itemID}", s.handleRotate)
-		r.Post("/v1/rotate/{vault}/{itemID}", s.handleRotateVaultItem)
-		r.Delete("/v1/cache/{stack}", s.handleCacheDelete)
-		r.Delete("/v1/cache", s.handleCacheFlush)
+		r.Post("/v1/lint", s.handleLint)
+
+		materialize := r.With(s.requireRole(RoleMaterialize))
+		materialize.Post("/v1/materialize/env", s.handleMaterializeEnv)
+		materialize.Post("/v1/materialize/compose", s.handleMaterializeCompose)
+
+		r.With(s.requireRole(RoleProvision)).Post("/v1/provision", s.handleProvision)
+
+		read := r.With(s.requireRole(RoleReadOnly))
+		read.Get("/v1/audit", s.handleAudit)
+		read.Get("/v1/inventory", s.handleInventory)
+		read.Get("/v1/inventory/{stack}", s.handleInventoryStack)
+		read.Get("/v1/cache", s.handleCacheList)
+		read.Get("/v1/cache/{vault}/{item}", s.handleCacheList)
+		read.Get("/v1/events", s.handleEvents)
+
+		rotate := r.With(s.requireRole(RoleRotate))
+		rotate.Post("/v1/rotate/{itemID}", s.handleRotate)
+		rotate.Post("/v1/rotate/{vault}/{itemID}", s.handleRotateVaultItem)
+		rotate.Delete("/v1/cache/{stack}", s.handleCacheDelete)
+		rotate.Delete("/v1/cache", s.handleCacheFlush) // a full flush also needs admin
+
+		admin := r.With(s.requireRole(RoleAdmin))
+		admin.Post("/v1/cache/rekey", s.handleCacheRekey)
+		admin.Get("/v1/admin/snapshot", s.handleSnapshot)
+		admin.Get("/v1/tokens", s.handleTokenList)
+		admin.Post("/v1/tokens", s.handleTokenCreate)
+		admin.Delete("/v1/tokens/{name}", s.handleTokenRevoke)
 	})
 }
 
 func (s *Server) Start(ctx context.Context) error {
 	addr := fmt.Sprintf("%s:%d", s.cfg.Server.Host, s.cfg.Server.Port)
+	tlsConfig, err := s.TLSConfig()
+	if err != nil {
+		return err
+	}
 	srv := &http.Server{
 		Addr:         addr,
 		Handler:      s.router,
+		TLSConfig:    tlsConfig,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 60 * time.Second,
 		IdleTimeout:  60 * time.Second,
@@ -131,6 +187,11 @@ func (s *Server) Start(ctx context.Context) error {
 
 	errCh := make(chan error, 1)
 	go func() {
+		if tlsConfig != nil {
+			log.Info().Str("addr", addr).Bool("mtls", s.cfg.Server.TLS.ClientCAFile != "").Msg("herald listening (TLS)")
+			errCh <- srv.ListenAndServeTLS("", "")
+			return
+		}
 		log.Info().Str("addr", addr).Msg("herald listening")
 		errCh <- srv.ListenAndServe()
 	}()
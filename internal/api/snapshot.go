@@ -0,0 +1,53 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// handleSnapshot streams an encrypted snapshot of the cache database —
+// entries, key metadata and the stack index. Restore it with
+// `herald snapshot restore`.
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if s.cache == nil {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "cache disabled")
+		return
+	}
+	if s.cache.MemoryOnly() {
+		writeError(w, r, http.StatusConflict, CodeConflict, "cache is memory-only")
+		return
+	}
+	name := fmt.Sprintf("herald-snapshot-%s.hsnap", time.Now().UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	sw := &startedWriter{w: w}
+	info, err := s.cache.Snapshot(sw)
+	if err != nil {
+		log.Error().Err(err).Msg("snapshot: failed")
+		if sw.started {
+			// The status line and part of the body are already out; a JSON
+			// envelope would only corrupt the download further.
+			return
+		}
+		w.Header().Del("Content-Disposition")
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "snapshot failed: "+err.Error())
+		return
+	}
+	log.Info().Int64("size", info.Size).Uint32("key_version", info.KeyVersion).Msg("snapshot: exported")
+}
+
+// startedWriter records whether the response body has been written to, which
+// commits the status line and headers.
+type startedWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (s *startedWriter) Write(p []byte) (int, error) {
+	s.started = true
+	return s.w.Write(p)
+}
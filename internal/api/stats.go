@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"net/http"
 	"time"
+
+	"github.com/elabx-org/herald/internal/cache"
 )
 
 type statsResponse struct {
@@ -14,6 +16,10 @@ type statsResponse struct {
 	TotalStaleHits int64  `json:"total_stale_hits"`
 	TotalFailed   int64   `json:"total_failed"`
 	CacheHitRate  float64 `json:"cache_hit_rate"` // 0.0–1.0, fraction of fetches served from cache
+	TotalRefreshed     int64 `json:"total_refreshed"`      // cache entries refreshed ahead of expiry
+	TotalRefreshFailed int64 `json:"total_refresh_failed"`
+	TotalChangesDetected int64 `json:"total_changes_detected"` // items rotated after a provider-side change
+	Cache         *cache.Stats `json:"cache,omitempty"` // nil when the cache is disabled
 }
 
 func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
@@ -29,6 +35,12 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 		hitRate = float64(cacheHits) / float64(total)
 	}
 
+	var cacheStats *cache.Stats
+	if s.cache != nil {
+		st := s.cache.Stats()
+		cacheStats = &st
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(statsResponse{
 		UptimeSeconds:  int64(time.Since(startTime).Seconds()),
@@ -38,5 +50,9 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 		TotalStaleHits: staleHits,
 		TotalFailed:    failed,
 		CacheHitRate:   hitRate,
+		TotalRefreshed:     s.statRefreshed.Load(),
+		TotalRefreshFailed: s.statRefreshFailed.Load(),
+		TotalChangesDetected: s.statChanges.Load(),
+		Cache:          cacheStats,
 	})
 }
@@ -36,6 +36,10 @@ func (s *Server) handleRotateVaultItem(w http.ResponseWriter, r *http.Request) {
 // Cache keys are vault/item/field — we derive the exact keys from the stack's item refs.
 func (s *Server) handleCacheDelete(w http.ResponseWriter, r *http.Request) {
 	stack := chi.URLParam(r, "stack")
+	if !requestToken(r.Context()).allowsStack(stack) {
+		writeError(w, r, http.StatusForbidden, CodeForbidden, "forbidden: token not allowed for stack "+stack)
+		return
+	}
 	deleted := 0
 	if s.cache != nil && stack != "" {
 		if info, ok := s.index.Get(stack); ok {
@@ -51,15 +55,28 @@ func (s *Server) handleCacheDelete(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	s.index.Delete(stack)
+	s.updatePolicyFloors()
+	s.publish(EventCacheInvalidate, []string{stack}, map[string]interface{}{"scope": "stack", "entries_deleted": deleted})
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "stack": stack, "entries_deleted": deleted})
 }
 
-// handleCacheFlush purges the entire cache.
+// handleCacheFlush purges the entire cache, or only the matching entries when
+// vault, item or field filters are given.
 func (s *Server) handleCacheFlush(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Has("vault") || q.Has("item") || q.Has("field") {
+		s.handleCacheInvalidate(w, r)
+		return
+	}
+	if !requestToken(r.Context()).hasRole(RoleAdmin) {
+		writeError(w, r, http.StatusForbidden, CodeForbidden, "forbidden: flushing the whole cache needs the admin role")
+		return
+	}
 	if s.cache != nil {
 		s.cache.Flush()
 	}
+	s.publish(EventCacheInvalidate, nil, map[string]interface{}{"scope": "all"})
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok"})
 }
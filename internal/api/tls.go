@@ -0,0 +1,191 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/elabx-org/herald/internal/config"
+	"github.com/gobwas/glob"
+	"github.com/rs/zerolog/log"
+)
+
+// certReloader serves the configured certificate and client CA, re-reading
+// the files when their modification times change.
+type certReloader struct {
+	certFile, keyFile, caFile string
+	interval                  time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	pool      *x509.CertPool
+	modTimes  [3]time.Time
+	checkedAt time.Time
+}
+
+func newCertReloader(certFile, keyFile, caFile string, interval time.Duration) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile, caFile: caFile, interval: interval}
+	if err := c.load(c.stat()); err != nil {
+		return nil, err
+	}
+	c.checkedAt = time.Now()
+	return c, nil
+}
+
+func (c *certReloader) stat() [3]time.Time {
+	var mt [3]time.Time
+	for i, path := range []string{c.certFile, c.keyFile, c.caFile} {
+		if path == "" {
+			continue
+		}
+		if fi, err := os.Stat(path); err == nil {
+			mt[i] = fi.ModTime()
+		}
+	}
+	return mt
+}
+
+func (c *certReloader) load(mt [3]time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("load TLS certificate: %w", err)
+	}
+	var pool *x509.CertPool
+	if c.caFile != "" {
+		data, err := os.ReadFile(c.caFile)
+		if err != nil {
+			return fmt.Errorf("load client CA: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("load client CA: no certificates in %s", c.caFile)
+		}
+	}
+	c.cert, c.pool, c.modTimes = &cert, pool, mt
+	return nil
+}
+
+// current returns the certificate and client CA pool, reloading them first
+// if the files changed since the last check. A failed reload keeps serving
+// the previous pair.
+func (c *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checkedAt) >= c.interval {
+		c.checkedAt = time.Now()
+		if mt := c.stat(); mt != c.modTimes {
+			if err := c.load(mt); err != nil {
+				log.Warn().Err(err).Msg("tls: reload failed — keeping the previous certificate")
+			} else {
+				log.Info().Str("cert", c.certFile).Msg("tls: certificate reloaded")
+			}
+		}
+	}
+	return c.cert, c.pool
+}
+
+// TLSConfig builds the HTTPS configuration from server.tls, or returns nil
+// when no certificate is configured. It also compiles the client certificate
+// identities used by bearerAuth.
+func (s *Server) TLSConfig() (*tls.Config, error) {
+	tc := s.cfg.Server.TLS
+	if tc.CertFile == "" && tc.KeyFile == "" {
+		if tc.ClientCAFile != "" || len(tc.Clients) > 0 {
+			return nil, errors.New("server.tls: client certificates need cert_file and key_file")
+		}
+		return nil, nil
+	}
+	if tc.CertFile == "" || tc.KeyFile == "" {
+		return nil, errors.New("server.tls: cert_file and key_file must be set together")
+	}
+	if (tc.RequireClientCert || len(tc.Clients) > 0) && tc.ClientCAFile == "" {
+		return nil, errors.New("server.tls: client certificates need client_ca_file")
+	}
+	ids, err := compileCertIdentities(tc.Clients)
+	if err != nil {
+		return nil, err
+	}
+	reloader, err := newCertReloader(tc.CertFile, tc.KeyFile, tc.ClientCAFile, time.Duration(tc.ReloadInterval)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	s.certIDs = ids
+
+	clientAuth := tls.NoClientCert
+	switch {
+	case tc.RequireClientCert:
+		clientAuth = tls.RequireAndVerifyClientCert
+	case tc.ClientCAFile != "":
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+	getCertificate := func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, _ := reloader.current()
+		return cert, nil
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: getCertificate,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			_, pool := reloader.current()
+			return &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: getCertificate,
+				ClientAuth:     clientAuth,
+				ClientCAs:      pool,
+			}, nil
+		},
+	}, nil
+}
+
+// certIdentity maps a client certificate subject to a token-like principal.
+type certIdentity struct {
+	subject glob.Glob
+	token   *apiToken
+}
+
+func compileCertIdentities(clients []config.TLSClient) ([]certIdentity, error) {
+	ids := make([]certIdentity, 0, len(clients))
+	for _, c := range clients {
+		t := &apiToken{Name: c.Name, Roles: c.Roles, Stacks: c.Stacks, Vaults: c.Vaults}
+		if err := t.compile(); err != nil {
+			return nil, fmt.Errorf("server.tls.clients: %w", err)
+		}
+		if c.Subject == "" {
+			return nil, fmt.Errorf("server.tls.clients: %s: subject is required", c.Name)
+		}
+		g, err := glob.Compile(c.Subject)
+		if err != nil {
+			return nil, fmt.Errorf("server.tls.clients: %s: subject %q: %w", c.Name, c.Subject, err)
+		}
+		ids = append(ids, certIdentity{subject: g, token: t})
+	}
+	return ids, nil
+}
+
+// certPrincipal returns the identity of the request's verified client
+// certificate, or nil. The first identity whose subject glob matches the
+// common name or any DNS, URI or email SAN wins.
+func (s *Server) certPrincipal(r *http.Request) *apiToken {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	leaf := r.TLS.PeerCertificates[0]
+	names := append([]string{leaf.Subject.CommonName}, leaf.DNSNames...)
+	names = append(names, leaf.EmailAddresses...)
+	for _, u := range leaf.URIs {
+		names = append(names, u.String())
+	}
+	for _, id := range s.certIDs {
+		for _, name := range names {
+			if name != "" && id.subject.Match(name) {
+				return id.token
+			}
+		}
+	}
+	return nil
+}
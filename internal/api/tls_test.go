@@ -0,0 +1,93 @@
+package api_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elabx-org/herald/internal/api"
+	"github.com/elabx-org/herald/internal/config"
+	"github.com/elabx-org/herald/internal/pki"
+)
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	if err := pki.Init(dir, "test CA"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pki.IssueServer(dir, "server", []string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pki.IssueClient(dir, "komodo"); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{}
+	cfg.Server.TLS.CertFile = filepath.Join(dir, "server.crt")
+	cfg.Server.TLS.KeyFile = filepath.Join(dir, "server.key")
+	cfg.Server.TLS.ClientCAFile = filepath.Join(dir, pki.CACert)
+	cfg.Server.TLS.Clients = []config.TLSClient{
+		{Name: "komodo", Subject: "komodo", Roles: []string{api.RoleMaterialize}, Stacks: []string{"myapp"}},
+	}
+	srv := api.NewServer(cfg, nil)
+	tlsConfig, err := srv.TLSConfig()
+	if err != nil {
+		t.Fatalf("TLSConfig() error = %v", err)
+	}
+	ts := httptest.NewUnstartedServer(srv.Router())
+	ts.TLS = tlsConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	caPEM, _ := os.ReadFile(cfg.Server.TLS.ClientCAFile)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "komodo.crt"), filepath.Join(dir, "komodo.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certs},
+			DisableKeepAlives: true,
+		}}
+	}
+	materialize := func(c *http.Client, stack string) *http.Response {
+		t.Helper()
+		resp, err := c.Post(ts.URL+"/v1/materialize/env", "application/json",
+			strings.NewReader(`{"stack":"`+stack+`","env_content":"A=1\n"}`))
+		if err != nil {
+			t.Fatalf("request error = %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	withCert := client(clientCert)
+	if resp := materialize(withCert, "myapp"); resp.StatusCode != http.StatusOK {
+		t.Errorf("mapped client cert status = %d, want 200", resp.StatusCode)
+	}
+	if resp := materialize(withCert, "other"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("stack outside the identity's scope status = %d, want 403", resp.StatusCode)
+	}
+	if resp := materialize(client(), "myapp"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("no client cert status = %d, want 401", resp.StatusCode)
+	}
+
+	// Renewing the server certificate takes effect without a restart.
+	first := materialize(withCert, "myapp").TLS.PeerCertificates[0].SerialNumber
+	if err := pki.IssueServer(dir, "server", []string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(cfg.Server.TLS.CertFile, later, later)
+	if second := materialize(withCert, "myapp").TLS.PeerCertificates[0].SerialNumber; second.Cmp(first) == 0 {
+		t.Error("server certificate was not reloaded")
+	}
+}
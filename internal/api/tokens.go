@@ -0,0 +1,372 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elabx-org/herald/internal/resolver"
+	"github.com/go-chi/chi/v5"
+	"github.com/gobwas/glob"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Token roles. Admin implies every other role.
+const (
+	RoleMaterialize = "materialize"
+	RoleProvision   = "provision"
+	RoleRotate      = "rotate"
+	RoleAdmin       = "admin"
+	RoleReadOnly    = "read-only"
+)
+
+// Roles lists every token role.
+var Roles = []string{RoleMaterialize, RoleProvision, RoleRotate, RoleAdmin, RoleReadOnly}
+
+var tokensBucket = []byte("tokens")
+
+// tokenPrefix marks Herald API tokens, so leaked ones are easy to grep for.
+const tokenPrefix = "hrld_"
+
+var tokenNameRE = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// masterTokenName is recorded for requests authenticated with HERALD_API_TOKEN.
+const masterTokenName = "api-token"
+
+// apiToken is a named API token. Only the SHA-256 of the secret is kept.
+type apiToken struct {
+	Name      string    `json:"name"`
+	Hash      []byte    `json:"hash"`
+	Roles     []string  `json:"roles"`
+	Stacks    []string  `json:"stacks,omitempty"` // globs; empty allows every stack
+	Vaults    []string  `json:"vaults,omitempty"` // globs; empty allows every vault
+	CreatedAt time.Time `json:"created_at"`
+
+	stackGlobs []glob.Glob
+	vaultGlobs []glob.Glob
+}
+
+// masterToken is the principal for HERALD_API_TOKEN: an unrestricted admin.
+var masterToken = &apiToken{Name: masterTokenName, Roles: []string{RoleAdmin}}
+
+// compile validates the token's roles and scopes and prepares its globs.
+func (t *apiToken) compile() error {
+	if !tokenNameRE.MatchString(t.Name) || t.Name == masterTokenName {
+		return fmt.Errorf("invalid token name %q", t.Name)
+	}
+	if len(t.Roles) == 0 {
+		return fmt.Errorf("at least one role is required")
+	}
+	for _, role := range t.Roles {
+		if !slices.Contains(Roles, role) {
+			return fmt.Errorf("unknown role %q (want one of materialize, provision, rotate, admin, read-only)", role)
+		}
+	}
+	var err error
+	if t.stackGlobs, err = compileGlobs(t.Stacks); err != nil {
+		return fmt.Errorf("stacks: %w", err)
+	}
+	if t.vaultGlobs, err = compileGlobs(t.Vaults); err != nil {
+		return fmt.Errorf("vaults: %w", err)
+	}
+	return nil
+}
+
+func compileGlobs(patterns []string) ([]glob.Glob, error) {
+	globs := make([]glob.Glob, 0, len(patterns))
+	for _, p := range patterns {
+		g, err := glob.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", p, err)
+		}
+		globs = append(globs, g)
+	}
+	return globs, nil
+}
+
+func matchAny(globs []glob.Glob, s string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	for _, g := range globs {
+		if g.Match(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRole reports whether the token grants role. A nil token (auth disabled)
+// grants everything.
+func (t *apiToken) hasRole(role string) bool {
+	return t == nil || slices.Contains(t.Roles, RoleAdmin) || slices.Contains(t.Roles, role)
+}
+
+// allowsStack reports whether the token may act on stack.
+func (t *apiToken) allowsStack(stack string) bool {
+	return t == nil || matchAny(t.stackGlobs, stack)
+}
+
+// allowsVault reports whether the token may read or change secrets in vault.
+func (t *apiToken) allowsVault(vault string) bool {
+	return t == nil || matchAny(t.vaultGlobs, vault)
+}
+
+// vaultRestricted reports whether the token is limited to some vaults.
+func (t *apiToken) vaultRestricted() bool {
+	return t != nil && len(t.vaultGlobs) > 0
+}
+
+// stackRestricted reports whether the token is limited to some stacks.
+func (t *apiToken) stackRestricted() bool {
+	return t != nil && len(t.stackGlobs) > 0
+}
+
+// authorizeRefs rejects, with 403, a request for stack or for refs outside
+// the token's stacks and vaults. It returns false if the request was rejected.
+func authorizeRefs(w http.ResponseWriter, r *http.Request, stack string, refs map[string]*resolver.SecretRef) bool {
+	t := requestToken(r.Context())
+	if !t.allowsStack(stack) {
+		writeError(w, r, http.StatusForbidden, CodeForbidden, "forbidden: token not allowed for stack "+stack)
+		return false
+	}
+	for _, ref := range refs {
+		if !t.allowsVault(ref.Vault) {
+			writeError(w, r, http.StatusForbidden, CodeForbidden, "forbidden: token not allowed for vault "+ref.Vault)
+			return false
+		}
+	}
+	return true
+}
+
+type tokenCtxKey struct{}
+
+// requestToken returns the token that authenticated the request, or nil when
+// authentication is disabled.
+func requestToken(ctx context.Context) *apiToken {
+	t, _ := ctx.Value(tokenCtxKey{}).(*apiToken)
+	return t
+}
+
+// tokenName returns the name of the token behind ctx, for audit records.
+func tokenName(ctx context.Context) string {
+	if t := requestToken(ctx); t != nil {
+		return t.Name
+	}
+	return ""
+}
+
+// tokenStore holds the named API tokens. When a bbolt DB is provided via
+// SetDB, tokens survive Herald restarts.
+type tokenStore struct {
+	mu     sync.RWMutex
+	tokens map[string]*apiToken
+	db     *bolt.DB
+}
+
+func newTokenStore() *tokenStore {
+	return &tokenStore{tokens: make(map[string]*apiToken)}
+}
+
+// SetDB wires a bbolt database for persistence and loads stored tokens.
+func (ts *tokenStore) SetDB(db *bolt.DB) {
+	if db == nil {
+		return
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(tokensBucket)
+		return err
+	}); err != nil {
+		log.Error().Err(err).Msg("tokens: failed to create bucket")
+		return
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(tokensBucket).ForEach(func(k, v []byte) error {
+			var t apiToken
+			if err := json.Unmarshal(v, &t); err != nil {
+				log.Warn().Str("token", string(k)).Err(err).Msg("tokens: skipping corrupt entry")
+				return nil
+			}
+			if err := t.compile(); err != nil {
+				log.Warn().Str("token", string(k)).Err(err).Msg("tokens: skipping invalid entry")
+				return nil
+			}
+			ts.tokens[t.Name] = &t
+			return nil
+		})
+	}); err != nil {
+		log.Error().Err(err).Msg("tokens: failed to load persisted tokens")
+		return
+	}
+	ts.db = db
+	log.Info().Int("tokens", len(ts.tokens)).Msg("tokens: loaded from persistent store")
+}
+
+// any reports whether any named token exists.
+func (ts *tokenStore) any() bool {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return len(ts.tokens) > 0
+}
+
+// lookup returns the token whose secret is secret. Every stored hash is
+// compared in constant time, so timing reveals neither which token matched
+// nor how much of it.
+func (ts *tokenStore) lookup(secret string) *apiToken {
+	sum := sha256.Sum256([]byte(secret))
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	var found *apiToken
+	for _, t := range ts.tokens {
+		if subtle.ConstantTimeCompare(sum[:], t.Hash) == 1 {
+			found = t
+		}
+	}
+	return found
+}
+
+func (ts *tokenStore) list() []*apiToken {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	list := make([]*apiToken, 0, len(ts.tokens))
+	for _, t := range ts.tokens {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// create stores t under a newly generated secret and returns the secret.
+func (ts *tokenStore) create(t *apiToken) (string, error) {
+	if err := t.compile(); err != nil {
+		return "", err
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	secret := tokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	sum := sha256.Sum256([]byte(secret))
+	t.Hash, t.CreatedAt = sum[:], time.Now().UTC()
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if _, exists := ts.tokens[t.Name]; exists {
+		return "", fmt.Errorf("token %q already exists", t.Name)
+	}
+	if ts.db != nil {
+		data, err := json.Marshal(t)
+		if err != nil {
+			return "", err
+		}
+		if err := ts.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(tokensBucket).Put([]byte(t.Name), data)
+		}); err != nil {
+			return "", err
+		}
+	}
+	ts.tokens[t.Name] = t
+	return secret, nil
+}
+
+// revoke deletes the named token and reports whether it existed.
+func (ts *tokenStore) revoke(name string) (bool, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if _, ok := ts.tokens[name]; !ok {
+		return false, nil
+	}
+	if ts.db != nil {
+		if err := ts.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(tokensBucket).Delete([]byte(name))
+		}); err != nil {
+			return false, err
+		}
+	}
+	delete(ts.tokens, name)
+	return true, nil
+}
+
+type tokenRequest struct {
+	Name   string   `json:"name"`
+	Roles  []string `json:"roles"`
+	Stacks []string `json:"stacks,omitempty"`
+	Vaults []string `json:"vaults,omitempty"`
+}
+
+type tokenView struct {
+	Name      string    `json:"name"`
+	Roles     []string  `json:"roles"`
+	Stacks    []string  `json:"stacks,omitempty"`
+	Vaults    []string  `json:"vaults,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Token     string    `json:"token,omitempty"` // only in the create response
+}
+
+func viewOf(t *apiToken) tokenView {
+	return tokenView{Name: t.Name, Roles: t.Roles, Stacks: t.Stacks, Vaults: t.Vaults, CreatedAt: t.CreatedAt}
+}
+
+// handleTokenList lists the named tokens. Secrets are never returned.
+func (s *Server) handleTokenList(w http.ResponseWriter, r *http.Request) {
+	tokens := []tokenView{}
+	for _, t := range s.tokens.list() {
+		tokens = append(tokens, viewOf(t))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"tokens": tokens, "count": len(tokens)})
+}
+
+// handleTokenCreate creates a named token. The secret is returned once and
+// only its hash is stored.
+func (s *Server) handleTokenCreate(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "invalid request body")
+		return
+	}
+	t := &apiToken{Name: req.Name, Roles: req.Roles, Stacks: req.Stacks, Vaults: req.Vaults}
+	secret, err := s.tokens.create(t)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	log.Info().Str("token", t.Name).Strs("roles", t.Roles).Str("by", tokenName(r.Context())).Msg("tokens: created")
+
+	view := viewOf(t)
+	view.Token = secret
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(view)
+}
+
+// handleTokenRevoke deletes a named token; it stops working immediately.
+func (s *Server) handleTokenRevoke(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	ok, err := s.tokens.revoke(name)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "revoke failed: "+err.Error())
+		return
+	}
+	if !ok {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "token not found")
+		return
+	}
+	log.Info().Str("token", name).Str("by", tokenName(r.Context())).Msg("tokens: revoked")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "name": name})
+}
@@ -0,0 +1,235 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/elabx-org/herald/internal/cache"
+	"github.com/elabx-org/herald/internal/materialize"
+	"github.com/elabx-org/herald/internal/resolver"
+	"github.com/rs/zerolog/log"
+)
+
+// Warm-up states reported by /v1/ready.
+const (
+	warmUpDisabled = "disabled"
+	warmUpPending  = "pending"
+	warmUpRunning  = "running"
+	warmUpDone     = "done"
+)
+
+// WarmUpStatus is the progress of the startup cache warm-up.
+type WarmUpStatus struct {
+	State      string     `json:"state"`
+	Total      int        `json:"total"`     // refs to warm
+	Processed  int        `json:"processed"` // refs handled so far (resolved, skipped or failed)
+	Resolved   int        `json:"resolved"`
+	Skipped    int        `json:"skipped"` // already fresh in the persistent cache, or policy none
+	Failed     int        `json:"failed"`
+	Error      string     `json:"error,omitempty"` // why the warm-up ended early
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type warmUpTracker struct {
+	mu     sync.Mutex
+	status WarmUpStatus
+}
+
+func (t *warmUpTracker) update(fn func(*WarmUpStatus)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fn(&t.status)
+}
+
+func (t *warmUpTracker) get() WarmUpStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
+
+// warmUpEnabled reports whether a warm-up will run, i.e. readiness waits for it.
+func (s *Server) warmUpEnabled() bool {
+	return s.cfg.Cache.WarmUp.Enabled && s.cache != nil && s.manager != nil
+}
+
+// warmUpStatus returns the warm-up progress; State is pending until WarmUp
+// starts.
+func (s *Server) warmUpStatus() WarmUpStatus {
+	if !s.warmUpEnabled() {
+		return WarmUpStatus{State: warmUpDisabled}
+	}
+	st := s.warmUp.get()
+	if st.State == "" {
+		st.State = warmUpPending
+	}
+	return st
+}
+
+// ready reports whether startup work is complete and Herald should take traffic.
+func (s *Server) ready() bool {
+	st := s.warmUpStatus().State
+	return st == warmUpDisabled || st == warmUpDone
+}
+
+// WarmUp pre-resolves the secrets referenced by every indexed stack, so the
+// first deploys after a restart hit the cache. Provider calls share the
+// background budget (see backgroundRate); a rate-limited provider ends the
+// warm-up early. Readiness (/v1/ready, /v1/health) waits for it to finish. No-op
+// unless cache.warm_up.enabled and a cache is wired.
+func (s *Server) WarmUp(ctx context.Context) {
+	if !s.warmUpEnabled() {
+		return
+	}
+	start := time.Now()
+	refs := s.index.RefsSyncedSince(time.Time{})
+	opts := mergeRefOptions(s.index.All())
+	s.warmUp.update(func(st *WarmUpStatus) {
+		*st = WarmUpStatus{State: warmUpRunning, Total: len(refs), StartedAt: &start}
+	})
+	log.Info().Int("refs", len(refs)).Msg("cache warm-up started")
+
+	var abort string
+	for _, rawURI := range refs {
+		outcome, err := s.warmRef(ctx, rawURI, opts[rawURI])
+		s.warmUp.update(func(st *WarmUpStatus) {
+			st.Processed++
+			switch {
+			case err != nil:
+				st.Failed++
+			case outcome == materialize.OutcomeResolved:
+				st.Resolved++
+			default:
+				st.Skipped++
+			}
+		})
+		if ctx.Err() != nil {
+			abort = "cancelled"
+			break
+		}
+		if err != nil {
+			if materialize.ClassifyError(err) == materialize.ErrClassRateLimited {
+				abort = "provider rate limited"
+				log.Warn().Err(err).Msg("warm-up: provider rate limited — finishing early")
+				break
+			}
+			log.Warn().Err(err).Str("ref", rawURI).Msg("warm-up: resolve failed")
+		}
+	}
+
+	end := time.Now()
+	s.warmUp.update(func(st *WarmUpStatus) {
+		st.State, st.Error, st.FinishedAt = warmUpDone, abort, &end
+	})
+	st := s.warmUp.get()
+	log.Info().Int("resolved", st.Resolved).Int("skipped", st.Skipped).Int("failed", st.Failed).Dur("took", end.Sub(start)).Msg("cache warm-up finished")
+}
+
+// warmRef resolves one ref into the cache under the policy and TTL it was last
+// materialized with (opt, falling back to the cache defaults). Refs already
+// fresh in the cache (persistent tiers survive restarts) and refs with policy
+// none are skipped.
+func (s *Server) warmRef(ctx context.Context, rawURI string, opt resolver.RefOption) (string, error) {
+	ref, err := resolver.ParseOpURI(rawURI)
+	if err != nil {
+		return "", err
+	}
+	policy, ttl := s.cfg.Cache.DefaultPolicy, s.cfg.Cache.DefaultTTL
+	if opt.Policy != "" {
+		policy = opt.Policy
+	}
+	if opt.TTL > 0 {
+		ttl = opt.TTL
+	}
+	cacheKey := fmt.Sprintf("%s/%s/%s", ref.Vault, ref.Item, ref.Field)
+	if policy == cache.PolicyNone {
+		return "", nil
+	}
+	if _, err := s.cache.Get(cacheKey); err == nil {
+		return materialize.OutcomeCached, nil
+	}
+
+	if !s.pace.wait(ctx) {
+		return "", ctx.Err()
+	}
+	val, providerName, err := s.resolveBackground(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	if err := s.cache.Set(cacheKey, &cache.Entry{
+		Value:     val,
+		Provider:  providerName,
+		Policy:    policy,
+		ExpiresAt: time.Now().Add(time.Duration(ttl) * time.Second),
+	}); err != nil {
+		return "", err
+	}
+	return materialize.OutcomeResolved, nil
+}
+
+// updatePolicyFloors pins every annotated secret to the least persistent
+// policy any indexed stack requested for it, so stacks that reference it
+// without an annotation cannot cache it more persistently. Call after the
+// index changes.
+func (s *Server) updatePolicyFloors() {
+	if s.cache == nil {
+		return
+	}
+	s.floorsMu.Lock()
+	defer s.floorsMu.Unlock()
+	floors := make(map[string]string)
+	for uri, opt := range mergeRefOptions(s.index.All()) {
+		ref, err := resolver.ParseOpURI(uri)
+		if err != nil || opt.Policy == "" {
+			continue
+		}
+		key := fmt.Sprintf("%s/%s/%s", ref.Vault, ref.Item, ref.Field)
+		if cur, ok := floors[key]; !ok || cache.PolicyRank(opt.Policy) < cache.PolicyRank(cur) {
+			floors[key] = opt.Policy
+		}
+	}
+	s.cache.SetPolicyFloors(floors)
+}
+
+// mergeRefOptions combines the ref annotations of all stacks. If stacks
+// disagree, the least persistent policy and the shortest TTL win.
+func mergeRefOptions(stacks map[string]StackInfo) map[string]resolver.RefOption {
+	merged := make(map[string]resolver.RefOption)
+	for _, info := range stacks {
+		for uri, opt := range info.RefOptions {
+			cur, seen := merged[uri]
+			if !seen {
+				merged[uri] = opt
+				continue
+			}
+			if opt.Policy != "" && (cur.Policy == "" || cache.PolicyRank(opt.Policy) < cache.PolicyRank(cur.Policy)) {
+				cur.Policy = opt.Policy
+			}
+			if opt.TTL > 0 && (cur.TTL == 0 || opt.TTL < cur.TTL) {
+				cur.TTL = opt.TTL
+			}
+			merged[uri] = cur
+		}
+	}
+	return merged
+}
+
+type readyResponse struct {
+	Ready  bool         `json:"ready"`
+	WarmUp WarmUpStatus `json:"warm_up"`
+}
+
+// handleReady reports whether Herald has finished startup work. 503 until
+// the cache warm-up completes.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	resp := readyResponse{Ready: s.ready(), WarmUp: s.warmUpStatus()}
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
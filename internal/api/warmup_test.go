@@ -0,0 +1,83 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/elabx-org/herald/internal/api"
+	"github.com/elabx-org/herald/internal/cache"
+	"github.com/elabx-org/herald/internal/config"
+	"github.com/elabx-org/herald/internal/provider"
+)
+
+func TestWarmUp(t *testing.T) {
+	path := t.TempDir() + "/cache.db"
+	cfg := &config.Config{}
+	cfg.Cache.DefaultPolicy = cache.PolicyMemory
+	cfg.Cache.DefaultTTL = 3600
+	cfg.Cache.WarmUp.Enabled = true
+	p := &countingProvider{value: "v1"}
+	mgr := provider.NewManager([]provider.Provider{p})
+
+	// First run: materialize a stack so it lands in the persistent index.
+	store, err := cache.New(path, "test-passphrase")
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	srv := api.NewServer(cfg, mgr)
+	srv.SetCache(store)
+	body := `{"stack":"myapp","env_content":"A=op://vault/item/a\nB=op://vault/item/b\n"}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/materialize/env", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	srv.Router().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("materialize status = %d: %s", w.Code, w.Body.String())
+	}
+	store.Close()
+
+	// Restart: the index survives, the memory tier does not.
+	store, err = cache.New(path, "test-passphrase")
+	if err != nil {
+		t.Fatalf("cache.New() reopen error = %v", err)
+	}
+	defer store.Close()
+	srv = api.NewServer(cfg, mgr)
+	srv.SetCache(store)
+
+	ready := func() (int, api.WarmUpStatus) {
+		req := httptest.NewRequest(http.MethodGet, "/v1/ready", nil)
+		w := httptest.NewRecorder()
+		srv.Router().ServeHTTP(w, req)
+		var resp struct {
+			WarmUp api.WarmUpStatus `json:"warm_up"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp.WarmUp
+	}
+	if code, st := ready(); code != http.StatusServiceUnavailable || st.State != "pending" {
+		t.Errorf("ready before warm-up = %d %q, want 503 pending", code, st.State)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/v1/health", nil)
+	w = httptest.NewRecorder()
+	srv.Router().ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"warming_up"`) {
+		t.Errorf("health before warm-up = %d %s, want 503 warming_up", w.Code, w.Body.String())
+	}
+
+	calls := p.calls.Load()
+	srv.WarmUp(context.Background())
+	if got := p.calls.Load() - calls; got != 2 {
+		t.Errorf("provider calls during warm-up = %d, want 2", got)
+	}
+	code, st := ready()
+	if code != http.StatusOK || st.State != "done" || st.Total != 2 || st.Resolved != 2 {
+		t.Errorf("ready after warm-up = %d %+v, want 200 done with 2 resolved", code, st)
+	}
+	if entry, err := store.Get("vault/item/a"); err != nil || entry.Value != "v1" {
+		t.Errorf("warmed entry = %+v, %v; want v1", entry, err)
+	}
+}
@@ -24,14 +24,20 @@ const (
 	tokenStateExpired
 )
 
-// StartHealthWatcher monitors provider health every 5 minutes and fires Komodo
-// alerts on state transitions (ok→degraded / degraded→ok, token expiry).
-// No-op if Komodo is not wired.
-func (s *Server) StartHealthWatcher(ctx context.Context) {
-	if s.komodo == nil {
-		return
+func (st tokenAlertState) String() string {
+	switch st {
+	case tokenStateWarning:
+		return "warning"
+	case tokenStateExpired:
+		return "expired"
 	}
+	return "ok"
+}
 
+// StartHealthWatcher monitors provider health every 5 minutes and, on state
+// transitions (ok→degraded / degraded→ok, token expiry), publishes an event
+// and fires a Komodo alert if Komodo is wired.
+func (s *Server) StartHealthWatcher(ctx context.Context) {
 	log.Info().Dur("interval", healthWatchInterval).Msg("health watcher started")
 
 	var lastDegraded bool
@@ -66,6 +72,7 @@ func (s *Server) checkProviderHealth(ctx context.Context, lastDegraded *bool) {
 	switch {
 	case degraded && !*lastDegraded:
 		var issues []string
+		var failing []map[string]interface{}
 		for _, p := range resp.Providers {
 			if p.Status != "ok" {
 				detail := p.Name
@@ -73,8 +80,13 @@ func (s *Server) checkProviderHealth(ctx context.Context, lastDegraded *bool) {
 					detail += ": " + p.Error
 				}
 				issues = append(issues, detail)
+				failing = append(failing, map[string]interface{}{"name": p.Name, "status": p.Status, "error": p.Error})
 			}
 		}
+		s.publish(EventProviderHealth, nil, map[string]interface{}{"status": "degraded", "providers": failing})
+		if s.komodo == nil {
+			break
+		}
 		msg := "Herald: provider degraded"
 		if len(issues) > 0 {
 			msg += " — " + strings.Join(issues, "; ")
@@ -86,6 +98,10 @@ func (s *Server) checkProviderHealth(ctx context.Context, lastDegraded *bool) {
 		}
 
 	case !degraded && *lastDegraded:
+		s.publish(EventProviderHealth, nil, map[string]interface{}{"status": "ok"})
+		if s.komodo == nil {
+			break
+		}
 		if err := s.komodo.SendAlert(ctx, "ok", "Herald: all providers healthy"); err != nil {
 			log.Error().Err(err).Msg("health watcher: failed to send recovery alert")
 		} else {
@@ -129,6 +145,15 @@ func (s *Server) checkTokenExpiry(ctx context.Context, lastState map[string]toke
 		if state == prev {
 			continue // no transition, no alert
 		}
+		s.publish(EventTokenExpiry, nil, map[string]interface{}{
+			"provider": p.Name, "type": p.Type, "state": state.String(), "expires_at": expiry.UTC(),
+		})
+		if s.komodo == nil {
+			if state != tokenStateOK {
+				log.Warn().Str("provider", p.Name).Str("state", state.String()).Time("expires_at", expiry).Msg("health watcher: provider token expiring")
+			}
+			continue
+		}
 
 		switch state {
 		case tokenStateExpired:
@@ -10,17 +10,31 @@ import (
 )
 
 type Entry struct {
-	Timestamp   time.Time `json:"ts"`
-	Action      string    `json:"action"`
-	Stack       string    `json:"stack"`
-	Secret      string    `json:"secret"`
-	Provider    string    `json:"provider"`
-	Delivery    []string  `json:"delivery,omitempty"`
-	Policy      string    `json:"policy"`
-	CacheHit    bool      `json:"cache_hit"`
-	DurationMs  int64     `json:"duration_ms"`
-	TriggeredBy string    `json:"triggered_by,omitempty"`
-	Error       string    `json:"error,omitempty"`
+	Timestamp   time.Time    `json:"ts"`
+	Action      string       `json:"action"`
+	Stack       string       `json:"stack"`
+	Secret      string       `json:"secret"`
+	Provider    string       `json:"provider"`
+	Delivery    []string     `json:"delivery,omitempty"`
+	Policy      string       `json:"policy"`
+	CacheHit    bool         `json:"cache_hit"`
+	Stale       bool         `json:"stale,omitempty"` // at least one value was served from an expired cache entry
+	DurationMs  int64        `json:"duration_ms"`
+	TriggeredBy string       `json:"triggered_by,omitempty"`
+	Token       string       `json:"token,omitempty"` // name of the API token that made the request
+	Error       string       `json:"error,omitempty"`
+	Provenance  []Provenance `json:"provenance,omitempty"`
+}
+
+// Provenance records which ref and provider served a single env key during a
+// materialization. Values are never logged.
+type Provenance struct {
+	Key             string `json:"key"`
+	Ref             string `json:"ref"`
+	Outcome         string `json:"outcome"` // resolved, cached, stale, failed, skipped
+	Provider        string `json:"provider,omitempty"`
+	CacheAgeSeconds int64  `json:"cache_age_seconds,omitempty"`
+	StaleReason     string `json:"stale_reason,omitempty"` // why a stale value was served (e.g. provider_unavailable)
 }
 
 type QueryOptions struct {
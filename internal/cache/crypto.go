@@ -11,8 +11,13 @@ import (
 	"golang.org/x/crypto/pbkdf2"
 )
 
+// deriveKey derives the legacy key, used before per-install salts, so
+// entries written by older versions can still be read and migrated.
 func deriveKey(passphrase string) []byte {
-	salt := []byte("herald-cache-salt-v1")
+	return deriveKeyWithSalt(passphrase, []byte("herald-cache-salt-v1"))
+}
+
+func deriveKeyWithSalt(passphrase string, salt []byte) []byte {
 	return pbkdf2.Key([]byte(passphrase), salt, 100_000, 32, sha256.New)
 }
 
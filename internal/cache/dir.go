@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/elabx-org/herald/internal/fsutil"
+)
+
+// dirTier stores encrypted entries as one file per cache key in a directory.
+// It backs PolicyTmpfs (a RAM-backed mount, lost on reboot) and PolicyFile
+// (a persistent directory). File names are the base64url-encoded cache key so
+// entries can be enumerated for invalidation.
+type dirTier struct {
+	dir string
+}
+
+func newDirTier(dir string) (*dirTier, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &dirTier{dir: dir}, nil
+}
+
+func (d *dirTier) path(cacheKey string) string {
+	return filepath.Join(d.dir, base64.RawURLEncoding.EncodeToString([]byte(cacheKey)))
+}
+
+func (d *dirTier) put(cacheKey string, data []byte) error {
+	return fsutil.WriteFileAtomic(d.path(cacheKey), data, 0600, -1, -1)
+}
+
+func (d *dirTier) get(cacheKey string) ([]byte, error) {
+	data, err := os.ReadFile(d.path(cacheKey))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// deleteMatching removes every entry whose key satisfies match and returns
+// how many were removed.
+func (d *dirTier) deleteMatching(match func(cacheKey string) bool) int {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return 0
+	}
+	count := 0
+	for _, e := range entries {
+		key, err := base64.RawURLEncoding.DecodeString(e.Name())
+		if err != nil || !match(string(key)) {
+			continue // temp files and foreign files are left alone
+		}
+		if os.Remove(filepath.Join(d.dir, e.Name())) == nil {
+			count++
+		}
+	}
+	return count
+}
+
+// each calls fn with the key and raw (encrypted) content of every entry.
+func (d *dirTier) each(fn func(cacheKey string, raw []byte)) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		key, err := base64.RawURLEncoding.DecodeString(e.Name())
+		if err != nil {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(d.dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		fn(string(key), raw)
+	}
+}
+
+// deleteIf removes every entry whose raw (encrypted) content satisfies
+// remove and returns how many were removed.
+func (d *dirTier) deleteIf(remove func(raw []byte) bool) int {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return 0
+	}
+	count := 0
+	for _, e := range entries {
+		if _, err := base64.RawURLEncoding.DecodeString(e.Name()); err != nil {
+			continue
+		}
+		path := filepath.Join(d.dir, e.Name())
+		raw, err := os.ReadFile(path)
+		if err != nil || !remove(raw) {
+			continue
+		}
+		if os.Remove(path) == nil {
+			count++
+		}
+	}
+	return count
+}
+
+// rewrite replaces each entry's raw content with the result of fn, removing
+// entries for which fn reports false.
+func (d *dirTier) rewrite(fn func(raw []byte) ([]byte, bool)) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if _, err := base64.RawURLEncoding.DecodeString(e.Name()); err != nil {
+			continue
+		}
+		path := filepath.Join(d.dir, e.Name())
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if data, ok := fn(raw); ok {
+			fsutil.WriteFileAtomic(path, data, 0600, -1, -1)
+		} else {
+			os.Remove(path)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrMemoryOnly is returned by operations that need the persistent store
+// when the cache runs in degraded memory-only mode.
+var ErrMemoryOnly = errors.New("cache: running in memory-only mode")
+
+// KEK is a key-encryption key. Data keys are generated at random and stored
+// in the meta bucket wrapped by the KEK, so the cache file is useless without
+// access to it.
+type KEK interface {
+	// ID identifies the KEK; it is recorded with each wrapped data key so a
+	// changed KEK is detected instead of failing to decrypt.
+	ID() string
+	Wrap(dataKey []byte) ([]byte, error)
+	Unwrap(wrapped []byte) ([]byte, error)
+}
+
+// aesKEK wraps data keys with AES-256-GCM under a key held in process memory.
+type aesKEK struct {
+	id  string
+	key []byte
+}
+
+// NewAESKEK returns a KEK that wraps with AES-256-GCM under key, which must
+// be 32 bytes.
+func NewAESKEK(id string, key []byte) (KEK, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("cache: KEK must be 32 bytes, got %d", len(key))
+	}
+	return &aesKEK{id: id, key: key}, nil
+}
+
+func (k *aesKEK) ID() string                          { return k.id }
+func (k *aesKEK) Wrap(dataKey []byte) ([]byte, error) { return encrypt(k.key, dataKey) }
+func (k *aesKEK) Unwrap(wrapped []byte) ([]byte, error) {
+	return decrypt(k.key, wrapped)
+}
+
+// NewWithKEK opens the cache at path with envelope encryption under kek.
+// previous passphrases (HERALD_CACHE_KEY and older) keep entries from
+// passphrase mode readable until the cache is rekeyed.
+func NewWithKEK(path string, kek KEK, previous ...string) (*Store, error) {
+	srcs := make([]keySource, len(previous))
+	for i, p := range previous {
+		srcs[i] = passphraseSource(p)
+	}
+	var legacy []byte
+	if len(previous) > 0 {
+		legacy = deriveKey(previous[0])
+	}
+	return open(path, kekSource{kek}, srcs, legacy)
+}
+
+// NewMemoryOnly returns a cache that never touches disk: every policy except
+// none is held in the memory tier. It is the degraded mode used when the KEK
+// is unavailable and the operator has opted in.
+func NewMemoryOnly() *Store {
+	return &Store{
+		mem:  newLRU(DefaultMaxEntries, DefaultMaxBytes),
+		dirs: make(map[string]*dirTier),
+	}
+}
+
+// MemoryOnly reports whether the cache runs without persistent storage.
+func (s *Store) MemoryOnly() bool { return s.db == nil }
@@ -0,0 +1,379 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrKeyUnavailable is returned when an entry was encrypted under a key
+// version none of the configured passphrases unlocks.
+var ErrKeyUnavailable = errors.New("cache: entry encrypted under an unavailable key version")
+
+var (
+	metaBucket = []byte("meta")
+	metaKeys   = []byte("keys")
+
+	// encryptedBuckets lists every bbolt bucket whose values are sealed under
+	// the cache key. Rekey re-encrypts all of them.
+	encryptedBuckets = [][]byte{bucketName}
+)
+
+// Sealed values are prefixed with headerMagic and the big-endian key version
+// that encrypted them. Values without the header predate key versions and
+// were encrypted under the legacy fixed-salt key.
+var headerMagic = []byte("HK")
+
+const (
+	headerSize = 6
+	saltSize   = 16
+)
+
+// keyCheck is encrypted under each key version so a passphrase can be matched
+// to its version without trial-decrypting entries.
+var keyCheck = []byte("herald-cache-key-check")
+
+// keyVersion records how to recover one data key: from a passphrase and
+// Salt, or by unwrapping Wrapped with the KEK identified by KEK.
+type keyVersion struct {
+	Salt    []byte    `json:"salt,omitempty"`
+	KEK     string    `json:"kek,omitempty"`
+	Wrapped []byte    `json:"wrapped,omitempty"`
+	Check   []byte    `json:"check"`
+	Created time.Time `json:"created"`
+}
+
+type keyMeta struct {
+	Current  uint32                 `json:"current"`
+	Versions map[uint32]*keyVersion `json:"versions"`
+}
+
+// keyring holds the unlocked key versions. It is immutable; Rekey swaps in a
+// new one.
+type keyring struct {
+	current uint32
+	keys    map[uint32][]byte
+	legacy  []byte // fixed-salt key, for headerless values
+}
+
+// keySource creates and unlocks key versions.
+type keySource interface {
+	newVersion() (*keyVersion, []byte, error)
+	unlock(v *keyVersion) ([]byte, bool)
+}
+
+// passphraseSource derives each version's data key from the passphrase and a
+// random per-version salt.
+type passphraseSource string
+
+func (p passphraseSource) newVersion() (*keyVersion, []byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, err
+	}
+	key := deriveKeyWithSalt(string(p), salt)
+	v, err := newCheckedVersion(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	v.Salt = salt
+	return v, key, nil
+}
+
+func (p passphraseSource) unlock(v *keyVersion) ([]byte, bool) {
+	if v.Salt == nil {
+		return nil, false
+	}
+	key := deriveKeyWithSalt(string(p), v.Salt)
+	return key, v.unlocks(key)
+}
+
+// kekSource generates a random data key per version and stores it wrapped by
+// the KEK (envelope encryption).
+type kekSource struct{ kek KEK }
+
+func (k kekSource) newVersion() (*keyVersion, []byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := k.kek.Wrap(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cache: wrap data key: %w", err)
+	}
+	v, err := newCheckedVersion(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	v.KEK, v.Wrapped = k.kek.ID(), wrapped
+	return v, key, nil
+}
+
+func (k kekSource) unlock(v *keyVersion) ([]byte, bool) {
+	if v.Wrapped == nil || v.KEK != k.kek.ID() {
+		return nil, false
+	}
+	key, err := k.kek.Unwrap(v.Wrapped)
+	if err != nil {
+		return nil, false
+	}
+	return key, v.unlocks(key)
+}
+
+func newCheckedVersion(key []byte) (*keyVersion, error) {
+	check, err := encrypt(key, keyCheck)
+	if err != nil {
+		return nil, err
+	}
+	return &keyVersion{Check: check, Created: time.Now().UTC()}, nil
+}
+
+// unlocks reports whether key decrypts this version's check value.
+func (v *keyVersion) unlocks(key []byte) bool {
+	pt, err := decrypt(key, v.Check)
+	return err == nil && bytes.Equal(pt, keyCheck)
+}
+
+func (k *keyring) seal(plaintext []byte) ([]byte, error) {
+	ct, err := encrypt(k.keys[k.current], plaintext)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, headerSize, headerSize+len(ct))
+	copy(out, headerMagic)
+	binary.BigEndian.PutUint32(out[2:], k.current)
+	return append(out, ct...), nil
+}
+
+// open decrypts a sealed value and returns the key version that encrypted it
+// (0 for legacy values).
+func (k *keyring) open(raw []byte) ([]byte, uint32, error) {
+	if len(raw) > headerSize && bytes.Equal(raw[:2], headerMagic) {
+		version := binary.BigEndian.Uint32(raw[2:headerSize])
+		if key, ok := k.keys[version]; ok {
+			if pt, err := decrypt(key, raw[headerSize:]); err == nil {
+				return pt, version, nil
+			}
+		} else if k.legacy == nil {
+			return nil, version, ErrKeyUnavailable
+		}
+	}
+	if k.legacy == nil {
+		return nil, 0, ErrKeyUnavailable
+	}
+	pt, err := decrypt(k.legacy, raw)
+	return pt, 0, err
+}
+
+// loadKeys unlocks the key versions recorded in the meta bucket with current
+// and previous. On first use it creates version 1 and migrates legacy entries
+// (readable with the legacy key, if any) to it. If current unlocks no recorded
+// version (HERALD_CACHE_KEY or the KEK was changed), a new version is added
+// and becomes current; entries under older versions stay readable only
+// through previous.
+func loadKeys(tx *bolt.Tx, current keySource, previous []keySource, legacy []byte) (*keyring, error) {
+	mb, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return nil, err
+	}
+	kr := &keyring{keys: make(map[uint32][]byte), legacy: legacy}
+
+	var meta keyMeta
+	if raw := mb.Get(metaKeys); raw != nil {
+		if err := json.Unmarshal(raw, &meta); err != nil {
+			return nil, fmt.Errorf("cache: corrupt key metadata: %w", err)
+		}
+	}
+
+	if len(meta.Versions) == 0 {
+		v, key, err := current.newVersion()
+		if err != nil {
+			return nil, err
+		}
+		meta = keyMeta{Current: 1, Versions: map[uint32]*keyVersion{1: v}}
+		kr.current, kr.keys[1] = 1, key
+		if _, _, err := rekeyBuckets(tx, kr, kr); err != nil {
+			return nil, err
+		}
+		return kr, putMeta(mb, &meta)
+	}
+
+	kr.current = 0
+	for version, v := range meta.Versions {
+		for i, src := range append([]keySource{current}, previous...) {
+			key, ok := src.unlock(v)
+			if !ok {
+				continue
+			}
+			kr.keys[version] = key
+			if i == 0 && version > kr.current {
+				kr.current = version
+			}
+			break
+		}
+	}
+	if kr.current == 0 {
+		v, key, err := current.newVersion()
+		if err != nil {
+			return nil, err
+		}
+		for version := range meta.Versions {
+			kr.current = max(kr.current, version)
+		}
+		kr.current++
+		kr.keys[kr.current] = key
+		meta.Versions[kr.current] = v
+	}
+	meta.Current = kr.current
+	return kr, putMeta(mb, &meta)
+}
+
+func putMeta(mb *bolt.Bucket, meta *keyMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return mb.Put(metaKeys, data)
+}
+
+// rekeyBuckets re-seals every value in the encrypted buckets: values opens
+// can decrypt are re-sealed under seals' current version, the rest are
+// deleted.
+func rekeyBuckets(tx *bolt.Tx, opens, seals *keyring) (reencrypted, dropped int, err error) {
+	for _, name := range encryptedBuckets {
+		b := tx.Bucket(name)
+		if b == nil {
+			continue
+		}
+		updates := make(map[string][]byte)
+		var stale [][]byte
+		if err := b.ForEach(func(k, v []byte) error {
+			pt, _, err := opens.open(v)
+			if err != nil {
+				stale = append(stale, append([]byte{}, k...))
+				return nil
+			}
+			sealed, err := seals.seal(pt)
+			if err != nil {
+				return err
+			}
+			updates[string(k)] = sealed
+			return nil
+		}); err != nil {
+			return 0, 0, err
+		}
+		for k, v := range updates {
+			if err := b.Put([]byte(k), v); err != nil {
+				return 0, 0, err
+			}
+		}
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return 0, 0, err
+			}
+		}
+		reencrypted += len(updates)
+		dropped += len(stale)
+	}
+	return reencrypted, dropped, nil
+}
+
+// RekeyResult reports the outcome of Rekey.
+type RekeyResult struct {
+	Version     uint32 `json:"version"`     // new current key version
+	Reencrypted int    `json:"reencrypted"` // entries now under Version
+	Dropped     int    `json:"dropped"`     // entries no unlocked key could decrypt
+}
+
+// Rekey re-encrypts every bbolt entry under a new key version derived from
+// newPassphrase with a fresh random salt, in a single transaction, and
+// retires all older versions. Directory-tier entries are re-encrypted after
+// the transaction commits. Herald must be restarted with HERALD_CACHE_KEY set
+// to newPassphrase afterwards.
+func (s *Store) Rekey(newPassphrase string) (RekeyResult, error) {
+	if newPassphrase == "" {
+		return RekeyResult{}, errors.New("cache: new passphrase is empty")
+	}
+	return s.rekey(passphraseSource(newPassphrase))
+}
+
+// RekeyKEK is Rekey with a data key wrapped by kek, moving the cache to
+// envelope encryption (or to a new KEK).
+func (s *Store) RekeyKEK(kek KEK) (RekeyResult, error) {
+	return s.rekey(kekSource{kek})
+}
+
+// RotateDataKey is Rekey under the current passphrase or KEK: a fresh data
+// key (and salt) replaces every older version.
+func (s *Store) RotateDataKey() (RekeyResult, error) {
+	return s.rekey(nil)
+}
+
+func (s *Store) rekey(src keySource) (RekeyResult, error) {
+	if s.db == nil {
+		return RekeyResult{}, ErrMemoryOnly
+	}
+	if src == nil {
+		s.keyMu.RLock()
+		src = s.source
+		s.keyMu.RUnlock()
+	}
+	// Derive the new key (pbkdf2 or a KEK call) before blocking readers.
+	v, key, err := src.newVersion()
+	if err != nil {
+		return RekeyResult{}, err
+	}
+
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+	var res RekeyResult
+	for version := range s.keys.keys {
+		res.Version = max(res.Version, version)
+	}
+	res.Version++
+	next := &keyring{current: res.Version, keys: map[uint32][]byte{res.Version: key}}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		res.Reencrypted, res.Dropped, err = rekeyBuckets(tx, s.keys, next)
+		if err != nil {
+			return err
+		}
+		return putMeta(tx.Bucket(metaBucket), &keyMeta{
+			Current:  res.Version,
+			Versions: map[uint32]*keyVersion{res.Version: v},
+		})
+	})
+	if err != nil {
+		return RekeyResult{}, err
+	}
+
+	for _, tier := range s.dirs {
+		tier.rewrite(func(raw []byte) ([]byte, bool) {
+			pt, _, err := s.keys.open(raw)
+			if err != nil {
+				return nil, false
+			}
+			sealed, err := next.seal(pt)
+			return sealed, err == nil
+		})
+	}
+	s.keys, s.source = next, src
+	return res, nil
+}
+
+// KeyVersion returns the key version new entries are encrypted under.
+func (s *Store) KeyVersion() uint32 {
+	s.keyMu.RLock()
+	defer s.keyMu.RUnlock()
+	if s.keys == nil {
+		return 0
+	}
+	return s.keys.current
+}
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// fingerprintLen is the number of hex digits of the value's SHA-256 kept in
+// EntryInfo.Fingerprint: enough to tell values apart, too short to be useful
+// as a lookup key.
+const fingerprintLen = 12
+
+// EntryInfo describes a cached entry without its value.
+type EntryInfo struct {
+	Key         string    `json:"key"`
+	Tier        string    `json:"tier"` // policy of the tier holding the entry
+	Provider    string    `json:"provider,omitempty"`
+	Policy      string    `json:"policy,omitempty"`
+	CreatedAt   time.Time `json:"created_at,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at,omitempty"`
+	Expired     bool      `json:"expired"`
+	Size        int       `json:"size"`                  // value length in bytes
+	Fingerprint string    `json:"fingerprint,omitempty"` // SHA-256 prefix of the value
+	KeyVersion  uint32    `json:"key_version,omitempty"`
+	Error       string    `json:"error,omitempty"` // set when the entry cannot be decrypted
+}
+
+// Fingerprint returns the SHA-256 prefix of value used by EntryInfo.
+func Fingerprint(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return "sha256:" + hex.EncodeToString(sum[:])[:fingerprintLen]
+}
+
+func infoFor(key, tier string, e *Entry) EntryInfo {
+	return EntryInfo{
+		Key:         key,
+		Tier:        tier,
+		Provider:    e.Provider,
+		Policy:      e.Policy,
+		CreatedAt:   e.CreatedAt,
+		ExpiresAt:   e.ExpiresAt,
+		Expired:     time.Now().After(e.ExpiresAt),
+		Size:        len(e.Value),
+		Fingerprint: Fingerprint(e.Value),
+		KeyVersion:  e.KeyVersion,
+	}
+}
+
+// List returns metadata for every entry whose key satisfies match (all
+// entries when match is nil), across all tiers and including expired entries
+// still held for stale serving, sorted by key. Values are never returned.
+func (s *Store) List(match func(cacheKey string) bool) []EntryInfo {
+	if match == nil {
+		match = func(string) bool { return true }
+	}
+	var infos []EntryInfo
+	s.mem.each(func(key string, e *Entry) {
+		if match(key) {
+			infos = append(infos, infoFor(key, PolicyMemory, e))
+		}
+	})
+
+	decoded := func(key, tier string, raw []byte) EntryInfo {
+		e, err := s.decode(raw)
+		if err != nil {
+			return EntryInfo{Key: key, Tier: tier, Error: err.Error()}
+		}
+		return infoFor(key, tier, e)
+	}
+	for policy, tier := range s.dirs {
+		tier.each(func(key string, raw []byte) {
+			if match(key) {
+				infos = append(infos, decoded(key, policy, raw))
+			}
+		})
+	}
+	if s.db != nil {
+		// Copy inside the transaction and decode after it: decode takes
+		// keyMu, which Rekey holds while it waits for the write transaction.
+		raws := make(map[string][]byte)
+		s.db.View(func(tx *bolt.Tx) error {
+			return tx.Bucket(bucketName).ForEach(func(k, v []byte) error {
+				if match(string(k)) {
+					raws[string(k)] = bytes.Clone(v)
+				}
+				return nil
+			})
+		})
+		for key, raw := range raws {
+			infos = append(infos, decoded(key, PolicyEncrypted, raw))
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos
+}
@@ -0,0 +1,162 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Default bounds for the memory tier.
+const (
+	DefaultMaxEntries = 10_000
+	DefaultMaxBytes   = 64 << 20 // 64 MiB
+)
+
+// entryOverhead approximates the per-entry bookkeeping cost (list element,
+// map slot, Entry struct) so the byte bound reflects real memory use.
+const entryOverhead = 128
+
+// lru is the memory tier: a mutex-guarded LRU bounded by entry count and
+// approximate byte size. The least recently used entries are evicted first.
+type lru struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	ll         *list.List // front = most recently used
+	items      map[string]*list.Element
+	bytes      int64
+	evictions  int64 // entries dropped to stay within bounds
+}
+
+type lruItem struct {
+	key   string
+	entry *Entry
+	size  int64
+}
+
+func newLRU(maxEntries int, maxBytes int64) *lru {
+	return &lru{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+func entrySize(key string, e *Entry) int64 {
+	return int64(len(key)+len(e.Value)+len(e.Provider)+len(e.Policy)) + entryOverhead
+}
+
+// setLimits changes the bounds, evicting immediately if the tier is over them.
+// Non-positive values mean unbounded in that dimension.
+func (l *lru) setLimits(maxEntries int, maxBytes int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.maxEntries, l.maxBytes = maxEntries, maxBytes
+	l.evict()
+}
+
+func (l *lru) get(key string) (*Entry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	el, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	l.ll.MoveToFront(el)
+	return el.Value.(*lruItem).entry, true
+}
+
+func (l *lru) set(key string, e *Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	size := entrySize(key, e)
+	if el, ok := l.items[key]; ok {
+		it := el.Value.(*lruItem)
+		l.bytes += size - it.size
+		it.entry, it.size = e, size
+		l.ll.MoveToFront(el)
+	} else {
+		l.items[key] = l.ll.PushFront(&lruItem{key: key, entry: e, size: size})
+		l.bytes += size
+	}
+	l.evict()
+}
+
+// evict drops least recently used entries until within bounds. It never
+// evicts the most recent entry, so a single oversized value is still cached.
+func (l *lru) evict() {
+	for l.ll.Len() > 1 &&
+		((l.maxEntries > 0 && l.ll.Len() > l.maxEntries) || (l.maxBytes > 0 && l.bytes > l.maxBytes)) {
+		l.removeElement(l.ll.Back())
+		l.evictions++
+	}
+}
+
+func (l *lru) removeElement(el *list.Element) {
+	it := l.ll.Remove(el).(*lruItem)
+	delete(l.items, it.key)
+	l.bytes -= it.size
+}
+
+func (l *lru) delete(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	el, ok := l.items[key]
+	if ok {
+		l.removeElement(el)
+	}
+	return ok
+}
+
+// deleteMatching removes every entry whose key satisfies match and returns
+// how many were removed.
+func (l *lru) deleteMatching(match func(cacheKey string) bool) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	count := 0
+	for key, el := range l.items {
+		if match(key) {
+			l.removeElement(el)
+			count++
+		}
+	}
+	return count
+}
+
+// removeExpiredBefore removes entries whose ExpiresAt is before cutoff.
+func (l *lru) removeExpiredBefore(cutoff time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	count := 0
+	for _, el := range l.items {
+		if el.Value.(*lruItem).entry.ExpiresAt.Before(cutoff) {
+			l.removeElement(el)
+			count++
+		}
+	}
+	return count
+}
+
+// each calls fn for every entry. fn must not call back into the tier.
+func (l *lru) each(fn func(key string, e *Entry)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, el := range l.items {
+		fn(key, el.Value.(*lruItem).entry)
+	}
+}
+
+func (l *lru) clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ll.Init()
+	l.items = make(map[string]*list.Element)
+	l.bytes = 0
+}
+
+func (l *lru) stats() (entries int, bytes, evictions int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ll.Len(), l.bytes, l.evictions
+}
@@ -0,0 +1,260 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Snapshots are snapshotMagic, the big-endian format version, the length of
+// the JSON header, the header, and the bbolt file encrypted under a fresh
+// snapshot key. The header records how to recover that key from the
+// configured passphrase or KEK, exactly like a cache key version.
+var snapshotMagic = []byte("HERALDSNAP")
+
+const snapshotFormat = 1
+
+// maxSnapshotHeader bounds the header read from untrusted input.
+const maxSnapshotHeader = 64 << 10
+
+// ErrSnapshotKey is returned by Restore when neither the configured
+// passphrase nor the KEK unlocks the snapshot.
+var ErrSnapshotKey = errors.New("cache: snapshot was encrypted under a key that is not configured")
+
+// SnapshotInfo describes a snapshot.
+type SnapshotInfo struct {
+	Format     int       `json:"format"`
+	CreatedAt  time.Time `json:"created_at"`
+	KeyVersion uint32    `json:"key_version"` // cache key version current when the snapshot was taken
+	Size       int64     `json:"size"`        // bytes of the bbolt file
+	SHA256     []byte    `json:"sha256"`      // of the bbolt file
+}
+
+type snapshotHeader struct {
+	SnapshotInfo
+	Key *keyVersion `json:"key"`
+}
+
+// Snapshot writes an encrypted copy of the whole bbolt file (entries, key
+// metadata, the stack index and any other bucket) to w. The copy is taken in
+// a single read transaction, so it is consistent while the server keeps
+// serving. Directory-tier entries are not included.
+func (s *Store) Snapshot(w io.Writer) (SnapshotInfo, error) {
+	if s.db == nil {
+		return SnapshotInfo{}, ErrMemoryOnly
+	}
+	var db bytes.Buffer
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(&db)
+		return err
+	}); err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	s.keyMu.RLock()
+	src, version := s.source, s.keys.current
+	s.keyMu.RUnlock()
+	kv, key, err := src.newVersion()
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	sum := sha256.Sum256(db.Bytes())
+	hdr := snapshotHeader{
+		SnapshotInfo: SnapshotInfo{
+			Format:     snapshotFormat,
+			CreatedAt:  time.Now().UTC(),
+			KeyVersion: version,
+			Size:       int64(db.Len()),
+			SHA256:     sum[:],
+		},
+		Key: kv,
+	}
+	payload, err := encrypt(key, db.Bytes())
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	hdrJSON, err := json.Marshal(hdr)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	var prefix [8]byte
+	binary.BigEndian.PutUint32(prefix[:4], snapshotFormat)
+	binary.BigEndian.PutUint32(prefix[4:], uint32(len(hdrJSON)))
+	bw := bufio.NewWriter(w)
+	for _, part := range [][]byte{snapshotMagic, prefix[:], hdrJSON, payload} {
+		if _, err := bw.Write(part); err != nil {
+			return SnapshotInfo{}, err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return SnapshotInfo{}, err
+	}
+	return hdr.SnapshotInfo, nil
+}
+
+// Restore replaces the cache file at path with the snapshot read from r. The
+// snapshot must unlock with passphrase or one of previous, its checksum must
+// match, and the restored file must pass a bbolt consistency check with its
+// current key version unlockable by the same keys; otherwise path is left
+// untouched. An existing file is kept as path.pre-restore. Herald must not be
+// running.
+func Restore(path string, r io.Reader, passphrase string, previous ...string) (SnapshotInfo, error) {
+	srcs := make([]keySource, 0, len(previous)+1)
+	srcs = append(srcs, passphraseSource(passphrase))
+	for _, p := range previous {
+		srcs = append(srcs, passphraseSource(p))
+	}
+	return restore(path, r, srcs)
+}
+
+// RestoreWithKEK is Restore for a cache under envelope encryption. previous
+// passphrases unlock snapshots taken in passphrase mode.
+func RestoreWithKEK(path string, r io.Reader, kek KEK, previous ...string) (SnapshotInfo, error) {
+	srcs := []keySource{kekSource{kek}}
+	for _, p := range previous {
+		srcs = append(srcs, passphraseSource(p))
+	}
+	return restore(path, r, srcs)
+}
+
+func restore(path string, r io.Reader, srcs []keySource) (SnapshotInfo, error) {
+	data, hdr, err := readSnapshot(r, srcs)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".restore-*")
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	if err := verifyRestored(tmp.Name(), srcs); err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		// Taking the bbolt lock fails fast if Herald still has the file open.
+		live, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+		if err != nil {
+			return SnapshotInfo{}, fmt.Errorf("cache: %s is in use (stop herald before restoring): %w", path, err)
+		}
+		live.Close()
+		if err := os.Rename(path, path+".pre-restore"); err != nil {
+			return SnapshotInfo{}, err
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return SnapshotInfo{}, err
+	}
+	return hdr.SnapshotInfo, nil
+}
+
+// readSnapshot parses and decrypts a snapshot and verifies its checksum.
+func readSnapshot(r io.Reader, srcs []keySource) ([]byte, *snapshotHeader, error) {
+	prefix := make([]byte, len(snapshotMagic)+8)
+	if _, err := io.ReadFull(r, prefix); err != nil || !bytes.Equal(prefix[:len(snapshotMagic)], snapshotMagic) {
+		return nil, nil, errors.New("cache: not a herald snapshot")
+	}
+	if format := binary.BigEndian.Uint32(prefix[len(snapshotMagic):]); format != snapshotFormat {
+		return nil, nil, fmt.Errorf("cache: unsupported snapshot format %d", format)
+	}
+	hdrLen := binary.BigEndian.Uint32(prefix[len(snapshotMagic)+4:])
+	if hdrLen > maxSnapshotHeader {
+		return nil, nil, errors.New("cache: corrupt snapshot header")
+	}
+	hdrJSON := make([]byte, hdrLen)
+	if _, err := io.ReadFull(r, hdrJSON); err != nil {
+		return nil, nil, fmt.Errorf("cache: truncated snapshot: %w", err)
+	}
+	var hdr snapshotHeader
+	if err := json.Unmarshal(hdrJSON, &hdr); err != nil || hdr.Key == nil {
+		return nil, nil, errors.New("cache: corrupt snapshot header")
+	}
+	payload, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var key []byte
+	for _, src := range srcs {
+		if k, ok := src.unlock(hdr.Key); ok {
+			key = k
+			break
+		}
+	}
+	if key == nil {
+		return nil, nil, ErrSnapshotKey
+	}
+	data, err := decrypt(key, payload)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cache: snapshot failed authentication: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	if int64(len(data)) != hdr.Size || !bytes.Equal(sum[:], hdr.SHA256) {
+		return nil, nil, errors.New("cache: snapshot checksum mismatch")
+	}
+	return data, &hdr, nil
+}
+
+// verifyRestored checks the bbolt file at path for consistency and that
+// srcs unlock its current key version, so the restored cache is readable.
+func verifyRestored(path string, srcs []keySource) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("cache: restored file is not a valid database: %w", err)
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error {
+		var checkErr error
+		for err := range tx.Check() {
+			if checkErr == nil {
+				checkErr = err // keep draining so the checker goroutine exits
+			}
+		}
+		if checkErr != nil {
+			return fmt.Errorf("cache: restored database is inconsistent: %w", checkErr)
+		}
+		if tx.Bucket(bucketName) == nil {
+			return errors.New("cache: restored database has no secrets bucket")
+		}
+		mb := tx.Bucket(metaBucket)
+		if mb == nil || mb.Get(metaKeys) == nil {
+			return errors.New("cache: restored database has no key metadata")
+		}
+		var meta keyMeta
+		if err := json.Unmarshal(mb.Get(metaKeys), &meta); err != nil {
+			return fmt.Errorf("cache: corrupt key metadata: %w", err)
+		}
+		v := meta.Versions[meta.Current]
+		if v == nil {
+			return errors.New("cache: restored key metadata has no current version")
+		}
+		for _, src := range srcs {
+			if _, ok := src.unlock(v); ok {
+				return nil
+			}
+		}
+		return fmt.Errorf("cache: restored cache key version %d is not unlocked by the configured keys", meta.Current)
+	})
+}
@@ -1,8 +1,14 @@
 package cache
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	bolt "go.etcd.io/bbolt"
@@ -18,113 +24,295 @@ const (
 	PolicyTmpfs     = "tmpfs"
 	PolicyEncrypted = "encrypted"
 	PolicyFile      = "file"
+	PolicyNone      = "none"
 )
 
+// Policies lists every cache policy name.
+var Policies = []string{PolicyNone, PolicyMemory, PolicyTmpfs, PolicyEncrypted, PolicyFile}
+
+// PolicyRank orders policies from least to most persistent. Unknown or empty
+// policies rank last.
+func PolicyRank(p string) int {
+	for i, name := range Policies {
+		if p == name {
+			return i
+		}
+	}
+	return len(Policies)
+}
+
+// ValidPolicy reports whether p is a known cache policy.
+func ValidPolicy(p string) bool {
+	for _, name := range Policies {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
 var bucketName = []byte("secrets")
 
 type Entry struct {
 	Value     string    `json:"value"`
 	Provider  string    `json:"provider"`
 	Policy    string    `json:"policy"`
+	CreatedAt time.Time `json:"created_at,omitempty"` // set by Set when zero
 	ExpiresAt time.Time `json:"expires_at"`
+
+	KeyVersion uint32 `json:"-"` // key version that encrypted the entry; 0 for memory or legacy entries
+}
+
+// Age returns how long ago the entry was cached, or 0 if unknown (entries
+// written before CreatedAt existed).
+func (e *Entry) Age() time.Duration {
+	if e.CreatedAt.IsZero() {
+		return 0
+	}
+	return time.Since(e.CreatedAt)
 }
 
 type Store struct {
-	db  *bolt.DB
-	key []byte
-	mem map[string]*Entry // memory-only entries
+	db      *bolt.DB
+	keyMu   sync.RWMutex // held for writing by Rekey
+	keys    *keyring
+	source  keySource           // creates new key versions on rotation
+	mem     *lru                // memory-only entries
+	dirs    map[string]*dirTier // PolicyTmpfs / PolicyFile tiers, set up by SetDir
+	expired atomic.Int64        // entries removed by Sweep
+
+	floorMu sync.Mutex
+	floors  map[string]string // cache key -> most persistent policy allowed, see SetPolicyFloors
 }
 
-func New(path, passphrase string) (*Store, error) {
+// Stats describes the cache's size and housekeeping counters.
+type Stats struct {
+	MemoryEntries   int    `json:"memory_entries"`
+	MemoryBytes     int64  `json:"memory_bytes"`
+	MemoryEvictions int64  `json:"memory_evictions"`      // dropped to stay within the LRU bounds
+	MemoryOnly      bool   `json:"memory_only,omitempty"` // degraded mode: nothing is persisted
+	ExpiredSwept    int64  `json:"expired_swept"`         // removed by the sweeper, all tiers
+	KeyVersion      uint32 `json:"key_version"`           // version new entries are encrypted under
+}
+
+// New opens the cache at path. passphrase unlocks (or creates) the current
+// key version; previous passphrases keep entries under older versions
+// readable until they are rewritten or rekeyed.
+func New(path, passphrase string, previous ...string) (*Store, error) {
+	srcs := make([]keySource, len(previous))
+	for i, p := range previous {
+		srcs[i] = passphraseSource(p)
+	}
+	return open(path, passphraseSource(passphrase), srcs, deriveKey(passphrase))
+}
+
+func open(path string, current keySource, previous []keySource, legacy []byte) (*Store, error) {
 	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
 	if err != nil {
 		return nil, err
 	}
+	var keys *keyring
 	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(bucketName)
+		if _, err := tx.CreateBucketIfNotExists(bucketName); err != nil {
+			return err
+		}
+		keys, err = loadKeys(tx, current, previous, legacy)
 		return err
 	})
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 	return &Store{
-		db:  db,
-		key: deriveKey(passphrase),
-		mem: make(map[string]*Entry),
+		db:     db,
+		keys:   keys,
+		source: current,
+		mem:    newLRU(DefaultMaxEntries, DefaultMaxBytes),
+		dirs:   make(map[string]*dirTier),
 	}, nil
 }
 
-func (s *Store) Close() error { return s.db.Close() }
+// SetDir backs a directory policy (PolicyTmpfs or PolicyFile) with dir,
+// creating it 0700. For PolicyTmpfs, dir should be on a RAM-backed mount such
+// as /dev/shm so entries never reach persistent disk. Without SetDir, tmpfs
+// entries fall back to memory and file entries to the encrypted bbolt store.
+// Call before serving requests.
+func (s *Store) SetDir(policy, dir string) error {
+	if policy != PolicyTmpfs && policy != PolicyFile {
+		return fmt.Errorf("cache: policy %q is not directory-backed", policy)
+	}
+	if s.db == nil {
+		return ErrMemoryOnly
+	}
+	tier, err := newDirTier(dir)
+	if err != nil {
+		return fmt.Errorf("cache: %s dir: %w", policy, err)
+	}
+	s.dirs[policy] = tier
+	return nil
+}
+
+// SetMemoryLimits bounds the memory tier by entry count and approximate
+// bytes; least recently used entries are evicted beyond either. Non-positive
+// values remove that bound.
+func (s *Store) SetMemoryLimits(maxEntries int, maxBytes int64) {
+	s.mem.setLimits(maxEntries, maxBytes)
+}
+
+// Stats returns the current size and eviction counters.
+func (s *Store) Stats() Stats {
+	entries, bytes, evictions := s.mem.stats()
+	return Stats{
+		MemoryEntries:   entries,
+		MemoryBytes:     bytes,
+		MemoryEvictions: evictions,
+		ExpiredSwept:    s.expired.Load(),
+		MemoryOnly:      s.MemoryOnly(),
+		KeyVersion:      s.KeyVersion(),
+	}
+}
+
+func (s *Store) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
 
 // DB returns the underlying bbolt database, allowing other subsystems (e.g. the
 // stack index) to persist data in the same file under a separate bucket.
 func (s *Store) DB() *bolt.DB { return s.db }
 
+// Set stores entry under its Policy, or under the key's policy floor if that
+// is less persistent (see SetPolicyFloors). Any copy in another tier is
+// removed, so changing a ref's policy (e.g. to none) also purges where it
+// used to live. PolicyNone stores nothing.
 func (s *Store) Set(cacheKey string, entry *Entry) error {
-	if entry.Policy == PolicyMemory {
-		s.mem[cacheKey] = entry
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	entry.Policy = s.floor(cacheKey, entry.Policy)
+	policy := s.effectivePolicy(entry.Policy)
+	s.deleteKey(cacheKey, policy)
+
+	switch policy {
+	case PolicyNone:
+		return nil
+	case PolicyMemory:
+		s.mem.set(cacheKey, entry)
 		return nil
 	}
+
 	data, err := json.Marshal(entry)
 	if err != nil {
 		return err
 	}
-	encrypted, err := encrypt(s.key, data)
+	s.keyMu.RLock()
+	defer s.keyMu.RUnlock()
+	encrypted, err := s.keys.seal(data)
 	if err != nil {
 		return err
 	}
+	if tier, ok := s.dirs[policy]; ok {
+		return tier.put(cacheKey, encrypted)
+	}
 	return s.db.Update(func(tx *bolt.Tx) error {
 		return tx.Bucket(bucketName).Put([]byte(cacheKey), encrypted)
 	})
 }
 
-func (s *Store) Get(cacheKey string) (*Entry, error) {
-	// Check memory cache first
-	if e, ok := s.mem[cacheKey]; ok {
-		if time.Now().After(e.ExpiresAt) {
-			return nil, ErrExpired
+// SetPolicyFloors replaces the per-key policy floors: the most persistent
+// policy each cache key may be stored under, whatever policy a Set asks for.
+// Stacks share cache keys, so a secret annotated policy=none or tmpfs in one
+// stack must stay off persistent disk when another stack caches it. Copies
+// already held above a key's new floor are removed.
+func (s *Store) SetPolicyFloors(floors map[string]string) {
+	s.floorMu.Lock()
+	prev := s.floors
+	s.floors = floors
+	s.floorMu.Unlock()
+
+	for key, policy := range floors {
+		if cur, ok := prev[key]; !ok || PolicyRank(policy) < PolicyRank(cur) {
+			s.deleteKey(key, s.effectivePolicy(policy))
 		}
-		return e, nil
 	}
+}
 
-	var raw []byte
-	err := s.db.View(func(tx *bolt.Tx) error {
-		v := tx.Bucket(bucketName).Get([]byte(cacheKey))
-		if v == nil {
-			return ErrNotFound
-		}
-		raw = make([]byte, len(v))
-		copy(raw, v)
-		return nil
-	})
-	if err != nil {
-		return nil, err
+// floor returns the less persistent of policy and cacheKey's floor.
+func (s *Store) floor(cacheKey, policy string) string {
+	s.floorMu.Lock()
+	defer s.floorMu.Unlock()
+	if f, ok := s.floors[cacheKey]; ok && PolicyRank(f) < PolicyRank(policy) {
+		return f
 	}
+	return policy
+}
 
-	decrypted, err := decrypt(s.key, raw)
-	if err != nil {
-		return nil, err
+// effectivePolicy maps a policy to the tier that will hold it, applying the
+// fallbacks for directory policies without a configured directory. In
+// memory-only mode everything cacheable is held in memory.
+func (s *Store) effectivePolicy(policy string) string {
+	if s.db == nil && policy != PolicyNone {
+		return PolicyMemory
+	}
+	switch policy {
+	case PolicyNone, PolicyMemory:
+		return policy
+	case PolicyTmpfs:
+		if _, ok := s.dirs[PolicyTmpfs]; ok {
+			return PolicyTmpfs
+		}
+		return PolicyMemory
+	case PolicyFile:
+		if _, ok := s.dirs[PolicyFile]; ok {
+			return PolicyFile
+		}
 	}
+	return PolicyEncrypted
+}
 
-	var entry Entry
-	if err := json.Unmarshal(decrypted, &entry); err != nil {
+func (s *Store) Get(cacheKey string) (*Entry, error) {
+	entry, err := s.lookup(cacheKey)
+	if err != nil {
 		return nil, err
 	}
-
 	if time.Now().After(entry.ExpiresAt) {
 		return nil, ErrExpired
 	}
-
-	return &entry, nil
+	return entry, nil
 }
 
 // GetStale returns an entry regardless of TTL. Used as a fallback when the
 // provider is unavailable (e.g. rate limited) to serve the last-known value.
 func (s *Store) GetStale(cacheKey string) (*Entry, error) {
-	if e, ok := s.mem[cacheKey]; ok {
+	return s.lookup(cacheKey)
+}
+
+// lookup finds cacheKey in memory, then the directory tiers, then bbolt.
+func (s *Store) lookup(cacheKey string) (*Entry, error) {
+	if e, ok := s.mem.get(cacheKey); ok {
 		return e, nil
 	}
 
+	for _, policy := range []string{PolicyTmpfs, PolicyFile} {
+		tier, ok := s.dirs[policy]
+		if !ok {
+			continue
+		}
+		raw, err := tier.get(cacheKey)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return s.decode(raw)
+	}
+
+	if s.db == nil {
+		return nil, ErrNotFound
+	}
 	var raw []byte
 	err := s.db.View(func(tx *bolt.Tx) error {
 		v := tx.Bucket(bucketName).Get([]byte(cacheKey))
@@ -138,92 +326,90 @@ func (s *Store) GetStale(cacheKey string) (*Entry, error) {
 	if err != nil {
 		return nil, err
 	}
+	return s.decode(raw)
+}
 
-	decrypted, err := decrypt(s.key, raw)
+func (s *Store) decode(raw []byte) (*Entry, error) {
+	s.keyMu.RLock()
+	decrypted, version, err := s.keys.open(raw)
+	s.keyMu.RUnlock()
 	if err != nil {
 		return nil, err
 	}
-
 	var entry Entry
 	if err := json.Unmarshal(decrypted, &entry); err != nil {
 		return nil, err
 	}
-
+	entry.KeyVersion = version
 	return &entry, nil
 }
 
 func (s *Store) Delete(cacheKey string) {
-	delete(s.mem, cacheKey)
-	s.db.Update(func(tx *bolt.Tx) error {
-		return tx.Bucket(bucketName).Delete([]byte(cacheKey))
-	})
+	s.deleteKey(cacheKey, "")
+}
+
+// deleteKey removes cacheKey from every tier except keep.
+func (s *Store) deleteKey(cacheKey, keep string) {
+	if keep != PolicyMemory {
+		s.mem.delete(cacheKey)
+	}
+	for policy, tier := range s.dirs {
+		if policy != keep {
+			os.Remove(tier.path(cacheKey))
+		}
+	}
+	if keep != PolicyEncrypted && s.db != nil {
+		// Check first: a read transaction is far cheaper than a write (which
+		// fsyncs), and most memory-policy Sets have nothing on disk to purge.
+		var exists bool
+		s.db.View(func(tx *bolt.Tx) error {
+			exists = tx.Bucket(bucketName).Get([]byte(cacheKey)) != nil
+			return nil
+		})
+		if exists {
+			s.db.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket(bucketName).Delete([]byte(cacheKey))
+			})
+		}
+	}
 }
 
 // InvalidateByItemID invalidates all cache entries containing the given itemID
 // in their key (format: vault/item/field). Returns count of invalidated entries.
 func (s *Store) InvalidateByItemID(itemID string) int {
-	count := 0
-	// Invalidate memory entries
-	for k := range s.mem {
+	return s.deleteMatching(func(k string) bool {
 		parts := splitCacheKey(k)
-		if len(parts) >= 2 && parts[1] == itemID {
-			delete(s.mem, k)
-			count++
-		}
-	}
-	// Invalidate bolt entries
-	s.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketName)
-		c := b.Cursor()
-		var toDelete [][]byte
-		for k, _ := c.First(); k != nil; k, _ = c.Next() {
-			parts := splitCacheKey(string(k))
-			if len(parts) >= 2 && parts[1] == itemID {
-				toDelete = append(toDelete, append([]byte{}, k...))
-				count++
-			}
-		}
-		for _, k := range toDelete {
-			b.Delete(k)
-		}
-		return nil
-	})
-	return count
+		return len(parts) >= 2 && parts[1] == itemID
+	}, "")
 }
 
 // InvalidateByVaultAndItemID invalidates all cache entries for a specific vault+item pair.
 // Cache keys are vault/item/field, so this is more precise than InvalidateByItemID.
 func (s *Store) InvalidateByVaultAndItemID(vault, itemID string) int {
-	count := 0
-	for k := range s.mem {
+	return s.deleteMatching(func(k string) bool {
 		parts := splitCacheKey(k)
-		if len(parts) >= 2 && parts[0] == vault && parts[1] == itemID {
-			delete(s.mem, k)
-			count++
-		}
-	}
-	s.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketName)
-		c := b.Cursor()
-		var toDelete [][]byte
-		for k, _ := c.First(); k != nil; k, _ = c.Next() {
-			parts := splitCacheKey(string(k))
-			if len(parts) >= 2 && parts[0] == vault && parts[1] == itemID {
-				toDelete = append(toDelete, append([]byte{}, k...))
-				count++
-			}
-		}
-		for _, k := range toDelete {
-			b.Delete(k)
-		}
-		return nil
-	})
-	return count
+		return len(parts) >= 2 && parts[0] == vault && parts[1] == itemID
+	}, "")
 }
 
-// Flush removes all entries from the cache (both memory and bolt).
+// InvalidateMatching invalidates every entry whose vault, item and field
+// satisfy match, and returns the number of entries removed.
+func (s *Store) InvalidateMatching(match func(vault, item, field string) bool) int {
+	return s.deleteMatching(func(k string) bool {
+		parts := splitCacheKey(k)
+		return len(parts) == 3 && match(parts[0], parts[1], parts[2])
+	}, "")
+}
+
+// Flush removes all entries from the cache (memory, directory tiers and bolt).
 func (s *Store) Flush() {
-	s.mem = make(map[string]*Entry)
+	s.mem.clear()
+	for _, tier := range s.dirs {
+		tier.deleteMatching(func(string) bool { return true })
+	}
+	if s.db == nil {
+		return
+	}
 	s.db.Update(func(tx *bolt.Tx) error {
 		tx.DeleteBucket(bucketName)
 		_, err := tx.CreateBucket(bucketName)
@@ -232,24 +418,87 @@ func (s *Store) Flush() {
 }
 
 func (s *Store) DeletePrefix(prefix string) {
-	// Delete all keys with given prefix from mem
-	for k := range s.mem {
-		if len(k) >= len(prefix) && k[:len(prefix)] == prefix {
-			delete(s.mem, k)
+	s.deleteMatching(func(k string) bool { return strings.HasPrefix(k, prefix) }, "")
+}
+
+// deleteMatching removes matching keys from every tier except keep (a policy
+// name, or "" for none) and returns the number of entries removed.
+func (s *Store) deleteMatching(match func(cacheKey string) bool, keep string) int {
+	count := 0
+	if keep != PolicyMemory {
+		count += s.mem.deleteMatching(match)
+	}
+	for policy, tier := range s.dirs {
+		if policy != keep {
+			count += tier.deleteMatching(match)
 		}
 	}
-	// Delete from bolt
-	s.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketName)
-		c := b.Cursor()
-		for k, _ := c.Seek([]byte(prefix)); k != nil; k, _ = c.Next() {
-			if len(k) < len(prefix) || string(k[:len(prefix)]) != prefix {
-				break
+	if keep != PolicyEncrypted && s.db != nil {
+		s.db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(bucketName)
+			c := b.Cursor()
+			var toDelete [][]byte
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				if match(string(k)) {
+					toDelete = append(toDelete, append([]byte{}, k...))
+				}
+			}
+			for _, k := range toDelete {
+				b.Delete(k)
+				count++
+			}
+			return nil
+		})
+	}
+	return count
+}
+
+// Sweep removes entries from every tier that expired more than retain ago
+// and returns how many were removed. retain keeps recently expired entries
+// available to GetStale for stale-while-unavailable serving.
+func (s *Store) Sweep(retain time.Duration) int {
+	cutoff := time.Now().Add(-retain)
+	count := s.mem.removeExpiredBefore(cutoff)
+
+	expired := func(raw []byte) bool {
+		e, err := s.decode(raw)
+		return err == nil && e.ExpiresAt.Before(cutoff)
+	}
+	for _, tier := range s.dirs {
+		count += tier.deleteIf(expired)
+	}
+	if s.db != nil {
+		// Decode outside any bolt transaction: decode takes keyMu, which
+		// Rekey holds while it waits for the write transaction.
+		candidates := make(map[string][]byte)
+		s.db.View(func(tx *bolt.Tx) error {
+			return tx.Bucket(bucketName).ForEach(func(k, v []byte) error {
+				candidates[string(k)] = bytes.Clone(v)
+				return nil
+			})
+		})
+		for k, v := range candidates {
+			if !expired(v) {
+				delete(candidates, k)
 			}
-			b.Delete(k)
 		}
-		return nil
-	})
+		if len(candidates) > 0 {
+			s.db.Update(func(tx *bolt.Tx) error {
+				b := tx.Bucket(bucketName)
+				for k, v := range candidates {
+					// Skip entries rewritten since they were read.
+					if bytes.Equal(b.Get([]byte(k)), v) {
+						b.Delete([]byte(k))
+						count++
+					}
+				}
+				return nil
+			})
+		}
+	}
+
+	s.expired.Add(int64(count))
+	return count
 }
 
 func splitCacheKey(key string) []string {
@@ -1,7 +1,12 @@
 package cache_test
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -73,3 +78,374 @@ func TestCacheDelete(t *testing.T) {
 		t.Errorf("Get() after Delete() = %v, want ErrNotFound", err)
 	}
 }
+
+func TestCachePolicyTiers(t *testing.T) {
+	dir := t.TempDir()
+	store, err := cache.New(dir+"/test.db", "test-encryption-key-32chars!!")
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	defer store.Close()
+	if err := store.SetDir(cache.PolicyTmpfs, dir+"/shm"); err != nil {
+		t.Fatalf("SetDir() error = %v", err)
+	}
+
+	key := "vault/item/field"
+	set := func(policy string) {
+		t.Helper()
+		if err := store.Set(key, &cache.Entry{Value: "v-" + policy, Policy: policy, ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatalf("Set(%s) error = %v", policy, err)
+		}
+	}
+
+	set(cache.PolicyEncrypted)
+	set(cache.PolicyTmpfs)
+	files, _ := os.ReadDir(dir + "/shm")
+	if len(files) != 1 {
+		t.Fatalf("tmpfs dir has %d files, want 1", len(files))
+	}
+	if data, _ := os.ReadFile(dir + "/shm/" + files[0].Name()); strings.Contains(string(data), "v-tmpfs") {
+		t.Error("tmpfs entry is not encrypted")
+	}
+	got, err := store.Get(key)
+	if err != nil || got.Value != "v-tmpfs" {
+		t.Fatalf("Get() = %v, %v; want v-tmpfs", got, err)
+	}
+
+	// Moving to none must purge the tmpfs copy (and the earlier bbolt copy).
+	set(cache.PolicyNone)
+	if _, err := store.GetStale(key); err != cache.ErrNotFound {
+		t.Errorf("GetStale() after policy none error = %v, want ErrNotFound", err)
+	}
+
+	set(cache.PolicyTmpfs)
+	if n := store.InvalidateByVaultAndItemID("vault", "item"); n != 1 {
+		t.Errorf("InvalidateByVaultAndItemID() = %d, want 1", n)
+	}
+	if files, _ := os.ReadDir(dir + "/shm"); len(files) != 0 {
+		t.Errorf("tmpfs dir has %d files after invalidation, want 0", len(files))
+	}
+}
+
+func TestCacheMemoryLRU(t *testing.T) {
+	store, err := cache.New(t.TempDir()+"/test.db", "test-encryption-key-32chars!!")
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	defer store.Close()
+	store.SetMemoryLimits(2, 0)
+
+	set := func(key string) {
+		store.Set(key, &cache.Entry{Value: "v", Policy: cache.PolicyMemory, ExpiresAt: time.Now().Add(time.Hour)})
+	}
+	set("a")
+	set("b")
+	store.Get("a") // a is now more recently used than b
+	set("c")
+
+	if _, err := store.Get("b"); err != cache.ErrNotFound {
+		t.Errorf("Get(b) error = %v, want ErrNotFound (least recently used)", err)
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, err := store.Get(key); err != nil {
+			t.Errorf("Get(%s) error = %v", key, err)
+		}
+	}
+	st := store.Stats()
+	if st.MemoryEntries != 2 || st.MemoryEvictions != 1 {
+		t.Errorf("Stats() = %+v, want 2 entries and 1 eviction", st)
+	}
+
+	// A byte bound smaller than two entries keeps only the newest.
+	store.SetMemoryLimits(0, 200)
+	if st := store.Stats(); st.MemoryEntries != 1 || st.MemoryBytes > 200 {
+		t.Errorf("Stats() after byte bound = %+v, want 1 entry within 200 bytes", st)
+	}
+}
+
+func TestCacheSweep(t *testing.T) {
+	store, err := cache.New(t.TempDir()+"/test.db", "test-encryption-key-32chars!!")
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now()
+	store.Set("fresh", &cache.Entry{Value: "v", Policy: cache.PolicyMemory, ExpiresAt: now.Add(time.Hour)})
+	store.Set("recent", &cache.Entry{Value: "v", Policy: cache.PolicyEncrypted, ExpiresAt: now.Add(-time.Minute)})
+	store.Set("old-mem", &cache.Entry{Value: "v", Policy: cache.PolicyMemory, ExpiresAt: now.Add(-2 * time.Hour)})
+	store.Set("old-disk", &cache.Entry{Value: "v", Policy: cache.PolicyEncrypted, ExpiresAt: now.Add(-2 * time.Hour)})
+
+	if n := store.Sweep(time.Hour); n != 2 {
+		t.Errorf("Sweep() = %d, want 2", n)
+	}
+	if _, err := store.GetStale("recent"); err != nil {
+		t.Errorf("GetStale(recent) error = %v, want entry retained for stale serving", err)
+	}
+	for _, key := range []string{"old-mem", "old-disk"} {
+		if _, err := store.GetStale(key); err != cache.ErrNotFound {
+			t.Errorf("GetStale(%s) error = %v, want ErrNotFound", key, err)
+		}
+	}
+	if st := store.Stats(); st.ExpiredSwept != 2 {
+		t.Errorf("ExpiredSwept = %d, want 2", st.ExpiredSwept)
+	}
+}
+
+func TestCacheRekey(t *testing.T) {
+	path := t.TempDir() + "/test.db"
+	store, err := cache.New(path, "old-passphrase")
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	entry := &cache.Entry{Value: "secret", Policy: cache.PolicyEncrypted, ExpiresAt: time.Now().Add(time.Hour)}
+	store.Set("vault/item/field", entry)
+	if got, err := store.Get("vault/item/field"); err != nil || got.KeyVersion != 1 {
+		t.Fatalf("Get() = %+v, %v; want key version 1", got, err)
+	}
+
+	res, err := store.Rekey("new-passphrase")
+	if err != nil {
+		t.Fatalf("Rekey() error = %v", err)
+	}
+	if res.Version != 2 || res.Reencrypted != 1 || res.Dropped != 0 {
+		t.Errorf("Rekey() = %+v, want version 2 with 1 entry re-encrypted", res)
+	}
+	if got, err := store.Get("vault/item/field"); err != nil || got.Value != "secret" || got.KeyVersion != 2 {
+		t.Errorf("Get() after Rekey() = %+v, %v; want secret under version 2", got, err)
+	}
+	store.Close()
+
+	// The retired passphrase unlocks nothing: a fresh version is created and
+	// existing entries are unreadable.
+	store, err = cache.New(path, "old-passphrase")
+	if err != nil {
+		t.Fatalf("cache.New(old) error = %v", err)
+	}
+	if _, err := store.Get("vault/item/field"); err == nil {
+		t.Error("Get() with retired passphrase succeeded, want error")
+	}
+	if v := store.KeyVersion(); v != 3 {
+		t.Errorf("KeyVersion() = %d, want 3", v)
+	}
+	store.Set("vault/item/other", entry)
+	store.Close()
+
+	// Listing the earlier passphrase as previous keeps version 2 readable
+	// alongside the current one.
+	store, err = cache.New(path, "old-passphrase", "new-passphrase")
+	if err != nil {
+		t.Fatalf("cache.New(previous) error = %v", err)
+	}
+	defer store.Close()
+	for key, version := range map[string]uint32{"vault/item/field": 2, "vault/item/other": 3} {
+		if got, err := store.Get(key); err != nil || got.KeyVersion != version {
+			t.Errorf("Get(%s) = %+v, %v; want key version %d", key, got, err, version)
+		}
+	}
+}
+
+// TestCacheSweepDuringRekey guards the lock order between Sweep and Rekey:
+// each once held what the other was waiting for.
+func TestCacheSweepDuringRekey(t *testing.T) {
+	store, err := cache.New(t.TempDir()+"/test.db", "old-passphrase")
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	now := time.Now()
+	for i := range 50 {
+		store.Set(fmt.Sprintf("vault/item/f%d", i), &cache.Entry{Value: "v", Policy: cache.PolicyEncrypted, ExpiresAt: now.Add(-2 * time.Hour)})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for i := range 5 {
+			wg.Add(3)
+			go func() {
+				defer wg.Done()
+				store.Sweep(time.Hour)
+			}()
+			go func() {
+				defer wg.Done()
+				store.List(nil)
+			}()
+			go func() {
+				defer wg.Done()
+				if _, err := store.Rekey(fmt.Sprintf("passphrase-%d", i)); err != nil {
+					t.Errorf("Rekey() error = %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("Sweep or List deadlocked with Rekey") // Close would block too
+	}
+	defer store.Close()
+	if n := len(store.List(nil)); n != 0 {
+		t.Errorf("List() after sweeps = %d entries, want 0", n)
+	}
+}
+
+func TestCacheKEK(t *testing.T) {
+	path := t.TempDir() + "/test.db"
+	kek, _ := cache.NewAESKEK("test-kek", []byte("0123456789abcdef0123456789abcdef"))
+	entry := &cache.Entry{Value: "secret", Policy: cache.PolicyEncrypted, ExpiresAt: time.Now().Add(time.Hour)}
+
+	// Start in passphrase mode, then move to the KEK with the passphrase as
+	// a previous key.
+	store, err := cache.New(path, "passphrase")
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	store.Set("vault/item/field", entry)
+	store.Close()
+
+	store, err = cache.NewWithKEK(path, kek, "passphrase")
+	if err != nil {
+		t.Fatalf("cache.NewWithKEK() error = %v", err)
+	}
+	if got, err := store.Get("vault/item/field"); err != nil || got.KeyVersion != 1 {
+		t.Fatalf("Get() = %+v, %v; want passphrase-mode entry under version 1", got, err)
+	}
+	if res, err := store.RotateDataKey(); err != nil || res.Reencrypted != 1 {
+		t.Fatalf("RotateDataKey() = %+v, %v; want 1 entry re-encrypted", res, err)
+	}
+	store.Close()
+
+	// The passphrase is no longer needed.
+	store, err = cache.NewWithKEK(path, kek)
+	if err != nil {
+		t.Fatalf("cache.NewWithKEK() error = %v", err)
+	}
+	got, err := store.Get("vault/item/field")
+	if err != nil || got.Value != "secret" {
+		t.Errorf("Get() under KEK = %+v, %v; want secret", got, err)
+	}
+	store.Close()
+
+	// A different KEK cannot unwrap the data key.
+	other, _ := cache.NewAESKEK("other-kek", []byte("fedcba9876543210fedcba9876543210"))
+	store, err = cache.NewWithKEK(path, other)
+	if err != nil {
+		t.Fatalf("cache.NewWithKEK(other) error = %v", err)
+	}
+	defer store.Close()
+	if _, err := store.Get("vault/item/field"); err == nil {
+		t.Error("Get() with a different KEK succeeded, want error")
+	}
+}
+
+func TestCacheMemoryOnly(t *testing.T) {
+	store := cache.NewMemoryOnly()
+	defer store.Close()
+
+	store.Set("vault/item/field", &cache.Entry{Value: "secret", Policy: cache.PolicyEncrypted, ExpiresAt: time.Now().Add(time.Hour)})
+	if got, err := store.Get("vault/item/field"); err != nil || got.Value != "secret" {
+		t.Errorf("Get() = %+v, %v; want secret held in memory", got, err)
+	}
+	if st := store.Stats(); !st.MemoryOnly || st.MemoryEntries != 1 {
+		t.Errorf("Stats() = %+v, want memory_only with 1 entry", st)
+	}
+	if _, err := store.RotateDataKey(); err != cache.ErrMemoryOnly {
+		t.Errorf("RotateDataKey() error = %v, want ErrMemoryOnly", err)
+	}
+	if err := store.SetDir(cache.PolicyTmpfs, t.TempDir()); err != cache.ErrMemoryOnly {
+		t.Errorf("SetDir() error = %v, want ErrMemoryOnly", err)
+	}
+}
+
+func TestCacheList(t *testing.T) {
+	store, err := cache.New(t.TempDir()+"/cache.db", "test-passphrase")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer store.Close()
+	if err := store.SetDir(cache.PolicyFile, t.TempDir()); err != nil {
+		t.Fatalf("SetDir() error = %v", err)
+	}
+
+	exp := time.Now().Add(time.Hour)
+	store.Set("vault/app/password", &cache.Entry{Value: "hunter2", Provider: "connect", Policy: cache.PolicyMemory, ExpiresAt: exp})
+	store.Set("vault/app/token", &cache.Entry{Value: "tok", Policy: cache.PolicyEncrypted, ExpiresAt: exp})
+	store.Set("vault/db/password", &cache.Entry{Value: "pw", Policy: cache.PolicyFile, ExpiresAt: time.Now().Add(-time.Minute)})
+
+	all := store.List(nil)
+	if len(all) != 3 {
+		t.Fatalf("List(nil) = %d entries, want 3", len(all))
+	}
+	if all[0].Key != "vault/app/password" || all[0].Tier != cache.PolicyMemory || all[0].Size != 7 ||
+		all[0].Fingerprint != cache.Fingerprint("hunter2") || all[0].Provider != "connect" {
+		t.Errorf("List()[0] = %+v", all[0])
+	}
+	if all[1].Tier != cache.PolicyEncrypted || all[1].KeyVersion == 0 {
+		t.Errorf("List()[1] = %+v, want encrypted tier with a key version", all[1])
+	}
+	if all[2].Tier != cache.PolicyFile || !all[2].Expired {
+		t.Errorf("List()[2] = %+v, want expired file-tier entry", all[2])
+	}
+
+	app := store.List(func(k string) bool { return strings.HasPrefix(k, "vault/app/") })
+	if len(app) != 2 {
+		t.Errorf("List(vault/app/) = %d entries, want 2", len(app))
+	}
+}
+
+func TestCacheSnapshotRestore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := cache.New(dir+"/cache.db", "test-passphrase")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	store.Set("vault/item/field", &cache.Entry{Value: "secret", Policy: cache.PolicyEncrypted, ExpiresAt: time.Now().Add(time.Hour)})
+	var snap bytes.Buffer
+	info, err := store.Snapshot(&snap)
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	if _, err := store.Snapshot(failingWriter{}); err == nil {
+		t.Error("Snapshot() to a failing writer succeeded")
+	}
+	store.Close()
+	if info.KeyVersion != 1 || info.Size == 0 {
+		t.Errorf("Snapshot() info = %+v", info)
+	}
+	if strings.Contains(snap.String(), "secrets") {
+		t.Error("snapshot contains plaintext bucket names, want encrypted")
+	}
+
+	// Wrong key: rejected before anything is written.
+	target := dir + "/restored.db"
+	if _, err := cache.Restore(target, bytes.NewReader(snap.Bytes()), "other-passphrase"); err != cache.ErrSnapshotKey {
+		t.Errorf("Restore() with wrong key error = %v, want ErrSnapshotKey", err)
+	}
+	// Tampered payload: fails authentication.
+	tampered := append([]byte{}, snap.Bytes()...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := cache.Restore(target, bytes.NewReader(tampered), "test-passphrase"); err == nil {
+		t.Error("Restore() of tampered snapshot succeeded")
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("failed restores left %s behind (stat err = %v)", target, err)
+	}
+
+	if _, err := cache.Restore(target, bytes.NewReader(snap.Bytes()), "test-passphrase"); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	restored, err := cache.New(target, "test-passphrase")
+	if err != nil {
+		t.Fatalf("New(restored) error = %v", err)
+	}
+	defer restored.Close()
+	if got, err := restored.Get("vault/item/field"); err != nil || got.Value != "secret" {
+		t.Errorf("restored Get() = %+v, %v; want secret", got, err)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("write failed") }
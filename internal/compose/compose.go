@@ -0,0 +1,388 @@
+package compose
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/elabx-org/herald/internal/format"
+	"github.com/elabx-org/herald/internal/resolver"
+	"gopkg.in/yaml.v3"
+)
+
+// File is the subset of a Compose file Herald cares about: per-service
+// environment, env_file and x-herald blocks.
+type File struct {
+	Services map[string]*Service
+}
+
+// Service holds the secret-bearing configuration of a single Compose service.
+type Service struct {
+	Environment []EnvVar  // services.<name>.environment, in file order
+	EnvFiles    []EnvFile // services.<name>.env_file
+	Herald      []EnvVar  // services.<name>.x-herald.environment
+}
+
+// EnvVar is a single KEY=VALUE pair.
+type EnvVar struct {
+	Key   string
+	Value string
+}
+
+// EnvFile is an env_file entry. Path is kept exactly as written in the
+// Compose file (relative to the project directory).
+type EnvFile struct {
+	Path     string
+	Required bool
+}
+
+type rawFile struct {
+	Services map[string]rawService `yaml:"services"`
+}
+
+type rawService struct {
+	Environment yaml.Node `yaml:"environment"`
+	EnvFile     yaml.Node `yaml:"env_file"`
+	Herald      struct {
+		Environment yaml.Node `yaml:"environment"`
+	} `yaml:"x-herald"`
+}
+
+// Parse decodes Compose YAML. Only the fields Herald needs are read; anything
+// else in the file is ignored.
+func Parse(data []byte) (*File, error) {
+	var raw rawFile
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse compose file: %w", err)
+	}
+	f := &File{Services: make(map[string]*Service, len(raw.Services))}
+	for name, rs := range raw.Services {
+		env, err := decodeEnvironment(&rs.Environment)
+		if err != nil {
+			return nil, fmt.Errorf("service %q: environment: %w", name, err)
+		}
+		files, err := decodeEnvFiles(&rs.EnvFile)
+		if err != nil {
+			return nil, fmt.Errorf("service %q: env_file: %w", name, err)
+		}
+		herald, err := decodeEnvironment(&rs.Herald.Environment)
+		if err != nil {
+			return nil, fmt.Errorf("service %q: x-herald.environment: %w", name, err)
+		}
+		f.Services[name] = &Service{Environment: env, EnvFiles: files, Herald: herald}
+	}
+	return f, nil
+}
+
+// ServiceNames returns service names in sorted order.
+func (f *File) ServiceNames() []string {
+	names := make([]string, 0, len(f.Services))
+	for name := range f.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// EnvFilePaths returns every distinct env_file path referenced by any service.
+func (f *File) EnvFilePaths() []EnvFile {
+	seen := make(map[string]bool)
+	var out []EnvFile
+	for _, name := range f.ServiceNames() {
+		for _, ef := range f.Services[name].EnvFiles {
+			if seen[ef.Path] {
+				continue
+			}
+			seen[ef.Path] = true
+			out = append(out, ef)
+		}
+	}
+	return out
+}
+
+// Secrets returns, per service, the variables whose values contain op:// refs.
+// Precedence follows Compose: env_file entries are overridden by environment,
+// and x-herald.environment overrides both. envFiles maps env_file path (as
+// written in the Compose file) to its content; a missing required file is an
+// error, a missing optional one is skipped.
+func (f *File) Secrets(envFiles map[string]string) (map[string][]EnvVar, error) {
+	out := make(map[string][]EnvVar)
+	for _, name := range f.ServiceNames() {
+		svc := f.Services[name]
+		vals := make(map[string]string)
+		var order []string
+		set := func(k, v string) {
+			if _, ok := vals[k]; !ok {
+				order = append(order, k)
+			}
+			vals[k] = v
+		}
+
+		for _, ef := range svc.EnvFiles {
+			content, ok := envFiles[ef.Path]
+			if !ok {
+				if ef.Required {
+					return nil, fmt.Errorf("service %q: env_file %q not provided", name, ef.Path)
+				}
+				continue
+			}
+			for _, v := range parseEnvLines(content) {
+				set(v.Key, v.Value)
+			}
+		}
+		for _, v := range svc.Environment {
+			set(v.Key, v.Value)
+		}
+		for _, v := range svc.Herald {
+			set(v.Key, v.Value)
+		}
+
+		var secrets []EnvVar
+		for _, k := range order {
+			if resolver.HasRef(vals[k]) {
+				secrets = append(secrets, EnvVar{Key: k, Value: vals[k]})
+			}
+		}
+		if len(secrets) > 0 {
+			out[name] = secrets
+		}
+	}
+	return out, nil
+}
+
+// Refs scans the secret variables returned by Secrets and returns every op://
+// ref keyed by raw URI, plus the raw URIs each service consumes.
+func Refs(secrets map[string][]EnvVar) (map[string]*resolver.SecretRef, map[string][]string, error) {
+	refs := make(map[string]*resolver.SecretRef)
+	byService := make(map[string][]string)
+	for svc, vars := range secrets {
+		svcRefs := make(map[string]*resolver.SecretRef)
+		for _, v := range vars {
+			if err := resolver.ScanValue(v.Value, svcRefs); err != nil {
+				return nil, nil, fmt.Errorf("service %q: %s: %w", svc, v.Key, err)
+			}
+		}
+		for uri, ref := range svcRefs {
+			refs[uri] = ref
+			byService[svc] = append(byService[svc], uri)
+		}
+		sort.Strings(byService[svc])
+	}
+	return refs, byService, nil
+}
+
+// Override renders a Compose override file that sets the resolved secret
+// variables in each service's environment. Compose merges environment maps by
+// key, so the override only needs the secret-bearing variables. Literal "$" is
+// escaped as "$$" so Compose does not treat resolved values as interpolation.
+// Variables with refs missing from resolvedByURI are omitted.
+func Override(secrets map[string][]EnvVar, resolvedByURI map[string]string) (string, error) {
+	services := make(map[string]map[string]map[string]string, len(secrets))
+	for svc, vars := range secrets {
+		env := make(map[string]string, len(vars))
+		for _, v := range vars {
+			val := resolver.ResolveValue(v.Value, resolvedByURI)
+			if resolver.HasRef(val) {
+				continue
+			}
+			env[v.Key] = strings.ReplaceAll(val, "$", "$$")
+		}
+		if len(env) > 0 {
+			services[svc] = map[string]map[string]string{"environment": env}
+		}
+	}
+	data, err := yaml.Marshal(map[string]interface{}{"services": services})
+	if err != nil {
+		return "", fmt.Errorf("render compose override: %w", err)
+	}
+	return string(data), nil
+}
+
+// EnvFiles renders one dotenv file per service containing its resolved secret
+// variables, keyed by service name. Variables with refs missing from
+// resolvedByURI are omitted.
+func EnvFiles(secrets map[string][]EnvVar, resolvedByURI map[string]string) map[string]string {
+	out := make(map[string]string, len(secrets))
+	for svc, vars := range ServiceVars(secrets, resolvedByURI) {
+		var sb strings.Builder
+		for _, v := range vars {
+			sb.WriteString(v.Key + "=" + v.Value + "\n")
+		}
+		out[svc] = sb.String()
+	}
+	return out
+}
+
+// ServiceVars returns each service's resolved secret variables, for
+// rendering in an output format. Variables with refs missing from
+// resolvedByURI are omitted.
+func ServiceVars(secrets map[string][]EnvVar, resolvedByURI map[string]string) map[string][]format.Var {
+	out := make(map[string][]format.Var, len(secrets))
+	for svc, vars := range secrets {
+		resolved := []format.Var{}
+		for _, v := range vars {
+			val := resolver.ResolveValue(v.Value, resolvedByURI)
+			if resolver.HasRef(val) {
+				continue
+			}
+			resolved = append(resolved, format.Var{Key: v.Key, Value: val})
+		}
+		out[svc] = resolved
+	}
+	return out
+}
+
+// RefOptions collects the "# herald:" annotations of a Compose file and the
+// env files it loads (keyed as in Secrets). In the Compose file an
+// annotation applies to the environment entry on the next line, in either
+// list ("- KEY=op://...") or map ("KEY: op://...") form.
+func RefOptions(composeContent []byte, envFiles map[string]string) (map[string]resolver.RefOption, error) {
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(string(composeContent)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		line = strings.TrimSpace(strings.TrimPrefix(line, "- "))
+		if !strings.HasPrefix(line, "#") && !strings.Contains(line, "=") {
+			line = strings.Replace(line, ":", "=", 1)
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	opts, err := resolver.RefOptions(strings.Join(lines, "\n"))
+	if err != nil {
+		return nil, fmt.Errorf("compose file: %w", err)
+	}
+
+	paths := make([]string, 0, len(envFiles))
+	for path := range envFiles {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fileOpts, err := resolver.RefOptions(envFiles[path])
+		if err != nil {
+			return nil, fmt.Errorf("env_file %s: %w", path, err)
+		}
+		for uri, opt := range fileOpts {
+			if prev, seen := opts[uri]; seen && prev != opt {
+				return nil, fmt.Errorf("env_file %s: %s has conflicting herald annotations", path, uri)
+			}
+			opts[uri] = opt
+		}
+	}
+	return opts, nil
+}
+
+// RefKeys maps each raw op:// URI to the "service/KEY" names that use it.
+func RefKeys(secrets map[string][]EnvVar) map[string][]string {
+	keys := make(map[string][]string)
+	for _, svc := range sortedKeys(secrets) {
+		for _, v := range secrets[svc] {
+			refs := make(map[string]*resolver.SecretRef)
+			if err := resolver.ScanValue(v.Value, refs); err != nil {
+				continue
+			}
+			for uri := range refs {
+				keys[uri] = append(keys[uri], svc+"/"+v.Key)
+			}
+		}
+	}
+	return keys
+}
+
+func sortedKeys(m map[string][]EnvVar) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// decodeEnvironment accepts both Compose forms: a mapping (KEY: value) and a
+// sequence of "KEY=value" strings. Entries without a value are skipped since
+// they are passed through from the host and cannot hold op:// refs.
+func decodeEnvironment(n *yaml.Node) ([]EnvVar, error) {
+	switch n.Kind {
+	case 0:
+		return nil, nil
+	case yaml.MappingNode:
+		var vars []EnvVar
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if v.Tag == "!!null" {
+				continue
+			}
+			vars = append(vars, EnvVar{Key: k.Value, Value: v.Value})
+		}
+		return vars, nil
+	case yaml.SequenceNode:
+		var vars []EnvVar
+		for _, item := range n.Content {
+			key, val, ok := strings.Cut(item.Value, "=")
+			if !ok {
+				continue
+			}
+			vars = append(vars, EnvVar{Key: key, Value: val})
+		}
+		return vars, nil
+	default:
+		return nil, fmt.Errorf("expected mapping or list")
+	}
+}
+
+// decodeEnvFiles accepts a single path, a list of paths, or a list of
+// {path, required} objects.
+func decodeEnvFiles(n *yaml.Node) ([]EnvFile, error) {
+	switch n.Kind {
+	case 0:
+		return nil, nil
+	case yaml.ScalarNode:
+		return []EnvFile{{Path: n.Value, Required: true}}, nil
+	case yaml.SequenceNode:
+		var files []EnvFile
+		for _, item := range n.Content {
+			if item.Kind == yaml.ScalarNode {
+				files = append(files, EnvFile{Path: item.Value, Required: true})
+				continue
+			}
+			ef := struct {
+				Path     string `yaml:"path"`
+				Required *bool  `yaml:"required"`
+			}{}
+			if err := item.Decode(&ef); err != nil {
+				return nil, err
+			}
+			if ef.Path == "" {
+				return nil, fmt.Errorf("entry without path")
+			}
+			files = append(files, EnvFile{Path: ef.Path, Required: ef.Required == nil || *ef.Required})
+		}
+		return files, nil
+	default:
+		return nil, fmt.Errorf("expected string or list")
+	}
+}
+
+// parseEnvLines parses dotenv content into ordered key/value pairs, skipping
+// blank lines and comments and tolerating a leading "export ".
+func parseEnvLines(content string) []EnvVar {
+	var vars []EnvVar
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		vars = append(vars, EnvVar{Key: strings.TrimSpace(key), Value: val})
+	}
+	return vars
+}
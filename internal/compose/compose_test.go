@@ -0,0 +1,160 @@
+package compose_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/elabx-org/herald/internal/compose"
+)
+
+const testCompose = `
+services:
+  db:
+    image: postgres:16
+    environment:
+      POSTGRES_USER: app
+      POSTGRES_PASSWORD: op://Vault/db/password
+      PGDATA:
+  web:
+    image: myapp
+    env_file:
+      - web.env
+      - path: optional.env
+        required: false
+    environment:
+      - APP_URL=https://example.com
+      - DATABASE_URL=postgres://app:op://Vault/db/password@db:5432/app
+    x-herald:
+      environment:
+        SMTP_KEY: op://Vault/smtp/key
+  cache:
+    image: redis
+`
+
+func TestParseAndSecrets(t *testing.T) {
+	f, err := compose.Parse([]byte(testCompose))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got := f.ServiceNames(); strings.Join(got, ",") != "cache,db,web" {
+		t.Errorf("ServiceNames() = %v, want [cache db web]", got)
+	}
+
+	secrets, err := f.Secrets(map[string]string{
+		"web.env": "# comment\nAPI_TOKEN=op://Vault/api/token\nPLAIN=value\n",
+	})
+	if err != nil {
+		t.Fatalf("Secrets() error = %v", err)
+	}
+	if _, ok := secrets["cache"]; ok {
+		t.Errorf("cache has no refs and should be omitted")
+	}
+	if len(secrets["db"]) != 1 || secrets["db"][0].Key != "POSTGRES_PASSWORD" {
+		t.Errorf("db secrets = %+v, want [POSTGRES_PASSWORD]", secrets["db"])
+	}
+
+	var keys []string
+	for _, v := range secrets["web"] {
+		keys = append(keys, v.Key)
+	}
+	if strings.Join(keys, ",") != "API_TOKEN,DATABASE_URL,SMTP_KEY" {
+		t.Errorf("web secret keys = %v, want [API_TOKEN DATABASE_URL SMTP_KEY]", keys)
+	}
+
+	refs, byService, err := compose.Refs(secrets)
+	if err != nil {
+		t.Fatalf("Refs() error = %v", err)
+	}
+	if len(refs) != 3 {
+		t.Errorf("got %d refs, want 3 (deduplicated across services)", len(refs))
+	}
+	if len(byService["web"]) != 3 {
+		t.Errorf("web refs = %v, want 3", byService["web"])
+	}
+}
+
+func TestSecretsMissingRequiredEnvFile(t *testing.T) {
+	f, err := compose.Parse([]byte("services:\n  app:\n    env_file: app.env\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if _, err := f.Secrets(nil); err == nil {
+		t.Error("Secrets() error = nil, want error for missing required env_file")
+	}
+}
+
+func TestOverrideEscapesDollar(t *testing.T) {
+	secrets := map[string][]compose.EnvVar{
+		"db": {{Key: "POSTGRES_PASSWORD", Value: "op://Vault/db/password"}},
+	}
+	out, err := compose.Override(secrets, map[string]string{"op://Vault/db/password": "pa$word"})
+	if err != nil {
+		t.Fatalf("Override() error = %v", err)
+	}
+	if !strings.Contains(out, "POSTGRES_PASSWORD: pa$$word") {
+		t.Errorf("override missing escaped value, got:\n%s", out)
+	}
+	if strings.Contains(out, "op://") {
+		t.Errorf("override still contains op:// ref, got:\n%s", out)
+	}
+}
+
+func TestEnvFiles(t *testing.T) {
+	secrets := map[string][]compose.EnvVar{
+		"web": {
+			{Key: "DATABASE_URL", Value: "postgres://app:op://Vault/db/password@db/app"},
+			{Key: "SMTP_KEY", Value: "op://Vault/smtp/key"},
+		},
+	}
+	files := compose.EnvFiles(secrets, map[string]string{
+		"op://Vault/db/password": "s3cr3t",
+		"op://Vault/smtp/key":    "smtp",
+	})
+	want := "DATABASE_URL=postgres://app:s3cr3t@db/app\nSMTP_KEY=smtp\n"
+	if files["web"] != want {
+		t.Errorf("web env file = %q, want %q", files["web"], want)
+	}
+}
+
+func TestRefOptions(t *testing.T) {
+	content := `
+services:
+  db:
+    environment:
+      # herald: policy=none
+      POSTGRES_PASSWORD: op://Vault/db/password
+      POSTGRES_USER: op://Vault/db/user
+  web:
+    environment:
+      # herald: policy=tmpfs ttl=60
+      - API_KEY=op://Vault/api/key
+`
+	opts, err := compose.RefOptions([]byte(content), map[string]string{
+		"web.env": "# herald: policy=memory\nSMTP_KEY=op://Vault/smtp/key\n",
+	})
+	if err != nil {
+		t.Fatalf("RefOptions() error = %v", err)
+	}
+	want := map[string]string{
+		"op://Vault/db/password": "none",
+		"op://Vault/api/key":     "tmpfs",
+		"op://Vault/smtp/key":    "memory",
+	}
+	if len(opts) != len(want) {
+		t.Errorf("RefOptions() = %v, want %d annotated refs", opts, len(want))
+	}
+	for uri, policy := range want {
+		if opts[uri].Policy != policy {
+			t.Errorf("%s policy = %q, want %q", uri, opts[uri].Policy, policy)
+		}
+	}
+	if opts["op://Vault/api/key"].TTL != 60 {
+		t.Errorf("api key TTL = %d, want 60", opts["op://Vault/api/key"].TTL)
+	}
+
+	if _, err := compose.RefOptions([]byte(content), map[string]string{
+		"web.env": "# herald: policy=memory\nAPI_KEY=op://Vault/api/key\n",
+	}); err == nil {
+		t.Error("RefOptions() with conflicting annotations succeeded, want error")
+	}
+}
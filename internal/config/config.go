@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -10,9 +11,33 @@ import (
 type Config struct {
 	APIToken string `yaml:"-"` // from HERALD_API_TOKEN env
 
+	// JWT accepts signed workload tokens as bearer tokens. The stack claim
+	// binds each token to the stacks it may act on.
+	JWT struct {
+		JWKSFile        string   `yaml:"jwks_file"`
+		JWKSURL         string   `yaml:"jwks_url"`
+		Issuer          string   `yaml:"issuer"`           // required iss claim
+		Audience        string   `yaml:"audience"`         // required aud claim
+		StackClaim      string   `yaml:"stack_claim"`      // claim naming the token's stack (string or list)
+		Roles           []string `yaml:"roles"`            // roles granted to every verified token
+		RefreshInterval int      `yaml:"refresh_interval"` // seconds between JWKS re-reads
+		Leeway          int      `yaml:"leeway"`           // seconds of allowed clock skew
+	} `yaml:"jwt"`
+
 	Server struct {
 		Host string `yaml:"host"`
 		Port int    `yaml:"port"`
+
+		// TLS serves HTTPS when a certificate is set. Certificate files are
+		// re-read when they change, so renewals need no restart.
+		TLS struct {
+			CertFile          string      `yaml:"cert_file"`
+			KeyFile           string      `yaml:"key_file"`
+			ClientCAFile      string      `yaml:"client_ca_file"`      // enables mutual TLS
+			RequireClientCert bool        `yaml:"require_client_cert"` // refuse connections without a verified client certificate
+			ReloadInterval    int         `yaml:"reload_interval"`     // seconds between checks for changed files; 0 checks every handshake
+			Clients           []TLSClient `yaml:"clients"`             // identities for verified client certificates
+		} `yaml:"tls"`
 	} `yaml:"server"`
 
 	Providers []ProviderConfig `yaml:"providers"`
@@ -24,12 +49,82 @@ type Config struct {
 	} `yaml:"komodo"`
 
 	Cache struct {
-		DefaultPolicy string `yaml:"default_policy"`
-		DefaultTTL    int    `yaml:"default_ttl"`
-		EncryptionKey string `yaml:"encryption_key"`
-		DataPath      string `yaml:"data_path"`
+		DefaultPolicy string   `yaml:"default_policy"`
+		DefaultTTL    int      `yaml:"default_ttl"`
+		EncryptionKey string   `yaml:"encryption_key"`
+		PreviousKeys  []string `yaml:"previous_keys"` // passphrases of older key versions, still readable
+		DataPath      string   `yaml:"data_path"`
+		TmpfsPath     string   `yaml:"tmpfs_path"` // RAM-backed dir for the tmpfs policy
+		FilePath      string   `yaml:"file_path"`  // persistent dir for the file policy
+
+		// KEK wraps the cache data key (envelope encryption) instead of
+		// deriving it from EncryptionKey.
+		KEK struct {
+			Type   string `yaml:"type"`   // file, pkcs11 or 1password; empty uses encryption_key
+			File   string `yaml:"file"`   // 32-byte key, raw, hex or base64
+			OpRef  string `yaml:"op_ref"` // op:// ref resolved through the providers at startup
+			PKCS11 struct {
+				Module     string `yaml:"module"`
+				TokenLabel string `yaml:"token_label"`
+				KeyLabel   string `yaml:"key_label"`
+				PIN        string `yaml:"pin"`
+			} `yaml:"pkcs11"`
+		} `yaml:"kek"`
+		AllowDegraded bool `yaml:"allow_degraded"` // run memory-only instead of exiting when the KEK is unavailable
+
+		MemoryMaxEntries int   `yaml:"memory_max_entries"` // LRU bound on memory-policy entries
+		MemoryMaxBytes   int64 `yaml:"memory_max_bytes"`   // LRU bound on approximate memory use
+		SweepInterval    int   `yaml:"sweep_interval"`     // seconds between expired-entry sweeps
+		RetainExpired    int   `yaml:"retain_expired"`     // seconds expired entries are kept for stale serving
+
+		// RefreshAhead re-resolves cached secrets of indexed stacks shortly
+		// before they expire.
+		RefreshAhead struct {
+			Enabled      bool    `yaml:"enabled"`
+			Window       float64 `yaml:"window"`         // refresh once this fraction of the TTL remains
+			Interval     int     `yaml:"interval"`       // seconds between refresh passes
+			MaxPerMinute int     `yaml:"max_per_minute"` // cap on refresh provider calls; background resolvers share the smallest enabled cap
+			UnusedDays   int     `yaml:"unused_days"`    // skip refs no stack synced for this long; 0 = never skip
+		} `yaml:"refresh_ahead"`
+
+		// WarmUp pre-resolves the refs of indexed stacks at startup; readiness
+		// waits for it.
+		WarmUp struct {
+			Enabled      bool `yaml:"enabled"`
+			MaxPerMinute int  `yaml:"max_per_minute"` // cap on warm-up provider calls
+		} `yaml:"warm_up"`
+
+		// Stale controls serving expired entries when a provider lookup fails.
+		Stale struct {
+			Mode        string         `yaml:"mode"`          // rate_limited (default), unavailable or never
+			MaxAge      int            `yaml:"max_age"`       // seconds past expiry; 0 = unbounded
+			VaultMaxAge map[string]int `yaml:"vault_max_age"` // per-vault override of max_age
+			RefMaxAge   map[string]int `yaml:"ref_max_age"`   // per op:// ref override of max_age
+		} `yaml:"stale"`
 	} `yaml:"cache"`
 
+	// ChangeDetection polls the providers for secrets of indexed stacks that
+	// changed and rotates the affected items.
+	ChangeDetection struct {
+		Enabled      bool     `yaml:"enabled"`
+		Interval     int      `yaml:"interval"`       // seconds between polls
+		Vaults       []string `yaml:"vaults"`         // vaults to watch; "*" watches all
+		Redeploy     bool     `yaml:"redeploy"`       // redeploy dependent stacks; false only invalidates the cache
+		MaxPerMinute int      `yaml:"max_per_minute"` // cap on poll provider calls
+	} `yaml:"change_detection"`
+
+	Materialize struct {
+		Concurrency     int                 `yaml:"concurrency"`       // max refs resolved in parallel per request
+		OutputDirs      []string            `yaml:"output_dirs"`       // directories any stack may write out_path/secrets_dir under
+		StackOutputDirs map[string][]string `yaml:"stack_output_dirs"` // extra directories per stack
+		AccessPolicy    string              `yaml:"access_policy"`     // file listing the vaults/items each stack may reference
+	} `yaml:"materialize"`
+
+	// Events buffers recent activity for GET /v1/events resumption.
+	Events struct {
+		BufferSize int `yaml:"buffer_size"` // events kept for Last-Event-ID replay
+	} `yaml:"events"`
+
 	Audit struct {
 		Enabled       bool   `yaml:"enabled"`
 		Path          string `yaml:"path"`
@@ -41,6 +136,16 @@ type Config struct {
 	} `yaml:"alerts"`
 }
 
+// TLSClient maps verified client certificates to an identity with the same
+// roles and scopes as a named API token.
+type TLSClient struct {
+	Name    string   `yaml:"name"`    // recorded in audit entries
+	Subject string   `yaml:"subject"` // glob over the certificate's common name and DNS, URI and email SANs
+	Roles   []string `yaml:"roles"`
+	Stacks  []string `yaml:"stacks"` // globs; empty allows every stack
+	Vaults  []string `yaml:"vaults"` // globs; empty allows every vault
+}
+
 type ProviderConfig struct {
 	Name     string `yaml:"name"`
 	Type     string `yaml:"type"`
@@ -55,9 +160,29 @@ func Load(path string) (*Config, error) {
 	// Defaults
 	cfg.Server.Host = "0.0.0.0"
 	cfg.Server.Port = 8765
+	cfg.Server.TLS.ReloadInterval = 60
+	cfg.JWT.StackClaim = "stack"
+	cfg.JWT.Roles = []string{"materialize"}
+	cfg.JWT.RefreshInterval = 3600
+	cfg.JWT.Leeway = 60
 	cfg.Cache.DefaultPolicy = "memory"
 	cfg.Cache.DefaultTTL = 300
 	cfg.Cache.DataPath = "/data/cache.db"
+	cfg.Cache.TmpfsPath = "/dev/shm/herald"
+	cfg.Cache.MemoryMaxEntries = 10000
+	cfg.Cache.MemoryMaxBytes = 64 << 20
+	cfg.Cache.SweepInterval = 60
+	cfg.Cache.RetainExpired = 86400
+	cfg.Cache.RefreshAhead.Window = 0.2
+	cfg.Cache.RefreshAhead.Interval = 60
+	cfg.Cache.RefreshAhead.MaxPerMinute = 30
+	cfg.Cache.RefreshAhead.UnusedDays = 7
+	cfg.Cache.WarmUp.MaxPerMinute = 60
+	cfg.ChangeDetection.Interval = 300
+	cfg.ChangeDetection.Redeploy = true
+	cfg.ChangeDetection.MaxPerMinute = 30
+	cfg.Materialize.Concurrency = 8
+	cfg.Events.BufferSize = 1000
 	cfg.Audit.RetentionDays = 30
 	cfg.Alerts.TokenExpiryWarningDays = 7
 
@@ -75,6 +200,30 @@ func Load(path string) (*Config, error) {
 	if v := os.Getenv("HERALD_API_TOKEN"); v != "" {
 		cfg.APIToken = v
 	}
+	if v := os.Getenv("HERALD_JWT_JWKS_FILE"); v != "" {
+		cfg.JWT.JWKSFile = v
+	}
+	if v := os.Getenv("HERALD_JWT_JWKS_URL"); v != "" {
+		cfg.JWT.JWKSURL = v
+	}
+	if v := os.Getenv("HERALD_JWT_ISSUER"); v != "" {
+		cfg.JWT.Issuer = v
+	}
+	if v := os.Getenv("HERALD_JWT_AUDIENCE"); v != "" {
+		cfg.JWT.Audience = v
+	}
+	if v := os.Getenv("HERALD_TLS_CERT_FILE"); v != "" {
+		cfg.Server.TLS.CertFile = v
+	}
+	if v := os.Getenv("HERALD_TLS_KEY_FILE"); v != "" {
+		cfg.Server.TLS.KeyFile = v
+	}
+	if v := os.Getenv("HERALD_TLS_CLIENT_CA_FILE"); v != "" {
+		cfg.Server.TLS.ClientCAFile = v
+	}
+	if v := os.Getenv("HERALD_TLS_REQUIRE_CLIENT_CERT"); v != "" {
+		cfg.Server.TLS.RequireClientCert = v == "true" || v == "1"
+	}
 	if v := os.Getenv("OP_CONNECT_TOKEN"); v != "" {
 		for i := range cfg.Providers {
 			if cfg.Providers[i].Type == "connect_server" {
@@ -132,9 +281,126 @@ func Load(path string) (*Config, error) {
 	if v := os.Getenv("HERALD_CACHE_KEY"); v != "" {
 		cfg.Cache.EncryptionKey = v
 	}
+	if v := os.Getenv("HERALD_CACHE_PREVIOUS_KEYS"); v != "" {
+		cfg.Cache.PreviousKeys = nil
+		for _, key := range strings.Split(v, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				cfg.Cache.PreviousKeys = append(cfg.Cache.PreviousKeys, key)
+			}
+		}
+	}
+	if v := os.Getenv("HERALD_CACHE_KEK_TYPE"); v != "" {
+		cfg.Cache.KEK.Type = v
+	}
+	if v := os.Getenv("HERALD_CACHE_KEK_FILE"); v != "" {
+		cfg.Cache.KEK.File = v
+	}
+	if v := os.Getenv("HERALD_CACHE_KEK_OP_REF"); v != "" {
+		cfg.Cache.KEK.OpRef = v
+	}
+	if v := os.Getenv("HERALD_CACHE_KEK_PKCS11_MODULE"); v != "" {
+		cfg.Cache.KEK.PKCS11.Module = v
+	}
+	if v := os.Getenv("HERALD_CACHE_KEK_PKCS11_TOKEN_LABEL"); v != "" {
+		cfg.Cache.KEK.PKCS11.TokenLabel = v
+	}
+	if v := os.Getenv("HERALD_CACHE_KEK_PKCS11_KEY_LABEL"); v != "" {
+		cfg.Cache.KEK.PKCS11.KeyLabel = v
+	}
+	if v := os.Getenv("HERALD_CACHE_KEK_PKCS11_PIN"); v != "" {
+		cfg.Cache.KEK.PKCS11.PIN = v
+	}
+	if v := os.Getenv("HERALD_CACHE_ALLOW_DEGRADED"); v != "" {
+		cfg.Cache.AllowDegraded = v == "true" || v == "1"
+	}
 	if v := os.Getenv("HERALD_CACHE_DATA_PATH"); v != "" {
 		cfg.Cache.DataPath = v
 	}
+	if v := os.Getenv("HERALD_CACHE_TMPFS_PATH"); v != "" {
+		cfg.Cache.TmpfsPath = v
+	}
+	if v := os.Getenv("HERALD_CACHE_FILE_PATH"); v != "" {
+		cfg.Cache.FilePath = v
+	}
+	if v := os.Getenv("HERALD_CACHE_MEMORY_MAX_ENTRIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.Cache.MemoryMaxEntries = n
+		}
+	}
+	if v := os.Getenv("HERALD_CACHE_MEMORY_MAX_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			cfg.Cache.MemoryMaxBytes = n
+		}
+	}
+	if v := os.Getenv("HERALD_CACHE_RETAIN_EXPIRED"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.Cache.RetainExpired = n
+		}
+	}
+	if v := os.Getenv("HERALD_CACHE_REFRESH_AHEAD"); v != "" {
+		cfg.Cache.RefreshAhead.Enabled = v == "true" || v == "1"
+	}
+	if v := os.Getenv("HERALD_CACHE_REFRESH_MAX_PER_MINUTE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.Cache.RefreshAhead.MaxPerMinute = n
+		}
+	}
+	if v := os.Getenv("HERALD_CACHE_WARM_UP"); v != "" {
+		cfg.Cache.WarmUp.Enabled = v == "true" || v == "1"
+	}
+	if v := os.Getenv("HERALD_CACHE_WARM_UP_MAX_PER_MINUTE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.Cache.WarmUp.MaxPerMinute = n
+		}
+	}
+	if v := os.Getenv("HERALD_CACHE_STALE_MODE"); v != "" {
+		cfg.Cache.Stale.Mode = v
+	}
+	if v := os.Getenv("HERALD_CACHE_STALE_MAX_AGE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.Cache.Stale.MaxAge = n
+		}
+	}
+	if v := os.Getenv("HERALD_CHANGE_DETECTION"); v != "" {
+		cfg.ChangeDetection.Enabled = v == "true" || v == "1"
+	}
+	if v := os.Getenv("HERALD_CHANGE_DETECTION_INTERVAL"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.ChangeDetection.Interval = n
+		}
+	}
+	if v := os.Getenv("HERALD_CHANGE_DETECTION_VAULTS"); v != "" {
+		cfg.ChangeDetection.Vaults = nil
+		for _, vault := range strings.Split(v, ",") {
+			if vault = strings.TrimSpace(vault); vault != "" {
+				cfg.ChangeDetection.Vaults = append(cfg.ChangeDetection.Vaults, vault)
+			}
+		}
+	}
+	if v := os.Getenv("HERALD_CHANGE_DETECTION_REDEPLOY"); v != "" {
+		cfg.ChangeDetection.Redeploy = v == "true" || v == "1"
+	}
+	if v := os.Getenv("HERALD_ACCESS_POLICY"); v != "" {
+		cfg.Materialize.AccessPolicy = v
+	}
+	if v := os.Getenv("HERALD_EVENTS_BUFFER_SIZE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.Events.BufferSize = n
+		}
+	}
+	if v := os.Getenv("HERALD_MATERIALIZE_CONCURRENCY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.Materialize.Concurrency = n
+		}
+	}
+	if v := os.Getenv("HERALD_MATERIALIZE_OUTPUT_DIRS"); v != "" {
+		cfg.Materialize.OutputDirs = nil
+		for _, dir := range strings.Split(v, ",") {
+			if dir = strings.TrimSpace(dir); dir != "" {
+				cfg.Materialize.OutputDirs = append(cfg.Materialize.OutputDirs, dir)
+			}
+		}
+	}
 	if v := os.Getenv("HERALD_AUDIT_ENABLED"); v != "" {
 		cfg.Audit.Enabled = v == "true" || v == "1"
 	}
@@ -0,0 +1,242 @@
+// Package format renders resolved env variables in the formats Herald can
+// deliver: dotenv, JSON, YAML, shell exports, systemd EnvironmentFile, a
+// Kubernetes Secret manifest and Docker --env-file.
+package format
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/elabx-org/herald/internal/resolver"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported formats.
+const (
+	Env     = "env"     // dotenv, as written by ResolveEnvContent (default)
+	JSON    = "json"    // {"KEY": "value", ...}
+	YAML    = "yaml"    // KEY: value mapping
+	Shell   = "shell"   // export KEY='value'
+	Systemd = "systemd" // EnvironmentFile= compatible
+	K8s     = "k8s"     // v1 Secret manifest
+	Docker  = "docker"  // docker run --env-file compatible
+)
+
+// Formats lists every supported format name.
+var Formats = []string{Env, JSON, YAML, Shell, Systemd, K8s, Docker}
+
+var (
+	envKeyRegex   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	k8sNameRegex  = regexp.MustCompile(`[^a-z0-9.-]+`)
+	k8sTrimRegexp = regexp.MustCompile(`^[.-]+|[.-]+$`)
+)
+
+// Valid reports whether f is a supported format name.
+func Valid(f string) bool {
+	for _, name := range Formats {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Var is a single resolved variable.
+type Var struct {
+	Key   string
+	Value string
+}
+
+// Vars parses dotenv content into ordered variables and substitutes op://
+// refs from resolvedByURI. Values are unquoted before substitution so a
+// resolved secret containing quotes or backslashes is never mis-parsed.
+// Variables whose refs are missing from resolvedByURI are omitted; comments,
+// blank lines and lines without "=" are skipped. Later duplicates win.
+func Vars(content string, resolvedByURI map[string]string) []Var {
+	index := make(map[string]int)
+	var vars []Var
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(strings.TrimPrefix(key, "export "))
+		value = resolver.ResolveValue(Unquote(strings.TrimSpace(value)), resolvedByURI)
+		if resolver.HasRef(value) {
+			continue
+		}
+		if i, seen := index[key]; seen {
+			vars[i].Value = value
+			continue
+		}
+		index[key] = len(vars)
+		vars = append(vars, Var{Key: key, Value: value})
+	}
+	return vars
+}
+
+// Unquote strips dotenv quoting: single quotes are literal, double quotes
+// support \n, \", \\ escapes. Unquoted values are returned unchanged.
+func Unquote(s string) string {
+	if len(s) < 2 {
+		return s
+	}
+	switch {
+	case s[0] == '\'' && s[len(s)-1] == '\'':
+		return s[1 : len(s)-1]
+	case s[0] == '"' && s[len(s)-1] == '"':
+		r := strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`)
+		return r.Replace(s[1 : len(s)-1])
+	}
+	return s
+}
+
+// Render formats vars as f. name is used where the target needs an object
+// name (the Kubernetes Secret's metadata.name) and is typically the stack.
+// The Env format is not handled here; callers keep the original line-preserving
+// dotenv output for it.
+func Render(f, name string, vars []Var) (string, error) {
+	for _, v := range vars {
+		if !envKeyRegex.MatchString(v.Key) {
+			return "", fmt.Errorf("key %q is not a valid variable name for format %s", v.Key, f)
+		}
+	}
+	switch f {
+	case JSON:
+		return renderJSON(vars)
+	case YAML:
+		return renderYAML(vars)
+	case Shell:
+		return renderShell(vars), nil
+	case Systemd:
+		return renderSystemd(vars), nil
+	case K8s:
+		return renderK8s(name, vars)
+	case Docker:
+		return renderDocker(vars)
+	default:
+		return "", fmt.Errorf("unsupported format %q", f)
+	}
+}
+
+func toMap(vars []Var) map[string]string {
+	m := make(map[string]string, len(vars))
+	for _, v := range vars {
+		m[v.Key] = v.Value
+	}
+	return m
+}
+
+func renderJSON(vars []Var) (string, error) {
+	data, err := json.MarshalIndent(toMap(vars), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+// renderYAML builds the mapping node by hand to keep file order and force
+// every value to a string scalar (so "true" or "0123" stay strings).
+func renderYAML(vars []Var) (string, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, v := range vars {
+		node.Content = append(node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v.Key},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v.Value, Style: yaml.DoubleQuotedStyle},
+		)
+	}
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// renderShell emits POSIX export statements. Values are single-quoted, which
+// disables all expansion; an embedded single quote closes the quoting, emits
+// an escaped quote and reopens it.
+func renderShell(vars []Var) string {
+	var sb strings.Builder
+	for _, v := range vars {
+		sb.WriteString("export " + v.Key + "='" + strings.ReplaceAll(v.Value, "'", `'\''`) + "'\n")
+	}
+	return sb.String()
+}
+
+// renderSystemd emits an EnvironmentFile. Values are double-quoted with
+// backslash, double quote, dollar and backtick escaped; systemd keeps literal
+// newlines inside quotes.
+func renderSystemd(vars []Var) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "`", "\\`")
+	var sb strings.Builder
+	for _, v := range vars {
+		sb.WriteString(v.Key + `="` + r.Replace(v.Value) + "\"\n")
+	}
+	return sb.String()
+}
+
+// renderDocker emits a docker --env-file. Docker takes everything after the
+// first "=" literally (no quoting or escapes), so values with newlines cannot
+// be represented and are rejected.
+func renderDocker(vars []Var) (string, error) {
+	var sb strings.Builder
+	for _, v := range vars {
+		if strings.ContainsAny(v.Value, "\r\n") {
+			return "", fmt.Errorf("value of %s contains a newline, which docker --env-file cannot represent", v.Key)
+		}
+		sb.WriteString(v.Key + "=" + v.Value + "\n")
+	}
+	return sb.String(), nil
+}
+
+type k8sSecret struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   k8sMetadata       `yaml:"metadata"`
+	Type       string            `yaml:"type"`
+	Data       map[string]string `yaml:"data"`
+}
+
+type k8sMetadata struct {
+	Name   string            `yaml:"name"`
+	Labels map[string]string `yaml:"labels"`
+}
+
+// renderK8s emits an Opaque v1 Secret with base64-encoded data. name is
+// lowercased and stripped of characters not allowed in a DNS subdomain.
+func renderK8s(name string, vars []Var) (string, error) {
+	secretName := k8sTrimRegexp.ReplaceAllString(k8sNameRegex.ReplaceAllString(strings.ToLower(name), "-"), "")
+	if secretName == "" {
+		return "", fmt.Errorf("stack name %q cannot be used as a Kubernetes Secret name", name)
+	}
+	if len(secretName) > 253 {
+		secretName = k8sTrimRegexp.ReplaceAllString(secretName[:253], "")
+	}
+	data := make(map[string]string, len(vars))
+	for _, v := range vars {
+		data[v.Key] = base64.StdEncoding.EncodeToString([]byte(v.Value))
+	}
+	out, err := yaml.Marshal(k8sSecret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata: k8sMetadata{
+			Name:   secretName,
+			Labels: map[string]string{"app.kubernetes.io/managed-by": "herald"},
+		},
+		Type: "Opaque",
+		Data: data,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
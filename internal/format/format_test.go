@@ -0,0 +1,123 @@
+package format_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/elabx-org/herald/internal/format"
+	"gopkg.in/yaml.v3"
+)
+
+// tricky holds a value that breaks naive quoting in every target.
+const tricky = "p'a\"s$s`w\\d"
+
+func TestVars(t *testing.T) {
+	content := "# comment\nAPP=plain\nexport DB=\"op://V/db/pw\"\nLIT='$HOME'\nMISSING=op://V/x/y\nAPP=override\n"
+	vars := format.Vars(content, map[string]string{"op://V/db/pw": tricky})
+
+	want := []format.Var{{"APP", "override"}, {"DB", tricky}, {"LIT", "$HOME"}}
+	if len(vars) != len(want) {
+		t.Fatalf("Vars() = %v, want %v", vars, want)
+	}
+	for i := range want {
+		if vars[i] != want[i] {
+			t.Errorf("Vars()[%d] = %v, want %v", i, vars[i], want[i])
+		}
+	}
+}
+
+func TestRenderJSONAndYAML(t *testing.T) {
+	vars := []format.Var{{"A", tricky}, {"B", "true"}}
+
+	out, err := format.Render(format.JSON, "app", vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]string
+	if err := json.Unmarshal([]byte(out), &m); err != nil {
+		t.Fatalf("json output does not parse: %v", err)
+	}
+	if m["A"] != tricky || m["B"] != "true" {
+		t.Errorf("json round-trip = %v", m)
+	}
+
+	out, err = format.Render(format.YAML, "app", vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var y map[string]interface{}
+	if err := yaml.Unmarshal([]byte(out), &y); err != nil {
+		t.Fatalf("yaml output does not parse: %v", err)
+	}
+	if y["A"] != tricky || y["B"] != "true" {
+		t.Errorf("yaml round-trip = %v (B must stay a string)", y)
+	}
+}
+
+func TestRenderShellAndSystemd(t *testing.T) {
+	vars := []format.Var{{"A", tricky}}
+
+	out, err := format.Render(format.Shell, "app", vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `export A='p'\''a"s$s` + "`" + `w\d'` + "\n"; out != want {
+		t.Errorf("shell = %q, want %q", out, want)
+	}
+
+	out, err = format.Render(format.Systemd, "app", vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `A="p'a\"s\$s\` + "`" + `w\\d"` + "\n"; out != want {
+		t.Errorf("systemd = %q, want %q", out, want)
+	}
+}
+
+func TestRenderDocker(t *testing.T) {
+	out, err := format.Render(format.Docker, "app", []format.Var{{"A", tricky}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "A="+tricky+"\n" {
+		t.Errorf("docker = %q", out)
+	}
+	if _, err := format.Render(format.Docker, "app", []format.Var{{"KEY", "line1\nline2"}}); err == nil {
+		t.Error("docker with multi-line value: error = nil, want error")
+	}
+}
+
+func TestRenderK8s(t *testing.T) {
+	out, err := format.Render(format.K8s, "My_App", []format.Var{{"A", tricky}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var secret struct {
+		Kind     string `yaml:"kind"`
+		Metadata struct {
+			Name string `yaml:"name"`
+		} `yaml:"metadata"`
+		Data map[string]string `yaml:"data"`
+	}
+	if err := yaml.Unmarshal([]byte(out), &secret); err != nil {
+		t.Fatalf("k8s output does not parse: %v", err)
+	}
+	if secret.Kind != "Secret" || secret.Metadata.Name != "my-app" {
+		t.Errorf("kind/name = %s/%s, want Secret/my-app", secret.Kind, secret.Metadata.Name)
+	}
+	decoded, _ := base64.StdEncoding.DecodeString(secret.Data["A"])
+	if string(decoded) != tricky {
+		t.Errorf("data.A decodes to %q, want %q", decoded, tricky)
+	}
+}
+
+func TestRenderRejectsInvalidKey(t *testing.T) {
+	if _, err := format.Render(format.Shell, "app", []format.Var{{"BAD KEY;rm", "x"}}); err == nil {
+		t.Error("Render() with invalid key: error = nil, want error")
+	}
+	if !format.Valid(format.K8s) || format.Valid("xml") || !strings.Contains(strings.Join(format.Formats, ","), "systemd") {
+		t.Error("Valid()/Formats mismatch")
+	}
+}
@@ -0,0 +1,128 @@
+// Package fsutil provides the crash-safe, confined file writes Herald uses
+// when delivering secrets to disk.
+package fsutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrOutsideAllowed is returned by Confine when a path does not resolve to a
+// location under any allowed directory.
+var ErrOutsideAllowed = errors.New("path is outside the allowed output directories")
+
+// WriteFileAtomic writes data to path so that readers see either the old
+// file or the complete new one, never a partial write: the data goes to a
+// temp file in the same directory, is fsynced, given perm (and uid/gid when
+// either is >= 0), and renamed over path. The directory is fsynced so the
+// rename survives a crash. If path is a symlink, the link itself is replaced.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode, uid, gid int) (err error) {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	// CreateTemp uses 0600, so the content is never exposed before chmod.
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if uid >= 0 || gid >= 0 {
+		if err = tmp.Chown(uid, gid); err != nil {
+			return err
+		}
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Confine resolves path (which must be absolute) with every symlink in its
+// existing ancestors followed, and returns the resolved path if it lies
+// within one of allowed (also resolved). Components that do not exist yet
+// are appended as-is, so a directory that will be created is accepted as long
+// as its nearest existing ancestor is inside the allowlist. The final
+// component is not followed, since WriteFileAtomic replaces rather than
+// follows a symlink there.
+func Confine(path string, allowed []string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("%q must be an absolute path", path)
+	}
+	if len(allowed) == 0 {
+		return "", fmt.Errorf("%w: no output directories are configured", ErrOutsideAllowed)
+	}
+	path = filepath.Clean(path)
+	dir, err := resolveExisting(filepath.Dir(path))
+	if err != nil {
+		return "", err
+	}
+	resolved := filepath.Join(dir, filepath.Base(path))
+
+	for _, a := range allowed {
+		root, err := resolveExisting(filepath.Clean(a))
+		if err != nil {
+			continue
+		}
+		if within(resolved, root) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrOutsideAllowed, path)
+}
+
+// resolveExisting follows symlinks in the longest existing prefix of path and
+// re-appends the missing tail.
+func resolveExisting(path string) (string, error) {
+	var tail []string
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			for i := len(tail) - 1; i >= 0; i-- {
+				resolved = filepath.Join(resolved, tail[i])
+			}
+			return resolved, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+		tail = append(tail, filepath.Base(path))
+		path = parent
+	}
+}
+
+func within(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,91 @@
+package fsutil_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elabx-org/herald/internal/fsutil"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.env")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fsutil.WriteFileAtomic(path, []byte("new"), 0640, -1, -1); err != nil {
+		t.Fatalf("WriteFileAtomic() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" {
+		t.Errorf("content = %q, want new", data)
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("mode = %o, want 0640", info.Mode().Perm())
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("dir has %d entries, want 1 (temp file left behind?)", len(entries))
+	}
+}
+
+func TestWriteFileAtomicReplacesSymlink(t *testing.T) {
+	dir := t.TempDir()
+	victim := filepath.Join(dir, "victim")
+	os.WriteFile(victim, []byte("keep"), 0600)
+	link := filepath.Join(dir, "out.env")
+	if err := os.Symlink(victim, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fsutil.WriteFileAtomic(link, []byte("secret"), 0600, -1, -1); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(victim); string(data) != "keep" {
+		t.Errorf("symlink target was overwritten: %q", data)
+	}
+}
+
+func TestConfine(t *testing.T) {
+	base := t.TempDir()
+	allowed := filepath.Join(base, "allowed")
+	outside := filepath.Join(base, "outside")
+	os.Mkdir(allowed, 0755)
+	os.Mkdir(outside, 0755)
+	if err := os.Symlink(outside, filepath.Join(allowed, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{filepath.Join(allowed, "app.env"), true},
+		{filepath.Join(allowed, "new/sub/dir"), true},
+		{filepath.Join(allowed, "../outside/app.env"), false},
+		{filepath.Join(outside, "app.env"), false},
+		{filepath.Join(allowed, "escape/app.env"), false},
+		{filepath.Join(allowed, "escape/new/app.env"), false},
+		{"relative/app.env", false},
+	}
+	for _, tt := range tests {
+		got, err := fsutil.Confine(tt.path, []string{allowed})
+		if tt.ok && err != nil {
+			t.Errorf("Confine(%s) error = %v, want allowed", tt.path, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("Confine(%s) = %s, want rejection", tt.path, got)
+		}
+	}
+
+	if _, err := fsutil.Confine(filepath.Join(allowed, "app.env"), nil); !errors.Is(err, fsutil.ErrOutsideAllowed) {
+		t.Errorf("Confine() with empty allowlist error = %v, want ErrOutsideAllowed", err)
+	}
+}
@@ -0,0 +1,231 @@
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// minRefetch bounds how often an unknown key ID can trigger a JWKS refetch.
+const minRefetch = time.Minute
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// KeySet holds the public keys of a JWKS document read from a file or URL.
+// The document is re-read every refresh interval, and sooner (at most once a
+// minute) when a token names an unknown key ID, so issuer key rotation needs
+// no restart.
+type KeySet struct {
+	file, url string
+	refresh   time.Duration
+	client    *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	inflight  chan struct{} // closed when the running refetch finishes
+}
+
+// NewKeySet loads the JWKS at file or url (exactly one must be set). An
+// unreachable URL is not fatal: the first verification retries the fetch.
+func NewKeySet(file, url string, refresh time.Duration) (*KeySet, error) {
+	if (file == "") == (url == "") {
+		return nil, errors.New("jwt: set exactly one of jwks_file and jwks_url")
+	}
+	ks := &KeySet{file: file, url: url, refresh: refresh, client: &http.Client{Timeout: 10 * time.Second}}
+	keys, err := ks.load()
+	if err != nil {
+		if file != "" {
+			return nil, err
+		}
+		// fetchedAt stays zero, so the first verification refetches.
+		log.Warn().Err(err).Str("url", url).Msg("jwt: initial JWKS fetch failed — retrying on first use")
+		return ks, nil
+	}
+	ks.keys, ks.fetchedAt = keys, time.Now()
+	return ks, nil
+}
+
+// key returns the public key for kid. An empty kid matches the only key of a
+// single-key set. The JWKS is refetched without holding mu, so a slow issuer
+// does not stall verifications of known keys; callers missing a key while a
+// refetch is running wait for it rather than starting their own.
+func (ks *KeySet) key(kid string) (crypto.PublicKey, error) {
+	ks.mu.Lock()
+	done := ks.inflight
+	since := time.Since(ks.fetchedAt)
+	switch {
+	case done == nil && (since >= ks.refresh || (ks.lookup(kid) == nil && since >= minRefetch)):
+		done = make(chan struct{})
+		ks.inflight = done
+		ks.fetchedAt = time.Now()
+		ks.mu.Unlock()
+		keys, err := ks.load()
+		ks.mu.Lock()
+		if err != nil {
+			log.Warn().Err(err).Msg("jwt: JWKS refresh failed — keeping the previous keys")
+		} else {
+			ks.keys = keys
+		}
+		ks.inflight = nil
+		close(done)
+	case done != nil && ks.lookup(kid) == nil:
+		ks.mu.Unlock()
+		<-done
+		ks.mu.Lock()
+	}
+	k := ks.lookup(kid)
+	ks.mu.Unlock()
+	if k != nil {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+func (ks *KeySet) lookup(kid string) crypto.PublicKey {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, k := range ks.keys {
+			return k
+		}
+	}
+	return ks.keys[kid]
+}
+
+// load reads and parses the JWKS document. It touches no KeySet state, so
+// callers run it without holding mu.
+func (ks *KeySet) load() (map[string]crypto.PublicKey, error) {
+	var data []byte
+	var err error
+	if ks.file != "" {
+		data, err = os.ReadFile(ks.file)
+	} else {
+		data, err = ks.get()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jwt: read JWKS: %w", err)
+	}
+	return parseJWKS(data)
+}
+
+func (ks *KeySet) get() ([]byte, error) {
+	resp, err := ks.client.Get(ks.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned HTTP %d", ks.url, resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// parseJWKS decodes the signing keys of a JWKS document. Keys of unsupported
+// types are skipped.
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("jwt: parse JWKS: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwt: JWKS key %q: %w", k.Kid, err)
+		}
+		if pub != nil {
+			keys[k.Kid] = pub
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwt: JWKS has no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, nil
+	}
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
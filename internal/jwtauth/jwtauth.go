@@ -0,0 +1,206 @@
+// Package jwtauth verifies signed JWTs against a JWKS, so workloads can
+// authenticate with short-lived, stack-bound identities instead of a shared
+// bearer token.
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Claims are the decoded claims of a verified token.
+type Claims map[string]interface{}
+
+// String returns the string claim name, or "" when absent or not a string.
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns claim name as a list; a single string becomes a
+// one-element list.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var out []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func (c Claims) time(name string) (time.Time, bool) {
+	f, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// Verifier checks a token's signature, lifetime, issuer and audience.
+type Verifier struct {
+	keys     *KeySet
+	issuer   string
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+// NewVerifier returns a verifier for tokens signed by keys. Empty issuer or
+// audience skips that check; leeway allows for clock skew.
+func NewVerifier(keys *KeySet, issuer, audience string, leeway time.Duration) *Verifier {
+	return &Verifier{keys: keys, issuer: issuer, audience: audience, leeway: leeway, now: time.Now}
+}
+
+// LooksLikeJWT reports whether token has the three-part compact JWS shape.
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// Verify validates token and returns its claims. Tokens must carry exp.
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	key, err := v.keys.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("signature: invalid encoding")
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("claims: %w", err)
+	}
+	now := v.now()
+	exp, ok := claims.time("exp")
+	if !ok {
+		return nil, errors.New("token has no exp claim")
+	}
+	if now.After(exp.Add(v.leeway)) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims.time("nbf"); ok && now.Add(v.leeway).Before(nbf) {
+		return nil, errors.New("token not valid yet")
+	}
+	if v.issuer != "" && claims.String("iss") != v.issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", claims.String("iss"))
+	}
+	if v.audience != "" && !slices.Contains(claims.Strings("aud"), v.audience) {
+		return nil, errors.New("token not issued for this audience")
+	}
+	return claims, nil
+}
+
+func decodeSegment(seg string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return errors.New("invalid encoding")
+	}
+	return json.Unmarshal(data, out)
+}
+
+// verifySignature checks sig over signed for alg. The key type must match the
+// algorithm, so an attacker cannot pick a weaker verification path.
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	bad := errors.New("invalid signature")
+	switch alg {
+	case "RS256", "RS384", "RS512", "PS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key does not match alg %s", alg)
+		}
+		h, digest := rsaHash(alg, signed)
+		if alg == "PS256" {
+			if rsa.VerifyPSS(pub, h, digest, sig, nil) != nil {
+				return bad
+			}
+			return nil
+		}
+		if rsa.VerifyPKCS1v15(pub, h, digest, sig) != nil {
+			return bad
+		}
+		return nil
+	case "ES256", "ES384":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key does not match alg %s", alg)
+		}
+		size := 32
+		var digest []byte
+		if alg == "ES256" {
+			sum := sha256.Sum256(signed)
+			digest = sum[:]
+		} else {
+			size = 48
+			sum := sha512.Sum384(signed)
+			digest = sum[:]
+		}
+		if pub.Curve.Params().BitSize != size*8 || len(sig) != 2*size {
+			return bad
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return bad
+		}
+		return nil
+	case "EdDSA":
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("key does not match alg %s", alg)
+		}
+		if !ed25519.Verify(pub, signed, sig) {
+			return bad
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+}
+
+func rsaHash(alg string, signed []byte) (crypto.Hash, []byte) {
+	switch alg {
+	case "RS384":
+		sum := sha512.Sum384(signed)
+		return crypto.SHA384, sum[:]
+	case "RS512":
+		sum := sha512.Sum512(signed)
+		return crypto.SHA512, sum[:]
+	default:
+		sum := sha256.Sum256(signed)
+		return crypto.SHA256, sum[:]
+	}
+}
@@ -0,0 +1,215 @@
+package jwtauth_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elabx-org/herald/internal/jwtauth"
+)
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// sign builds a compact JWS over claims with an RSA (RS256) or EC (ES256) key.
+func sign(t *testing.T, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + b64(sig)
+}
+
+// jwks renders the public halves of keys as a JWKS document.
+func jwks(keys map[string]crypto.Signer) []byte {
+	var out []map[string]string
+	for kid, key := range keys {
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			out = append(out, map[string]string{"kty": "RSA", "kid": kid, "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())})
+		case *ecdsa.PrivateKey:
+			out = append(out, map[string]string{"kty": "EC", "kid": kid, "crv": "P-256",
+				"x": b64(k.X.FillBytes(make([]byte, 32))), "y": b64(k.Y.FillBytes(make([]byte, 32)))})
+		}
+	}
+	data, _ := json.Marshal(map[string]interface{}{"keys": out})
+	return data
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	var doc atomic.Value
+	doc.Store(jwks(map[string]crypto.Signer{"rsa": rsaKey, "ec": ecKey}))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(doc.Load().([]byte))
+	}))
+	defer srv.Close()
+
+	keys, err := jwtauth.NewKeySet("", srv.URL, 0)
+	if err != nil {
+		t.Fatalf("NewKeySet() error = %v", err)
+	}
+	v := jwtauth.NewVerifier(keys, "https://komodo", "herald", time.Minute)
+
+	now := time.Now().Unix()
+	valid := map[string]interface{}{"iss": "https://komodo", "aud": "herald", "sub": "periphery-1", "stack": "myapp", "exp": now + 300}
+	with := func(k string, val interface{}) map[string]interface{} {
+		c := map[string]interface{}{}
+		for key, v := range valid {
+			c[key] = v
+		}
+		if val == nil {
+			delete(c, k)
+		} else {
+			c[k] = val
+		}
+		return c
+	}
+
+	for _, tt := range []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{"rsa", sign(t, "rsa", rsaKey, valid), ""},
+		{"ec", sign(t, "ec", ecKey, valid), ""},
+		{"audience list", sign(t, "ec", ecKey, with("aud", []string{"other", "herald"})), ""},
+		{"expired", sign(t, "ec", ecKey, with("exp", now-600)), "expired"},
+		{"no exp", sign(t, "ec", ecKey, with("exp", nil)), "exp"},
+		{"not yet valid", sign(t, "ec", ecKey, with("nbf", now+600)), "not valid yet"},
+		{"wrong issuer", sign(t, "ec", ecKey, with("iss", "https://evil")), "issuer"},
+		{"wrong audience", sign(t, "ec", ecKey, with("aud", "other")), "audience"},
+		{"wrong key", sign(t, "ec", otherKey, valid), "invalid signature"},
+		{"key type mismatch", sign(t, "rsa", ecKey, valid), "does not match"},
+		{"unknown kid", sign(t, "gone", ecKey, valid), "unknown key id"},
+		{"alg none", strings.Join([]string{b64([]byte(`{"alg":"none","kid":"ec"}`)), b64([]byte(`{"exp":9999999999}`)), ""}, "."), "unsupported alg"},
+	} {
+		claims, err := v.Verify(tt.token)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: Verify() error = %v", tt.name, err)
+		case tt.wantErr == "" && claims.String("stack") != "myapp":
+			t.Errorf("%s: stack claim = %q", tt.name, claims.String("stack"))
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: Verify() error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// A rotated issuer key is picked up from the JWKS URL without a restart.
+	doc.Store(jwks(map[string]crypto.Signer{"new": otherKey}))
+	if _, err := v.Verify(sign(t, "new", otherKey, valid)); err != nil {
+		t.Errorf("Verify() with rotated key error = %v", err)
+	}
+}
+
+func TestKeySetRefetchOutsideLock(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rotated, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	var doc atomic.Value
+	doc.Store(jwks(map[string]crypto.Signer{"ec": key}))
+	var hits atomic.Int32
+	started, release := make(chan struct{}), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 2 {
+			close(started)
+			<-release
+		}
+		w.Write(doc.Load().([]byte))
+	}))
+	defer srv.Close()
+
+	keys, err := jwtauth.NewKeySet("", srv.URL, 0)
+	if err != nil {
+		t.Fatalf("NewKeySet() error = %v", err)
+	}
+	v := jwtauth.NewVerifier(keys, "https://komodo", "herald", time.Minute)
+	claims := map[string]interface{}{"iss": "https://komodo", "aud": "herald", "exp": time.Now().Unix() + 300}
+
+	// The second request blocks inside the issuer; a known key still verifies.
+	doc.Store(jwks(map[string]crypto.Signer{"ec": key, "new": rotated}))
+	errs := make(chan error, 1)
+	go func() {
+		_, err := v.Verify(sign(t, "new", rotated, claims))
+		errs <- err
+	}()
+	<-started
+	verified := make(chan error, 1)
+	go func() {
+		_, err := v.Verify(sign(t, "ec", key, claims))
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Errorf("Verify() of a known key during a refetch error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Verify() of a known key blocked on a JWKS refetch")
+	}
+	close(release)
+	if err := <-errs; err != nil {
+		t.Errorf("Verify() with rotated key error = %v", err)
+	}
+}
+
+func TestKeySetInitialFetchFailure(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			http.Error(w, "issuer down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(jwks(map[string]crypto.Signer{"ec": key}))
+	}))
+	defer srv.Close()
+
+	// A long refresh interval: only the failed startup fetch may trigger the
+	// retry, not the periodic refresh or the once-a-minute unknown kid refetch.
+	keys, err := jwtauth.NewKeySet("", srv.URL, time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeySet() error = %v", err)
+	}
+	v := jwtauth.NewVerifier(keys, "https://komodo", "herald", time.Minute)
+	claims := map[string]interface{}{"iss": "https://komodo", "aud": "herald", "exp": time.Now().Unix() + 300}
+	if _, err := v.Verify(sign(t, "ec", key, claims)); err != nil {
+		t.Errorf("Verify() after a failed initial fetch error = %v", err)
+	}
+	if n := hits.Load(); n != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", n)
+	}
+}
@@ -0,0 +1,68 @@
+// Package kek loads key-encryption keys for cache envelope encryption from a
+// key file, a secret resolved from a provider, or a PKCS#11 token.
+package kek
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/elabx-org/herald/internal/cache"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Source types accepted in cache.kek.type.
+const (
+	TypeFile        = "file"
+	TypePKCS11      = "pkcs11"
+	TypeOnePassword = "1password"
+)
+
+// FromFile loads a 32-byte KEK from path. The file may hold the raw bytes or
+// their hex or base64 encoding (e.g. `openssl rand -hex 32`).
+func FromFile(path string) (cache.KEK, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("kek: %w", err)
+	}
+	key, err := decodeKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("kek: %s: %w", path, err)
+	}
+	return cache.NewAESKEK("file:"+fingerprint(key), key)
+}
+
+func decodeKey(data []byte) ([]byte, error) {
+	if len(data) == 32 {
+		return data, nil
+	}
+	s := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, fmt.Errorf("want 32 bytes (raw, hex or base64)")
+}
+
+// FromSecret derives a KEK from a secret value, such as a 1Password field
+// resolved at startup. ref names the secret in the KEK's ID; the value is
+// stretched with PBKDF2 so a memorable password is not used directly.
+func FromSecret(ref, value string) (cache.KEK, error) {
+	if value == "" {
+		return nil, fmt.Errorf("kek: %s is empty", ref)
+	}
+	key := pbkdf2.Key([]byte(value), []byte("herald-kek-v1"), 100_000, 32, sha256.New)
+	return cache.NewAESKEK("secret:"+ref+":"+fingerprint(key), key)
+}
+
+// fingerprint identifies key material without revealing it, so a replaced
+// key file is detected as a different KEK.
+func fingerprint(key []byte) string {
+	sum := sha256.Sum256(append([]byte("herald-kek-id"), key...))
+	return hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,70 @@
+package kek_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elabx-org/herald/internal/kek"
+)
+
+func TestFromFile(t *testing.T) {
+	raw := bytes.Repeat([]byte{0x42}, 32)
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"raw":    raw,
+		"hex":    []byte(hex.EncodeToString(raw) + "\n"),
+		"base64": []byte(base64.StdEncoding.EncodeToString(raw)),
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, data, 0600)
+		k, err := kek.FromFile(path)
+		if err != nil {
+			t.Fatalf("FromFile(%s) error = %v", name, err)
+		}
+		wrapped, err := k.Wrap([]byte("data-key"))
+		if err != nil {
+			t.Fatalf("Wrap(%s) error = %v", name, err)
+		}
+		if got, err := k.Unwrap(wrapped); err != nil || string(got) != "data-key" {
+			t.Errorf("Unwrap(%s) = %q, %v; want data-key", name, got, err)
+		}
+	}
+
+	// All encodings of the same key share an ID.
+	a, _ := kek.FromFile(filepath.Join(dir, "raw"))
+	b, _ := kek.FromFile(filepath.Join(dir, "hex"))
+	if a.ID() != b.ID() {
+		t.Errorf("ID() differs across encodings: %q vs %q", a.ID(), b.ID())
+	}
+
+	short := filepath.Join(dir, "short")
+	os.WriteFile(short, []byte("not-a-key"), 0600)
+	if _, err := kek.FromFile(short); err == nil {
+		t.Error("FromFile(short) succeeded, want error")
+	}
+	if _, err := kek.FromFile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("FromFile(missing) succeeded, want error")
+	}
+}
+
+func TestFromSecret(t *testing.T) {
+	a, err := kek.FromSecret("op://Infra/herald/kek", "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("FromSecret() error = %v", err)
+	}
+	b, _ := kek.FromSecret("op://Infra/herald/kek", "a different value")
+	if a.ID() == b.ID() {
+		t.Error("different secret values produced the same KEK ID")
+	}
+	wrapped, _ := a.Wrap([]byte("data-key"))
+	if _, err := b.Unwrap(wrapped); err == nil {
+		t.Error("Unwrap() with a different secret succeeded, want error")
+	}
+	if _, err := kek.FromSecret("op://Infra/herald/kek", ""); err == nil {
+		t.Error("FromSecret(empty) succeeded, want error")
+	}
+}
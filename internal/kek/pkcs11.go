@@ -0,0 +1,148 @@
+//go:build cgo
+
+package kek
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+const gcmIVSize = 12
+
+// PKCS11Config locates an AES key on a PKCS#11 token.
+type PKCS11Config struct {
+	Module     string // path to the PKCS#11 library, e.g. libsofthsm2.so
+	TokenLabel string
+	KeyLabel   string
+	PIN        string
+}
+
+// PKCS11 wraps data keys with AES-GCM inside the token; the KEK never leaves
+// the HSM.
+type PKCS11 struct {
+	mu      sync.Mutex // a PKCS#11 session runs one operation at a time
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	key     pkcs11.ObjectHandle
+	id      string
+}
+
+// OpenPKCS11 loads the module, logs in to the token and finds the key. Call
+// Close when done.
+func OpenPKCS11(cfg PKCS11Config) (*PKCS11, error) {
+	if cfg.Module == "" || cfg.TokenLabel == "" || cfg.KeyLabel == "" {
+		return nil, errors.New("kek: pkcs11 requires module, token_label and key_label")
+	}
+	ctx := pkcs11.New(cfg.Module)
+	if ctx == nil {
+		return nil, fmt.Errorf("kek: cannot load pkcs11 module %s", cfg.Module)
+	}
+	k := &PKCS11{ctx: ctx, id: "pkcs11:" + cfg.TokenLabel + "/" + cfg.KeyLabel}
+	if err := k.open(cfg); err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("kek: pkcs11: %w", err)
+	}
+	return k, nil
+}
+
+func (k *PKCS11) open(cfg PKCS11Config) error {
+	if err := k.ctx.Initialize(); err != nil {
+		return err
+	}
+	slots, err := k.ctx.GetSlotList(true)
+	if err != nil {
+		return err
+	}
+	slot, found := uint(0), false
+	for _, s := range slots {
+		info, err := k.ctx.GetTokenInfo(s)
+		if err == nil && info.Label == cfg.TokenLabel {
+			slot, found = s, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("token %q not found", cfg.TokenLabel)
+	}
+
+	k.session, err = k.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return err
+	}
+	if err := k.ctx.Login(k.session, pkcs11.CKU_USER, cfg.PIN); err != nil {
+		return fmt.Errorf("login: %w", err)
+	}
+
+	if err := k.ctx.FindObjectsInit(k.session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, cfg.KeyLabel),
+	}); err != nil {
+		return err
+	}
+	objs, _, err := k.ctx.FindObjects(k.session, 1)
+	k.ctx.FindObjectsFinal(k.session)
+	if err != nil {
+		return err
+	}
+	if len(objs) == 0 {
+		return fmt.Errorf("key %q not found on token %q", cfg.KeyLabel, cfg.TokenLabel)
+	}
+	k.key = objs[0]
+	return nil
+}
+
+func (k *PKCS11) ID() string { return k.id }
+
+// Wrap encrypts dataKey with AES-GCM on the token and returns IV || ciphertext.
+func (k *PKCS11) Wrap(dataKey []byte) ([]byte, error) {
+	iv := make([]byte, gcmIVSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	params := pkcs11.NewGCMParams(iv, nil, 128)
+	defer params.Free()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.ctx.EncryptInit(k.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}, k.key); err != nil {
+		return nil, err
+	}
+	ct, err := k.ctx.Encrypt(k.session, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if actual := params.IV(); len(actual) == gcmIVSize {
+		iv = actual // some HSMs generate their own IV
+	}
+	return append(iv, ct...), nil
+}
+
+func (k *PKCS11) Unwrap(wrapped []byte) ([]byte, error) {
+	if len(wrapped) <= gcmIVSize {
+		return nil, errors.New("kek: wrapped key too short")
+	}
+	params := pkcs11.NewGCMParams(wrapped[:gcmIVSize], nil, 128)
+	defer params.Free()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.ctx.DecryptInit(k.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}, k.key); err != nil {
+		return nil, err
+	}
+	return k.ctx.Decrypt(k.session, wrapped[gcmIVSize:])
+}
+
+// Close logs out and unloads the module.
+func (k *PKCS11) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.ctx.Logout(k.session)
+	k.ctx.CloseSession(k.session)
+	k.ctx.Finalize()
+	k.ctx.Destroy()
+	return nil
+}
@@ -0,0 +1,26 @@
+//go:build !cgo
+
+package kek
+
+import "errors"
+
+// PKCS11Config locates an AES key on a PKCS#11 token.
+type PKCS11Config struct {
+	Module     string
+	TokenLabel string
+	KeyLabel   string
+	PIN        string
+}
+
+// PKCS11 is unavailable without cgo.
+type PKCS11 struct{}
+
+// OpenPKCS11 always fails: loading a PKCS#11 module requires cgo.
+func OpenPKCS11(cfg PKCS11Config) (*PKCS11, error) {
+	return nil, errors.New("kek: pkcs11 support requires a cgo build")
+}
+
+func (k *PKCS11) ID() string                            { return "" }
+func (k *PKCS11) Wrap(dataKey []byte) ([]byte, error)   { return nil, errors.ErrUnsupported }
+func (k *PKCS11) Unwrap(wrapped []byte) ([]byte, error) { return nil, errors.ErrUnsupported }
+func (k *PKCS11) Close() error                          { return nil }
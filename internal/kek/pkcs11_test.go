@@ -0,0 +1,46 @@
+//go:build cgo
+
+package kek_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/elabx-org/herald/internal/kek"
+)
+
+// TestPKCS11 runs against SoftHSM (or any token) when configured, e.g.:
+//
+//	softhsm2-util --init-token --free --label herald --pin 1234 --so-pin 1234
+//	pkcs11-tool --module $MODULE --login --pin 1234 --token-label herald \
+//	    --keygen --key-type AES:32 --label herald-kek
+//	HERALD_TEST_PKCS11_MODULE=$MODULE HERALD_TEST_PKCS11_PIN=1234 go test ./internal/kek
+func TestPKCS11(t *testing.T) {
+	module := os.Getenv("HERALD_TEST_PKCS11_MODULE")
+	if module == "" {
+		t.Skip("HERALD_TEST_PKCS11_MODULE not set")
+	}
+	k, err := kek.OpenPKCS11(kek.PKCS11Config{
+		Module:     module,
+		TokenLabel: "herald",
+		KeyLabel:   "herald-kek",
+		PIN:        os.Getenv("HERALD_TEST_PKCS11_PIN"),
+	})
+	if err != nil {
+		t.Fatalf("OpenPKCS11() error = %v", err)
+	}
+	defer k.Close()
+
+	wrapped, err := k.Wrap([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("Wrap() error = %v", err)
+	}
+	got, err := k.Unwrap(wrapped)
+	if err != nil || string(got) != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("Unwrap() = %q, %v; want the data key", got, err)
+	}
+	wrapped[len(wrapped)-1] ^= 0xff
+	if _, err := k.Unwrap(wrapped); err == nil {
+		t.Error("Unwrap(tampered) succeeded, want error")
+	}
+}
@@ -0,0 +1,205 @@
+package lint
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/elabx-org/herald/internal/resolver"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+const (
+	RuleKnownPrefix    = "known_prefix"
+	RulePrivateKey     = "private_key"
+	RuleURLCredentials = "url_credentials"
+	RuleHighEntropy    = "high_entropy"
+	RuleSecretName     = "secret_name"
+	RuleMalformedRef   = "malformed_ref"
+)
+
+// Finding is a single lint result. It never carries the offending value.
+type Finding struct {
+	Line     int    `json:"line"`
+	Key      string `json:"key,omitempty"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// knownPrefixes maps token prefixes issued by common services to a label.
+// A value must start with the prefix and be at least minPrefixedLen long.
+var knownPrefixes = []struct {
+	prefix string
+	label  string
+}{
+	{"github_pat_", "GitHub fine-grained token"},
+	{"ghp_", "GitHub personal access token"},
+	{"gho_", "GitHub OAuth token"},
+	{"ghu_", "GitHub user-to-server token"},
+	{"ghs_", "GitHub server-to-server token"},
+	{"ghr_", "GitHub refresh token"},
+	{"glpat-", "GitLab personal access token"},
+	{"sk_live_", "Stripe secret key"},
+	{"rk_live_", "Stripe restricted key"},
+	{"sk-", "OpenAI/Anthropic-style secret key"},
+	{"xoxb-", "Slack bot token"},
+	{"xoxp-", "Slack user token"},
+	{"xoxa-", "Slack app token"},
+	{"xapp-", "Slack app-level token"},
+	{"AKIA", "AWS access key ID"},
+	{"AIza", "Google API key"},
+	{"npm_", "npm token"},
+	{"hf_", "Hugging Face token"},
+	{"SG.", "SendGrid API key"},
+}
+
+const (
+	minPrefixedLen   = 16
+	minEntropyLen    = 20
+	entropyThreshold = 4.0 // bits per character
+)
+
+var (
+	privateKeyRegex = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY( BLOCK)?-----`)
+	// urlCredsRegex matches scheme://user:password@ and captures the password.
+	urlCredsRegex = regexp.MustCompile(`[A-Za-z][A-Za-z0-9+.-]*://[^\s:/@]*:([^\s@]+)@`)
+	varRefRegex   = regexp.MustCompile(`^\$\{?[A-Za-z_][A-Za-z0-9_]*(:?[-?][^}]*)?\}?$`)
+)
+
+// IsLikelySecretName returns true if an env var or field name suggests it holds
+// a secret value.
+func IsLikelySecretName(name string) bool {
+	lower := strings.ToLower(name)
+	secretWords := []string{"password", "passwd", "secret", "token", "key", "api_key", "apikey", "credential", "private"}
+	for _, w := range secretWords {
+		if strings.Contains(lower, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// Scan reads env content and reports likely plaintext secrets and malformed
+// op:// refs. Line numbers are 1-based and count every line, including
+// comments and blanks.
+func Scan(r io.Reader) ([]Finding, error) {
+	var findings []Finding
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, hasValue := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !hasValue {
+			// Continuation lines of multi-line values can still leak key material.
+			if privateKeyRegex.MatchString(line) {
+				findings = append(findings, Finding{Line: lineNo, Rule: RulePrivateKey, Severity: SeverityError,
+					Message: "private key material in plaintext"})
+			}
+			continue
+		}
+		key = strings.TrimSpace(key)
+		findings = append(findings, checkValue(lineNo, key, unquote(strings.TrimSpace(value)))...)
+	}
+	return findings, scanner.Err()
+}
+
+func checkValue(lineNo int, key, value string) []Finding {
+	var findings []Finding
+	add := func(rule, severity, msg string) {
+		findings = append(findings, Finding{Line: lineNo, Key: key, Rule: rule, Severity: severity, Message: msg})
+	}
+
+	for _, frag := range resolver.MalformedRefs(value) {
+		add(RuleMalformedRef, SeverityError,
+			fmt.Sprintf("malformed reference %q — expected op://vault/item/field using letters, digits, '_' or '-'", frag))
+	}
+	if value == "" || resolver.HasRef(value) && !hasLiteralURLPassword(value) {
+		return findings
+	}
+
+	if privateKeyRegex.MatchString(value) {
+		add(RulePrivateKey, SeverityError, "private key material in plaintext")
+		return findings
+	}
+	for _, p := range knownPrefixes {
+		if strings.HasPrefix(value, p.prefix) && len(value) >= minPrefixedLen {
+			add(RuleKnownPrefix, SeverityError, fmt.Sprintf("value looks like a %s (%s...)", p.label, p.prefix))
+			return findings
+		}
+	}
+	if hasLiteralURLPassword(value) {
+		add(RuleURLCredentials, SeverityError, "URL contains a plaintext password")
+		return findings
+	}
+	if looksBenign(value) {
+		return findings
+	}
+	if len(value) >= minEntropyLen && !strings.ContainsAny(value, " \t") && shannonEntropy(value) >= entropyThreshold {
+		add(RuleHighEntropy, SeverityWarning, "high-entropy value — likely a plaintext secret")
+		return findings
+	}
+	if IsLikelySecretName(key) {
+		add(RuleSecretName, SeverityWarning, "name suggests a secret but the value is not an op:// reference")
+	}
+	return findings
+}
+
+// hasLiteralURLPassword reports whether value contains a URL whose password
+// is neither an op:// ref nor a variable reference.
+func hasLiteralURLPassword(value string) bool {
+	m := urlCredsRegex.FindStringSubmatch(value)
+	return m != nil && !resolver.HasRef(m[1]) && !varRefRegex.MatchString(m[1])
+}
+
+// looksBenign filters out values that are never secrets: variable references,
+// paths, booleans, numbers and plain URLs without credentials.
+func looksBenign(value string) bool {
+	lower := strings.ToLower(value)
+	switch {
+	case varRefRegex.MatchString(value):
+		return true
+	case strings.HasPrefix(value, "/"), strings.HasPrefix(value, "./"), strings.HasPrefix(value, "~/"):
+		return true
+	case lower == "true" || lower == "false" || lower == "yes" || lower == "no":
+		return true
+	case strings.Trim(value, "0123456789.") == "":
+		return true
+	case strings.Contains(value, "://") && !urlCredsRegex.MatchString(value):
+		return true
+	}
+	return false
+}
+
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	n := float64(len([]rune(s)))
+	var h float64
+	for _, c := range counts {
+		p := float64(c) / n
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+func unquote(v string) string {
+	if len(v) >= 2 && (v[0] == '"' && v[len(v)-1] == '"' || v[0] == '\'' && v[len(v)-1] == '\'') {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
@@ -2,12 +2,16 @@ package materialize
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/elabx-org/herald/internal/cache"
+	"github.com/elabx-org/herald/internal/format"
+	"github.com/elabx-org/herald/internal/fsutil"
 	"github.com/elabx-org/herald/internal/resolver"
 	"github.com/rs/zerolog/log"
 )
@@ -16,90 +20,435 @@ type Resolver interface {
 	Resolve(ctx context.Context, vault, item, field string) (string, string, error)
 }
 
+// Materialize modes. In strict mode the first failing ref aborts the whole
+// materialization; in lenient mode every ref is attempted and failures are
+// reported per ref.
+const (
+	ModeStrict  = "strict"
+	ModeLenient = "lenient"
+)
+
+// Per-ref outcomes reported in RefReport.Outcome.
+const (
+	OutcomeResolved = "resolved" // fetched from a provider
+	OutcomeCached   = "cached"   // served from a live cache entry
+	OutcomeStale    = "stale"    // served from an expired cache entry
+	OutcomeFailed   = "failed"
+	OutcomeSkipped  = "skipped" // not attempted: strict mode aborted after another failure
+)
+
+// Error classes reported in RefReport.ErrorClass.
+const (
+	ErrClassNotFound     = "not_found"
+	ErrClassRateLimited  = "rate_limited"
+	ErrClassUnauthorized = "unauthorized"
+	ErrClassTimeout      = "timeout"
+	ErrClassUnavailable  = "provider_unavailable"
+	ErrClassUnknown      = "unknown"
+)
+
 type Result struct {
 	Resolved   int
 	CacheHits  int
 	StaleHits  int
 	Failed     int
 	DurationMs int64
+	Refs       []RefReport // one per ref, sorted by Ref
+}
+
+// RefReport describes how a single op:// ref was served. It never carries the
+// secret value.
+type RefReport struct {
+	Ref             string   `json:"ref"`
+	Keys            []string `json:"keys,omitempty"` // env var names that use this ref
+	Outcome         string   `json:"outcome"`
+	Provider        string   `json:"provider,omitempty"`
+	Policy          string   `json:"policy,omitempty"`            // cache policy the value was stored or found under
+	CacheAgeSeconds int64    `json:"cache_age_seconds,omitempty"` // cached/stale only
+	StaleReason     string   `json:"stale_reason,omitempty"`      // error class that caused a stale delivery
+	ErrorClass      string   `json:"error_class,omitempty"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// Provenance records where the value of a single env key came from. A key
+// with several inline refs gets one entry per ref. It never carries the value.
+type Provenance struct {
+	Key             string `json:"key"`
+	Ref             string `json:"ref"`
+	Outcome         string `json:"outcome"`
+	Provider        string `json:"provider,omitempty"`
+	CacheAgeSeconds int64  `json:"cache_age_seconds,omitempty"`
+	StaleReason     string `json:"stale_reason,omitempty"`
+}
+
+// Provenance flattens the ref reports into one entry per env key and ref,
+// sorted by key. Call after SetKeys.
+func (r *Result) Provenance() []Provenance {
+	var out []Provenance
+	for _, rep := range r.Refs {
+		for _, key := range rep.Keys {
+			out = append(out, Provenance{
+				Key:             key,
+				Ref:             rep.Ref,
+				Outcome:         rep.Outcome,
+				Provider:        rep.Provider,
+				CacheAgeSeconds: rep.CacheAgeSeconds,
+				StaleReason:     rep.StaleReason,
+			})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Key != out[j].Key {
+			return out[i].Key < out[j].Key
+		}
+		return out[i].Ref < out[j].Ref
+	})
+	return out
+}
+
+// Policies returns the distinct cache policies refs were served under, sorted.
+func (r *Result) Policies() []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, rep := range r.Refs {
+		if rep.Policy == "" || seen[rep.Policy] {
+			continue
+		}
+		seen[rep.Policy] = true
+		out = append(out, rep.Policy)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Providers returns the distinct providers that actually served a value
+// (fresh, cached or stale), sorted.
+func (r *Result) Providers() []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, rep := range r.Refs {
+		if rep.Provider == "" || seen[rep.Provider] {
+			continue
+		}
+		seen[rep.Provider] = true
+		out = append(out, rep.Provider)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// SetKeys attaches env var names to each ref report. keysByURI maps raw op://
+// URI to the variables that reference it.
+func (r *Result) SetKeys(keysByURI map[string][]string) {
+	for i := range r.Refs {
+		r.Refs[i].Keys = keysByURI[r.Refs[i].Ref]
+	}
 }
 
+// DefaultConcurrency is the number of refs resolved in parallel when no
+// explicit limit is configured.
+const DefaultConcurrency = 8
+
 type EnvMaterializer struct {
 	store         *cache.Store
 	manager       Resolver
 	defaultPolicy string
 	defaultTTL    int
+	concurrency   int
+	mode          string
+	format        string
+	refOptions    map[string]resolver.RefOption // per-ref policy/TTL overrides, keyed by raw URI
+	stale         StalePolicy
 }
 
 func NewEnvMaterializer(store *cache.Store, mgr Resolver, defaultPolicy string, defaultTTL int) *EnvMaterializer {
-	return &EnvMaterializer{store: store, manager: mgr, defaultPolicy: defaultPolicy, defaultTTL: defaultTTL}
+	return &EnvMaterializer{
+		store:         store,
+		manager:       mgr,
+		defaultPolicy: defaultPolicy,
+		defaultTTL:    defaultTTL,
+		concurrency:   DefaultConcurrency,
+		mode:          ModeStrict,
+		format:        format.Env,
+	}
+}
+
+// SetConcurrency caps how many refs are resolved in parallel. Values below 1
+// fall back to DefaultConcurrency.
+func (m *EnvMaterializer) SetConcurrency(n int) {
+	if n < 1 {
+		n = DefaultConcurrency
+	}
+	m.concurrency = n
+}
+
+// SetMode selects ModeStrict (default) or ModeLenient. Unknown values are
+// treated as strict.
+func (m *EnvMaterializer) SetMode(mode string) {
+	if mode != ModeLenient {
+		mode = ModeStrict
+	}
+	m.mode = mode
+}
+
+// SetStalePolicy controls when expired cache entries may be served in place
+// of a failed provider lookup. The default serves them only on rate limiting,
+// with no age bound.
+func (m *EnvMaterializer) SetStalePolicy(p StalePolicy) {
+	m.stale = p
+}
+
+// SetRefOptions applies per-ref cache policy/TTL overrides (see
+// resolver.RefOptions). Refs without an entry use the defaults.
+func (m *EnvMaterializer) SetRefOptions(opts map[string]resolver.RefOption) {
+	m.refOptions = opts
+}
+
+// SetFormat selects the output format (see package format). The default,
+// format.Env, preserves the input's lines; other formats only carry the
+// variables. Unknown values are rejected by Materialize.
+func (m *EnvMaterializer) SetFormat(f string) {
+	if f == "" {
+		f = format.Env
+	}
+	m.format = f
+}
+
+// render converts line-preserving dotenv output into m.format. vars is only
+// consulted for non-Env formats.
+func (m *EnvMaterializer) render(stack, content string, vars func() []format.Var) (string, error) {
+	if m.format == format.Env {
+		return content, nil
+	}
+	return format.Render(m.format, stack, vars())
 }
 
 // Materialize resolves all op:// refs in envContent and returns the complete
 // resolved env content (non-secret lines preserved). If outPath is non-empty,
 // the resolved content is also written to that file.
+//
+// In lenient mode, lines whose refs could not be resolved are commented out
+// rather than passed through with a literal op:// value.
 func (m *EnvMaterializer) Materialize(ctx context.Context, stack string, refs map[string]*resolver.SecretRef, envContent string, outPath string) (string, *Result, error) {
+	start := time.Now()
+	resolvedVals, result, err := m.ResolveRefs(ctx, refs)
+	result.SetKeys(resolver.RefKeys(envContent))
+	if err != nil {
+		return "", result, err
+	}
+
+	// Build complete resolved env content
+	content := resolver.ResolveEnvContent(envContent, resolvedVals)
+	if result.Failed > 0 {
+		content = resolver.DisableUnresolved(content)
+	}
+	content, err = m.render(stack, content, func() []format.Var {
+		return format.Vars(envContent, resolvedVals)
+	})
+	if err != nil {
+		return "", result, fmt.Errorf("render %s: %w", m.format, err)
+	}
+
+	// Write to file if path specified
+	if outPath != "" {
+		if err := writeFile(outPath, content); err != nil {
+			return "", result, fmt.Errorf("write env file: %w", err)
+		}
+	}
+
+	result.DurationMs = time.Since(start).Milliseconds()
+	return content, result, nil
+}
+
+// ResolveRefs resolves every ref (cache first, then provider) and returns the
+// values keyed by raw op:// URI. Callers that render something other than an
+// env file (e.g. Compose overrides) use this directly.
+//
+// Refs are resolved concurrently, at most m.concurrency at a time. In strict
+// mode the first failure cancels outstanding lookups and is returned as the
+// error; refs aborted by that cancellation are reported as skipped, not
+// failed. In lenient mode failures are only recorded in the result and the
+// returned map holds every ref that did resolve.
+func (m *EnvMaterializer) ResolveRefs(ctx context.Context, refs map[string]*resolver.SecretRef) (map[string]string, *Result, error) {
 	start := time.Now()
 	result := &Result{}
-	resolvedVals := make(map[string]string)
+	resolvedVals := make(map[string]string, len(refs))
+	reports := make(map[string]RefReport, len(refs))
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	sem := make(chan struct{}, m.concurrency)
 
 	// refs is keyed by raw op:// URI; resolvedVals mirrors that key so
 	// ResolveEnvContent can do URI-based substitution (handles both standalone
 	// and inline refs uniformly).
+dispatch:
 	for rawURI, ref := range refs {
-		cacheKey := fmt.Sprintf("%s/%s/%s", ref.Vault, ref.Item, ref.Field)
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break dispatch
+		}
+		wg.Add(1)
+		go func(rawURI string, ref *resolver.SecretRef) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			val, rep, err := m.resolveOne(ctx, rawURI, ref)
+			rep.Ref = rawURI
 
-		if m.store != nil {
-			if entry, err := m.store.Get(cacheKey); err == nil {
-				resolvedVals[rawURI] = entry.Value
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr != nil && errors.Is(err, context.Canceled) {
+					reports[rawURI] = RefReport{Ref: rawURI, Outcome: OutcomeSkipped}
+					return // aborted because another ref already failed
+				}
+				result.Failed++
+				rep.Outcome = OutcomeFailed
+				rep.ErrorClass = ClassifyError(err)
+				rep.Error = err.Error()
+				reports[rawURI] = rep
+				if firstErr == nil && m.mode == ModeStrict {
+					firstErr = fmt.Errorf("resolve %s: %w", rawURI, err)
+					cancel()
+				}
+				return
+			}
+			reports[rawURI] = rep
+			resolvedVals[rawURI] = val
+			switch rep.Outcome {
+			case OutcomeCached:
 				result.CacheHits++
-				continue
+			case OutcomeStale:
+				result.StaleHits++
+			default:
+				result.Resolved++
 			}
+		}(rawURI, ref)
+	}
+	wg.Wait()
+
+	for rawURI := range refs {
+		if _, ok := reports[rawURI]; !ok {
+			reports[rawURI] = RefReport{Ref: rawURI, Outcome: OutcomeSkipped}
 		}
+	}
+	result.Refs = make([]RefReport, 0, len(reports))
+	for _, rep := range reports {
+		result.Refs = append(result.Refs, rep)
+	}
+	sort.Slice(result.Refs, func(i, j int) bool { return result.Refs[i].Ref < result.Refs[j].Ref })
 
-		val, providerName, err := m.manager.Resolve(ctx, ref.Vault, ref.Item, ref.Field)
-		if err != nil {
-			if m.store != nil && strings.Contains(err.Error(), "rate limit") {
-				if stale, serr := m.store.GetStale(cacheKey); serr == nil {
-					log.Warn().Str("key", cacheKey).Msg("provider rate limited — serving stale cache value")
-					resolvedVals[rawURI] = stale.Value
-					result.StaleHits++
-					continue
-				}
-			}
-			result.Failed++
-			return "", result, fmt.Errorf("resolve %s: %w", rawURI, err)
+	if firstErr == nil && parent.Err() != nil {
+		firstErr = fmt.Errorf("resolve: %w", parent.Err())
+	}
+	result.DurationMs = time.Since(start).Milliseconds()
+	if firstErr != nil {
+		return nil, result, firstErr
+	}
+	return resolvedVals, result, nil
+}
+
+// resolveOne serves a single ref from cache or the provider, falling back to a
+// stale cache entry when the stale policy allows it. On success the returned
+// report has Outcome, Provider and Policy set. Refs with policy "none" never
+// read from or write to the cache.
+func (m *EnvMaterializer) resolveOne(ctx context.Context, rawURI string, ref *resolver.SecretRef) (string, RefReport, error) {
+	cacheKey := fmt.Sprintf("%s/%s/%s", ref.Vault, ref.Item, ref.Field)
+	policy, ttl := m.policyFor(rawURI)
+
+	if m.store != nil && policy != cache.PolicyNone {
+		if entry, err := m.store.Get(cacheKey); err == nil {
+			return entry.Value, RefReport{Outcome: OutcomeCached, Provider: entry.Provider, Policy: entry.Policy, CacheAgeSeconds: int64(entry.Age().Seconds())}, nil
 		}
+	}
 
-		if m.store != nil {
-			if err := m.store.Set(cacheKey, &cache.Entry{
-				Value:     val,
-				Provider:  providerName,
-				Policy:    m.defaultPolicy,
-				ExpiresAt: time.Now().Add(time.Duration(m.defaultTTL) * time.Second),
-			}); err != nil {
-				log.Warn().Err(err).Str("key", cacheKey).Msg("materialize: cache write failed")
+	val, providerName, err := m.manager.Resolve(ctx, ref.Vault, ref.Item, ref.Field)
+	if err != nil {
+		class := ClassifyError(err)
+		if m.store != nil && policy != cache.PolicyNone && m.stale.serves(class) {
+			if stale, serr := m.store.GetStale(cacheKey); serr == nil {
+				staleFor := time.Since(stale.ExpiresAt)
+				if max := m.stale.maxAge(rawURI, ref.Vault); max == 0 || staleFor <= max {
+					log.Warn().Str("key", cacheKey).Str("reason", class).Dur("stale_for", staleFor).Msg("provider unavailable — serving stale cache value")
+					return stale.Value, RefReport{
+						Outcome:         OutcomeStale,
+						Provider:        stale.Provider,
+						Policy:          stale.Policy,
+						CacheAgeSeconds: int64(stale.Age().Seconds()),
+						StaleReason:     class,
+					}, nil
+				}
+				log.Warn().Str("key", cacheKey).Dur("stale_for", staleFor).Msg("provider unavailable — stale cache value too old to serve")
 			}
 		}
-
-		resolvedVals[rawURI] = val
-		result.Resolved++
+		return "", RefReport{}, err
 	}
 
-	// Build complete resolved env content
-	content := resolver.ResolveEnvContent(envContent, resolvedVals)
+	if m.store != nil {
+		if err := m.store.Set(cacheKey, &cache.Entry{
+			Value:     val,
+			Provider:  providerName,
+			Policy:    policy,
+			ExpiresAt: time.Now().Add(time.Duration(ttl) * time.Second),
+		}); err != nil {
+			log.Warn().Err(err).Str("key", cacheKey).Msg("materialize: cache write failed")
+		}
+	}
+	return val, RefReport{Outcome: OutcomeResolved, Provider: providerName, Policy: policy}, nil
+}
 
-	// Write to file if path specified
-	if outPath != "" {
-		if err := writeFile(outPath, content); err != nil {
-			return "", result, fmt.Errorf("write env file: %w", err)
+// policyFor returns the cache policy and TTL for a ref: its annotation if it
+// has one, otherwise the materializer defaults.
+func (m *EnvMaterializer) policyFor(rawURI string) (string, int) {
+	policy, ttl := m.defaultPolicy, m.defaultTTL
+	if opt, ok := m.refOptions[rawURI]; ok {
+		if opt.Policy != "" {
+			policy = opt.Policy
+		}
+		if opt.TTL > 0 {
+			ttl = opt.TTL
 		}
 	}
+	return policy, ttl
+}
 
-	result.DurationMs = time.Since(start).Milliseconds()
-	return content, result, nil
+// ClassifyError maps a resolve error to a stable error class. Providers only
+// surface free-form errors, so this inspects the message the same way the
+// rate-limit fallback does.
+func ClassifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrClassTimeout
+	}
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "rate limit"):
+		return ErrClassRateLimited
+	case strings.Contains(msg, "not found"), strings.Contains(msg, "no item"), strings.Contains(msg, "isn't a field"):
+		return ErrClassNotFound
+	case strings.Contains(msg, "unauthorized"), strings.Contains(msg, "forbidden"), strings.Contains(msg, "401"), strings.Contains(msg, "403"),
+		strings.Contains(msg, "invalid token"), strings.Contains(msg, "authentication"):
+		return ErrClassUnauthorized
+	case strings.Contains(msg, "timeout"), strings.Contains(msg, "deadline exceeded"):
+		return ErrClassTimeout
+	case strings.Contains(msg, "connection refused"), strings.Contains(msg, "no such host"), strings.Contains(msg, "connect:"),
+		strings.Contains(msg, "eof"), strings.Contains(msg, "no providers configured"), strings.Contains(msg, "503"), strings.Contains(msg, "502"):
+		return ErrClassUnavailable
+	}
+	return ErrClassUnknown
 }
 
 func writeFile(path, content string) error {
-	return os.WriteFile(path, []byte(content), 0600)
+	return fsutil.WriteFileAtomic(path, []byte(content), 0600, -1, -1)
 }
@@ -2,9 +2,13 @@ package materialize_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/elabx-org/herald/internal/cache"
 	"github.com/elabx-org/herald/internal/materialize"
@@ -69,6 +73,18 @@ func TestMaterializeEnv(t *testing.T) {
 	if result2.CacheHits != 1 {
 		t.Errorf("CacheHits = %d, want 1", result2.CacheHits)
 	}
+
+	prov := result2.Provenance()
+	if len(prov) != 1 {
+		t.Fatalf("Provenance() len = %d, want 1", len(prov))
+	}
+	p := prov[0]
+	if p.Key != "DB_PASSWORD" || p.Ref != "op://Vault/item/password" || p.Outcome != materialize.OutcomeCached || p.Provider != "mock" {
+		t.Errorf("Provenance()[0] = %+v", p)
+	}
+	if got := result2.Providers(); len(got) != 1 || got[0] != "mock" {
+		t.Errorf("Providers() = %v, want [mock]", got)
+	}
 }
 
 func TestMaterializeEnvNoFile(t *testing.T) {
@@ -117,3 +133,303 @@ func TestMaterializeEnvInline(t *testing.T) {
 		t.Errorf("plain value not preserved, got:\n%s", content)
 	}
 }
+
+// slowMgr tracks the peak number of concurrent Resolve calls.
+type slowMgr struct {
+	delay    time.Duration
+	failItem string
+	inFlight atomic.Int32
+	peak     atomic.Int32
+}
+
+func (m *slowMgr) Resolve(ctx context.Context, vault, item, field string) (string, string, error) {
+	n := m.inFlight.Add(1)
+	defer m.inFlight.Add(-1)
+	for {
+		p := m.peak.Load()
+		if n <= p || m.peak.CompareAndSwap(p, n) {
+			break
+		}
+	}
+	if item == m.failItem {
+		return "", "", fmt.Errorf("item %q not found", item)
+	}
+	select {
+	case <-time.After(m.delay):
+		return "val-" + item, "mock", nil
+	case <-ctx.Done():
+		return "", "", ctx.Err()
+	}
+}
+
+func testRefs(n int) map[string]*resolver.SecretRef {
+	refs := make(map[string]*resolver.SecretRef, n)
+	for i := 0; i < n; i++ {
+		uri := fmt.Sprintf("op://V/item%d/f", i)
+		refs[uri] = &resolver.SecretRef{Vault: "V", Item: fmt.Sprintf("item%d", i), Field: "f", Raw: uri}
+	}
+	return refs
+}
+
+func TestResolveRefsConcurrent(t *testing.T) {
+	mgr := &slowMgr{delay: 20 * time.Millisecond}
+	mat := materialize.NewEnvMaterializer(nil, mgr, "memory", 3600)
+	mat.SetConcurrency(4)
+
+	vals, result, err := mat.ResolveRefs(context.Background(), testRefs(12))
+	if err != nil {
+		t.Fatalf("ResolveRefs() error = %v", err)
+	}
+	if result.Resolved != 12 || len(vals) != 12 {
+		t.Errorf("Resolved = %d, len(vals) = %d, want 12", result.Resolved, len(vals))
+	}
+	if vals["op://V/item3/f"] != "val-item3" {
+		t.Errorf("vals[item3] = %q, want val-item3", vals["op://V/item3/f"])
+	}
+	if peak := mgr.peak.Load(); peak > 4 || peak < 2 {
+		t.Errorf("peak concurrency = %d, want 2..4", peak)
+	}
+}
+
+func TestResolveRefsFailureCancels(t *testing.T) {
+	mgr := &slowMgr{delay: time.Second, failItem: "item0"}
+	mat := materialize.NewEnvMaterializer(nil, mgr, "memory", 3600)
+	mat.SetConcurrency(20)
+
+	start := time.Now()
+	_, result, err := mat.ResolveRefs(context.Background(), testRefs(10))
+	if err == nil {
+		t.Fatal("ResolveRefs() error = nil, want error")
+	}
+	if result.Failed != 1 {
+		t.Errorf("Failed = %d, want 1 (cancelled lookups are not failures)", result.Failed)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("ResolveRefs took %v, want outstanding lookups cancelled promptly", time.Since(start))
+	}
+}
+
+func TestResolveRefsContextDeadline(t *testing.T) {
+	mat := materialize.NewEnvMaterializer(nil, &slowMgr{delay: time.Second}, "memory", 3600)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, _, err := mat.ResolveRefs(ctx, testRefs(3)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ResolveRefs() error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestMaterializeLenient(t *testing.T) {
+	refs := map[string]*resolver.SecretRef{
+		"op://V/item0/f": {Vault: "V", Item: "item0", Field: "f", Raw: "op://V/item0/f"},
+		"op://V/item1/f": {Vault: "V", Item: "item1", Field: "f", Raw: "op://V/item1/f"},
+	}
+	envContent := "BAD=op://V/item0/f\nGOOD=op://V/item1/f\nALSO_GOOD=op://V/item1/f\n"
+
+	mat := materialize.NewEnvMaterializer(nil, &slowMgr{failItem: "item0"}, "memory", 3600)
+	mat.SetMode(materialize.ModeLenient)
+
+	content, result, err := mat.Materialize(context.Background(), "myapp", refs, envContent, "")
+	if err != nil {
+		t.Fatalf("Materialize() error = %v, want nil in lenient mode", err)
+	}
+	if result.Failed != 1 || result.Resolved != 1 {
+		t.Errorf("Failed = %d, Resolved = %d, want 1 and 1", result.Failed, result.Resolved)
+	}
+	if !strings.Contains(content, "GOOD=val-item1") {
+		t.Errorf("content missing resolved GOOD, got:\n%s", content)
+	}
+	if !strings.Contains(content, "# herald: BAD unresolved (op://V/item0/f)") {
+		t.Errorf("unresolved BAD line not commented out, got:\n%s", content)
+	}
+
+	if len(result.Refs) != 2 {
+		t.Fatalf("got %d ref reports, want 2", len(result.Refs))
+	}
+	bad, good := result.Refs[0], result.Refs[1]
+	if bad.Outcome != materialize.OutcomeFailed || bad.ErrorClass != materialize.ErrClassNotFound {
+		t.Errorf("bad report = %+v, want failed/not_found", bad)
+	}
+	if strings.Join(bad.Keys, ",") != "BAD" {
+		t.Errorf("bad keys = %v, want [BAD]", bad.Keys)
+	}
+	if good.Outcome != materialize.OutcomeResolved || good.Provider != "mock" {
+		t.Errorf("good report = %+v, want resolved via mock", good)
+	}
+	if strings.Join(good.Keys, ",") != "GOOD,ALSO_GOOD" {
+		t.Errorf("good keys = %v, want [GOOD ALSO_GOOD]", good.Keys)
+	}
+}
+
+func TestMaterializeStrictReport(t *testing.T) {
+	refs := map[string]*resolver.SecretRef{
+		"op://V/item0/f": {Vault: "V", Item: "item0", Field: "f", Raw: "op://V/item0/f"},
+	}
+	mat := materialize.NewEnvMaterializer(nil, &slowMgr{failItem: "item0"}, "memory", 3600)
+
+	_, result, err := mat.Materialize(context.Background(), "myapp", refs, "DB_PASSWORD=op://V/item0/f\n", "")
+	if err == nil {
+		t.Fatal("Materialize() error = nil, want error in strict mode")
+	}
+	if len(result.Refs) != 1 || result.Refs[0].Outcome != materialize.OutcomeFailed || result.Refs[0].Keys[0] != "DB_PASSWORD" {
+		t.Errorf("report = %+v, want DB_PASSWORD failed", result.Refs)
+	}
+}
+
+func TestMaterializeSecretsDir(t *testing.T) {
+	dir, err := os.MkdirTemp("", "herald-secrets-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	envContent := "APP_URL=https://example.com\nDB_PASSWORD=\"op://Vault/db/password\"\nexport DSN=postgres://app:op://Vault/db/password@db/app\n"
+	refs, err := resolver.ScanEnvFile(strings.NewReader(envContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mat := materialize.NewEnvMaterializer(nil, &mockMgr{val: "s3cr3t"}, "memory", 3600)
+	opts := materialize.SecretsDirOptions{Dir: dir + "/myapp", UID: -1, GID: -1, Mode: 0440}
+	content, files, _, err := mat.MaterializeSecretsDir(context.Background(), "myapp", refs, envContent, opts, "")
+	if err != nil {
+		t.Fatalf("MaterializeSecretsDir() error = %v", err)
+	}
+	if strings.Contains(content, "s3cr3t") {
+		t.Errorf("env content contains a secret value:\n%s", content)
+	}
+	want := "APP_URL=https://example.com\nDB_PASSWORD_FILE=" + dir + "/myapp/DB_PASSWORD\nexport DSN_FILE=" + dir + "/myapp/DSN\n"
+	if content != want {
+		t.Errorf("content = %q, want %q", content, want)
+	}
+	if len(files) != 2 {
+		t.Fatalf("files = %v, want 2 entries", files)
+	}
+
+	for path, wantVal := range map[string]string{
+		dir + "/myapp/DB_PASSWORD": "s3cr3t",
+		dir + "/myapp/DSN":         "postgres://app:s3cr3t@db/app",
+	} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile(%s) error = %v", path, err)
+		}
+		if string(data) != wantVal {
+			t.Errorf("%s = %q, want %q", path, data, wantVal)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0440 {
+			t.Errorf("%s mode = %o, want 0440", path, info.Mode().Perm())
+		}
+	}
+}
+
+func TestMaterializeSecretsDirRejectsBadKey(t *testing.T) {
+	dir, err := os.MkdirTemp("", "herald-secrets-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	envContent := "../escape=op://Vault/db/password\n"
+	refs, err := resolver.ScanEnvFile(strings.NewReader(envContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mat := materialize.NewEnvMaterializer(nil, &mockMgr{val: "s3cr3t"}, "memory", 3600)
+	opts := materialize.SecretsDirOptions{Dir: dir, UID: -1, GID: -1}
+	if _, _, _, err := mat.MaterializeSecretsDir(context.Background(), "myapp", refs, envContent, opts, ""); err == nil {
+		t.Fatal("MaterializeSecretsDir() error = nil, want rejection of path-like key")
+	}
+}
+
+func TestMaterializeRefPolicyNone(t *testing.T) {
+	dir := t.TempDir()
+	store, err := cache.New(dir+"/cache.db", "test-key-32chars-exactly!!!!!!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	envContent := "# herald: policy=none\nROOT_PW=op://Vault/db/root\nAPP_PW=op://Vault/db/app\n"
+	refs, _ := resolver.ScanEnvFile(strings.NewReader(envContent))
+	opts, err := resolver.RefOptions(envContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mat := materialize.NewEnvMaterializer(store, &mockMgr{val: "pw"}, cache.PolicyEncrypted, 3600)
+	mat.SetRefOptions(opts)
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := mat.Materialize(context.Background(), "db", refs, envContent, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.GetStale("Vault/db/root"); err != cache.ErrNotFound {
+		t.Errorf("policy=none ref was cached (err = %v)", err)
+	}
+	if e, err := store.Get("Vault/db/app"); err != nil || e.Policy != cache.PolicyEncrypted {
+		t.Errorf("default-policy ref: Get() = %v, %v; want encrypted entry", e, err)
+	}
+}
+
+// errMgr fails every lookup with err.
+type errMgr struct{ err error }
+
+func (m *errMgr) Resolve(ctx context.Context, vault, item, field string) (string, string, error) {
+	return "", "", m.err
+}
+
+func TestStaleWhileUnavailable(t *testing.T) {
+	dir := t.TempDir()
+	store, err := cache.New(dir+"/cache.db", "test-key-32chars-exactly!!!!!!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.Set("Vault/item/password", &cache.Entry{
+		Value:     "last-known",
+		Provider:  "connect",
+		Policy:    cache.PolicyEncrypted,
+		ExpiresAt: time.Now().Add(-time.Hour),
+	})
+
+	refs := map[string]*resolver.SecretRef{
+		"op://Vault/item/password": {Vault: "Vault", Item: "item", Field: "password", Raw: "op://Vault/item/password"},
+	}
+	outage := &errMgr{err: errors.New("connect: connection refused")}
+	run := func(p materialize.StalePolicy) (*materialize.Result, error) {
+		mat := materialize.NewEnvMaterializer(store, outage, cache.PolicyEncrypted, 3600)
+		mat.SetStalePolicy(p)
+		_, result, err := mat.ResolveRefs(context.Background(), refs)
+		return result, err
+	}
+
+	if _, err := run(materialize.StalePolicy{}); err == nil {
+		t.Error("default policy served stale on an outage, want failure (rate limits only)")
+	}
+
+	result, err := run(materialize.StalePolicy{Mode: materialize.StaleOnUnavailable})
+	if err != nil {
+		t.Fatalf("unavailable mode: error = %v", err)
+	}
+	rep := result.Refs[0]
+	if result.StaleHits != 1 || rep.Outcome != materialize.OutcomeStale || rep.StaleReason != materialize.ErrClassUnavailable {
+		t.Errorf("unavailable mode: stale_hits=%d report=%+v", result.StaleHits, rep)
+	}
+
+	bounded := materialize.StalePolicy{
+		Mode:        materialize.StaleOnUnavailable,
+		MaxAge:      48 * time.Hour,
+		VaultMaxAge: map[string]time.Duration{"Vault": 10 * time.Minute},
+	}
+	if _, err := run(bounded); err == nil {
+		t.Error("vault max age exceeded: error = nil, want failure")
+	}
+	bounded.RefMaxAge = map[string]time.Duration{"op://Vault/item/password": 2 * time.Hour}
+	if _, err := run(bounded); err != nil {
+		t.Errorf("ref max age overrides vault: error = %v", err)
+	}
+}
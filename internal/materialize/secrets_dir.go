@@ -0,0 +1,150 @@
+package materialize
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/elabx-org/herald/internal/format"
+	"github.com/elabx-org/herald/internal/fsutil"
+	"github.com/elabx-org/herald/internal/resolver"
+)
+
+// DefaultSecretFileMode is the permission used for per-secret files when the
+// caller does not set one.
+const DefaultSecretFileMode os.FileMode = 0400
+
+// envKeyRegex restricts keys that become file names, so a key can never
+// traverse out of the secrets directory.
+var envKeyRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SecretsDirOptions controls Docker-secrets style delivery: one file per
+// secret-bearing key in Dir, owned by UID/GID (-1 leaves ownership unchanged)
+// with permission Mode.
+type SecretsDirOptions struct {
+	Dir  string
+	UID  int
+	GID  int
+	Mode os.FileMode
+}
+
+// SecretFile records where a key's value was written. It never carries the
+// value itself.
+type SecretFile struct {
+	Key  string `json:"key"`
+	Path string `json:"path"`
+}
+
+// MaterializeSecretsDir resolves refs like Materialize, but instead of
+// inlining values it writes each secret-bearing key to its own file in
+// opts.Dir and returns env content where KEY=op://... becomes
+// KEY_FILE=<dir>/KEY. Non-secret lines are passed through unchanged, so the
+// env file never contains a secret value. If outPath is non-empty the env
+// content is also written there.
+//
+// In lenient mode, keys whose refs failed get no file and their line is
+// commented out as in Materialize.
+func (m *EnvMaterializer) MaterializeSecretsDir(ctx context.Context, stack string, refs map[string]*resolver.SecretRef, envContent string, opts SecretsDirOptions, outPath string) (string, []SecretFile, *Result, error) {
+	start := time.Now()
+	if opts.Mode == 0 {
+		opts.Mode = DefaultSecretFileMode
+	}
+	dir, err := filepath.Abs(opts.Dir)
+	if err != nil {
+		return "", nil, &Result{}, fmt.Errorf("secrets dir: %w", err)
+	}
+
+	resolvedVals, result, err := m.ResolveRefs(ctx, refs)
+	result.SetKeys(resolver.RefKeys(envContent))
+	if err != nil {
+		return "", nil, result, err
+	}
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", nil, result, fmt.Errorf("create secrets dir: %w", err)
+	}
+	if err := chown(dir, opts.UID, opts.GID); err != nil {
+		return "", nil, result, fmt.Errorf("chown secrets dir: %w", err)
+	}
+
+	var (
+		sb    strings.Builder
+		files []SecretFile
+	)
+	scanner := bufio.NewScanner(strings.NewReader(envContent))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || !resolver.HasRef(line) {
+			sb.WriteString(line + "\n")
+			continue
+		}
+		rawKey, value, ok := strings.Cut(trimmed, "=")
+		if !ok {
+			sb.WriteString(line + "\n")
+			continue
+		}
+		export := strings.HasPrefix(rawKey, "export ")
+		key := strings.TrimSpace(strings.TrimPrefix(rawKey, "export "))
+		if !envKeyRegex.MatchString(key) {
+			return "", nil, result, fmt.Errorf("key %q cannot be used as a secret file name", key)
+		}
+
+		// Unquote before substituting so quotes inside a secret survive.
+		resolved := resolver.ResolveValue(format.Unquote(strings.TrimSpace(value)), resolvedVals)
+		if resolver.HasRef(resolved) {
+			// Lenient mode: leave the line for DisableUnresolved below.
+			sb.WriteString(line + "\n")
+			continue
+		}
+
+		path := filepath.Join(dir, key)
+		if err := writeSecretFile(path, resolved, opts); err != nil {
+			return "", nil, result, fmt.Errorf("write secret file for %s: %w", key, err)
+		}
+		files = append(files, SecretFile{Key: key, Path: path})
+
+		if export {
+			sb.WriteString("export ")
+		}
+		sb.WriteString(key + "_FILE=" + path + "\n")
+	}
+
+	content := sb.String()
+	if result.Failed > 0 {
+		content = resolver.DisableUnresolved(content)
+	}
+	content, err = m.render(stack, content, func() []format.Var {
+		return format.Vars(content, nil)
+	})
+	if err != nil {
+		return "", nil, result, fmt.Errorf("render %s: %w", m.format, err)
+	}
+
+	if outPath != "" {
+		if err := writeFile(outPath, content); err != nil {
+			return "", nil, result, fmt.Errorf("write env file: %w", err)
+		}
+	}
+
+	result.DurationMs = time.Since(start).Milliseconds()
+	return content, files, result, nil
+}
+
+// writeSecretFile atomically writes value to path with the configured
+// ownership and mode.
+func writeSecretFile(path, value string, opts SecretsDirOptions) error {
+	return fsutil.WriteFileAtomic(path, []byte(value), opts.Mode, opts.UID, opts.GID)
+}
+
+func chown(path string, uid, gid int) error {
+	if uid < 0 && gid < 0 {
+		return nil
+	}
+	return os.Chown(path, uid, gid)
+}
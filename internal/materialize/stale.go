@@ -0,0 +1,54 @@
+package materialize
+
+import "time"
+
+// Stale modes: which provider failures may be answered from an expired cache
+// entry.
+const (
+	StaleOnRateLimit   = "rate_limited" // only when the provider rate limits (default)
+	StaleOnUnavailable = "unavailable"  // rate limits, outages, network errors and timeouts
+	StaleNever         = "never"
+)
+
+// StalePolicy bounds stale-while-unavailable serving. Max ages measure how
+// long an entry has been past its expiry; the most specific limit wins (ref,
+// then vault, then MaxAge) and zero means unbounded.
+type StalePolicy struct {
+	Mode        string
+	MaxAge      time.Duration
+	VaultMaxAge map[string]time.Duration
+	RefMaxAge   map[string]time.Duration // keyed by raw op:// URI
+}
+
+// ValidStaleMode reports whether mode is a known stale mode ("" counts as
+// the default).
+func ValidStaleMode(mode string) bool {
+	switch mode {
+	case "", StaleOnRateLimit, StaleOnUnavailable, StaleNever:
+		return true
+	}
+	return false
+}
+
+// serves reports whether a failure of the given error class may fall back to
+// a stale entry.
+func (p StalePolicy) serves(class string) bool {
+	switch p.Mode {
+	case StaleNever:
+		return false
+	case StaleOnUnavailable:
+		return class == ErrClassRateLimited || class == ErrClassUnavailable || class == ErrClassTimeout
+	default:
+		return class == ErrClassRateLimited
+	}
+}
+
+func (p StalePolicy) maxAge(rawURI, vault string) time.Duration {
+	if d, ok := p.RefMaxAge[rawURI]; ok {
+		return d
+	}
+	if d, ok := p.VaultMaxAge[vault]; ok {
+		return d
+	}
+	return p.MaxAge
+}
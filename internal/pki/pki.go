@@ -0,0 +1,169 @@
+// Package pki creates a small local certificate authority for serving Herald
+// over TLS and issuing client certificates for mutual TLS.
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/elabx-org/herald/internal/fsutil"
+)
+
+// File names inside a PKI directory.
+const (
+	CACert = "ca.crt"
+	CAKey  = "ca.key"
+)
+
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 2 * 365 * 24 * time.Hour
+)
+
+// ErrExists is returned by Init when the directory already holds a CA.
+var ErrExists = errors.New("pki: CA already exists")
+
+// Init creates a CA named name in dir, writing ca.crt and ca.key. It refuses
+// to overwrite an existing CA.
+func Init(dir, name string) error {
+	if _, err := os.Stat(filepath.Join(dir, CAKey)); err == nil {
+		return ErrExists
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	if tmpl.SerialNumber, err = serial(); err != nil {
+		return err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	return write(dir, "ca", der, key)
+}
+
+// IssueServer signs a server certificate for hosts (DNS names or IPs) with
+// the CA in dir and writes <name>.crt and <name>.key.
+func IssueServer(dir, name string, hosts []string) error {
+	if len(hosts) == 0 {
+		return errors.New("pki: a server certificate needs at least one host")
+	}
+	tmpl := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: hosts[0]},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	return issue(dir, name, tmpl)
+}
+
+// IssueClient signs a client certificate whose common name is name with the
+// CA in dir and writes <name>.crt and <name>.key.
+func IssueClient(dir, name string) error {
+	return issue(dir, name, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+}
+
+func issue(dir, name string, tmpl *x509.Certificate) error {
+	if name == "" || name == "ca" || filepath.Base(name) != name {
+		return fmt.Errorf("pki: invalid certificate name %q", name)
+	}
+	caCert, caKey, err := loadCA(dir)
+	if err != nil {
+		return err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	tmpl.NotBefore = now.Add(-time.Hour)
+	tmpl.NotAfter = now.Add(leafValidity)
+	if tmpl.NotAfter.After(caCert.NotAfter) {
+		tmpl.NotAfter = caCert.NotAfter
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	if tmpl.SerialNumber, err = serial(); err != nil {
+		return err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	return write(dir, name, der, key)
+}
+
+func loadCA(dir string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, err := os.ReadFile(filepath.Join(dir, CACert))
+	if err != nil {
+		return nil, nil, fmt.Errorf("pki: read CA (run herald pki init first): %w", err)
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(dir, CAKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("pki: read CA key: %w", err)
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, errors.New("pki: CA files are not PEM")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// write stores the certificate (0644) and private key (0600) as PEM files.
+func write(dir, name string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := fsutil.WriteFileAtomic(filepath.Join(dir, name+".key"), keyPEM, 0600, -1, -1); err != nil {
+		return err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return fsutil.WriteFileAtomic(filepath.Join(dir, name+".crt"), certPEM, 0644, -1, -1)
+}
+
+func serial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,65 @@
+package pki_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elabx-org/herald/internal/pki"
+)
+
+func TestInitAndIssue(t *testing.T) {
+	dir := t.TempDir()
+	if err := pki.Init(dir, "test CA"); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if err := pki.Init(dir, "test CA"); !errors.Is(err, pki.ErrExists) {
+		t.Errorf("second Init() error = %v, want ErrExists", err)
+	}
+	if err := pki.IssueServer(dir, "server", []string{"herald", "127.0.0.1"}); err != nil {
+		t.Fatalf("IssueServer() error = %v", err)
+	}
+	if err := pki.IssueClient(dir, "komodo"); err != nil {
+		t.Fatalf("IssueClient() error = %v", err)
+	}
+	if err := pki.IssueClient(dir, "../escape"); err == nil {
+		t.Error("IssueClient() with a path name should fail")
+	}
+
+	caPEM, err := os.ReadFile(filepath.Join(dir, pki.CACert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+
+	if fi, err := os.Stat(filepath.Join(dir, pki.CAKey)); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("CA key mode = %v, %v; want 0600", fi.Mode().Perm(), err)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		usage x509.ExtKeyUsage
+		host  string
+	}{
+		{"server", x509.ExtKeyUsageServerAuth, "herald"},
+		{"server", x509.ExtKeyUsageServerAuth, "127.0.0.1"},
+		{"komodo", x509.ExtKeyUsageClientAuth, ""},
+	} {
+		pair, err := tls.LoadX509KeyPair(filepath.Join(dir, tt.name+".crt"), filepath.Join(dir, tt.name+".key"))
+		if err != nil {
+			t.Fatalf("load %s: %v", tt.name, err)
+		}
+		leaf, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := x509.VerifyOptions{Roots: roots, DNSName: tt.host, KeyUsages: []x509.ExtKeyUsage{tt.usage}}
+		if _, err := leaf.Verify(opts); err != nil {
+			t.Errorf("verify %s for %q: %v", tt.name, tt.host, err)
+		}
+	}
+}
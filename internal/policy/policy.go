@@ -0,0 +1,144 @@
+// Package policy restricts which vaults and items each stack may reference.
+package policy
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/elabx-org/herald/internal/resolver"
+	"github.com/gobwas/glob"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DefaultDeny  = "deny"
+	DefaultAllow = "allow"
+)
+
+// file is the on-disk policy format:
+//
+//	default: deny
+//	stacks:
+//	  myapp:
+//	    - HomeLab/myapp
+//	    - HomeLab/shared-*
+//	  "web-*":
+//	    - Web
+type file struct {
+	Default string              `yaml:"default"` // deny (default) or allow, for stacks no rule names
+	Stacks  map[string][]string `yaml:"stacks"`  // stack glob -> allowed "vault" or "vault/item" globs
+}
+
+type rule struct {
+	stack glob.Glob
+	allow []glob.Glob
+}
+
+// Policy maps stacks to the vaults and items they may reference. A nil
+// Policy allows everything.
+type Policy struct {
+	allowUnlisted bool
+	rules         []rule
+}
+
+// Denial is a ref a stack is not allowed to use, with the keys that use it.
+type Denial struct {
+	Ref  string   `json:"ref"`
+	Keys []string `json:"keys"`
+}
+
+// Load reads and compiles the policy file at path.
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Parse compiles a policy from its YAML form.
+func Parse(data []byte) (*Policy, error) {
+	var f file
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	switch f.Default {
+	case "", DefaultDeny:
+	case DefaultAllow:
+		p.allowUnlisted = true
+	default:
+		return nil, fmt.Errorf("default must be %q or %q", DefaultDeny, DefaultAllow)
+	}
+
+	stacks := make([]string, 0, len(f.Stacks))
+	for stack := range f.Stacks {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+	for _, stack := range stacks {
+		sg, err := glob.Compile(stack)
+		if err != nil {
+			return nil, fmt.Errorf("stack %q: %w", stack, err)
+		}
+		rl := rule{stack: sg}
+		for _, pattern := range f.Stacks[stack] {
+			pattern = strings.Trim(strings.TrimPrefix(pattern, "op://"), "/")
+			if pattern == "" {
+				return nil, fmt.Errorf("stack %q: empty allow entry", stack)
+			}
+			if !strings.Contains(pattern, "/") {
+				pattern += "/*" // a bare vault allows all of its items
+			}
+			g, err := glob.Compile(pattern, '/')
+			if err != nil {
+				return nil, fmt.Errorf("stack %q: allow %q: %w", stack, pattern, err)
+			}
+			rl.allow = append(rl.allow, g)
+		}
+		p.rules = append(p.rules, rl)
+	}
+	return p, nil
+}
+
+// Allows reports whether stack may reference item in vault. Every rule whose
+// stack pattern matches contributes its allow list; a stack no rule matches
+// falls back to the policy default.
+func (p *Policy) Allows(stack, vault, item string) bool {
+	if p == nil {
+		return true
+	}
+	target := vault + "/" + item
+	listed := false
+	for _, rl := range p.rules {
+		if !rl.stack.Match(stack) {
+			continue
+		}
+		listed = true
+		for _, g := range rl.allow {
+			if g.Match(target) {
+				return true
+			}
+		}
+	}
+	return !listed && p.allowUnlisted
+}
+
+// Check returns the refs stack may not use, sorted by ref. keys maps each raw
+// op:// URI to the env keys that reference it.
+func (p *Policy) Check(stack string, refs map[string]*resolver.SecretRef, keys map[string][]string) []Denial {
+	var denials []Denial
+	for uri, ref := range refs {
+		if !p.Allows(stack, ref.Vault, ref.Item) {
+			denials = append(denials, Denial{Ref: uri, Keys: keys[uri]})
+		}
+	}
+	sort.Slice(denials, func(i, j int) bool { return denials[i].Ref < denials[j].Ref })
+	return denials
+}
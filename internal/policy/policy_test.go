@@ -0,0 +1,80 @@
+package policy_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/elabx-org/herald/internal/policy"
+	"github.com/elabx-org/herald/internal/resolver"
+)
+
+func TestAllows(t *testing.T) {
+	p, err := policy.Parse([]byte(`
+stacks:
+  myapp:
+    - HomeLab/myapp
+    - HomeLab/shared-*
+  "web-*":
+    - Web
+`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	tests := []struct {
+		stack, vault, item string
+		want               bool
+	}{
+		{"myapp", "HomeLab", "myapp", true},
+		{"myapp", "HomeLab", "shared-smtp", true},
+		{"myapp", "HomeLab", "other", false},
+		{"myapp", "Web", "site", false},
+		{"web-blog", "Web", "site", true},
+		{"web-blog", "HomeLab", "myapp", false},
+		{"unlisted", "HomeLab", "myapp", false}, // default deny
+	}
+	for _, tt := range tests {
+		if got := p.Allows(tt.stack, tt.vault, tt.item); got != tt.want {
+			t.Errorf("Allows(%q, %q, %q) = %v, want %v", tt.stack, tt.vault, tt.item, got, tt.want)
+		}
+	}
+
+	var nilPolicy *policy.Policy
+	if !nilPolicy.Allows("any", "Vault", "item") {
+		t.Error("nil policy should allow everything")
+	}
+}
+
+func TestDefaultAllow(t *testing.T) {
+	p, err := policy.Parse([]byte("default: allow\nstacks:\n  myapp: [HomeLab/myapp]\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !p.Allows("unlisted", "Anything", "item") {
+		t.Error("unlisted stack should be allowed with default: allow")
+	}
+	if p.Allows("myapp", "HomeLab", "other") {
+		t.Error("listed stack should be limited to its allow list")
+	}
+
+	if _, err := policy.Parse([]byte("default: maybe\n")); err == nil {
+		t.Error("Parse() with an invalid default should fail")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p, err := policy.Parse([]byte("stacks:\n  myapp: [HomeLab/myapp]\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	content := "DB=op://HomeLab/myapp/db\nADMIN=op://HomeLab/root/password\nROOT=op://HomeLab/root/password\n"
+	refs, err := resolver.ScanEnvFile(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("ScanEnvFile() error = %v", err)
+	}
+	got := p.Check("myapp", refs, resolver.RefKeys(content))
+	want := []policy.Denial{{Ref: "op://HomeLab/root/password", Keys: []string{"ADMIN", "ROOT"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Check() = %+v, want %+v", got, want)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	onepassword "github.com/1password/onepassword-sdk-go"
+	"github.com/elabx-org/herald/internal/lint"
 )
 
 // FieldSpec describes a field to create in a 1Password item.
@@ -242,14 +243,7 @@ func generatePassword(length int) (string, error) {
 
 // isLikelySecret returns true if the field name suggests it holds a secret value.
 func isLikelySecret(name string) bool {
-	lower := strings.ToLower(name)
-	secretWords := []string{"password", "passwd", "secret", "token", "key", "api_key", "apikey", "credential", "private"}
-	for _, w := range secretWords {
-		if strings.Contains(lower, w) {
-			return true
-		}
-	}
-	return false
+	return lint.IsLikelySecretName(name)
 }
 
 // resolveCategory maps a user-supplied category string to the SDK constant.
@@ -2,8 +2,10 @@ package resolver
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -29,15 +31,8 @@ func ScanEnvFile(r io.Reader) (map[string]*SecretRef, error) {
 		if len(parts) != 2 {
 			continue
 		}
-		for _, rawURI := range opURIRegex.FindAllString(parts[1], -1) {
-			if _, exists := refs[rawURI]; exists {
-				continue
-			}
-			ref, err := ParseOpURI(rawURI)
-			if err != nil {
-				return nil, err
-			}
-			refs[rawURI] = ref
+		if err := ScanValue(parts[1], refs); err != nil {
+			return nil, err
 		}
 	}
 	return refs, scanner.Err()
@@ -56,13 +51,213 @@ func ResolveEnvContent(content string, resolvedByURI map[string]string) string {
 			sb.WriteString(line + "\n")
 			continue
 		}
-		resolved := opURIRegex.ReplaceAllStringFunc(line, func(uri string) string {
-			if val, ok := resolvedByURI[uri]; ok {
-				return val
-			}
-			return uri
-		})
-		sb.WriteString(resolved + "\n")
+		sb.WriteString(ResolveValue(line, resolvedByURI) + "\n")
+	}
+	return sb.String()
+}
+
+// ScanValue adds every op:// URI found in a single value to refs, keyed by the
+// raw URI. It is the per-value counterpart of ScanEnvFile for callers that
+// already have key/value pairs (e.g. Compose environment blocks).
+func ScanValue(value string, refs map[string]*SecretRef) error {
+	for _, rawURI := range opURIRegex.FindAllString(value, -1) {
+		if _, exists := refs[rawURI]; exists {
+			continue
+		}
+		ref, err := ParseOpURI(rawURI)
+		if err != nil {
+			return err
+		}
+		refs[rawURI] = ref
+	}
+	return nil
+}
+
+// HasRef reports whether value contains at least one op:// URI.
+func HasRef(value string) bool {
+	return opURIRegex.MatchString(value)
+}
+
+// ResolveValue returns value with all op:// URIs replaced by their resolved
+// values. URIs missing from resolvedByURI are left untouched.
+func ResolveValue(value string, resolvedByURI map[string]string) string {
+	return opURIRegex.ReplaceAllStringFunc(value, func(uri string) string {
+		if val, ok := resolvedByURI[uri]; ok {
+			return val
+		}
+		return uri
+	})
+}
+
+// MalformedRefs returns op:// fragments in value that opURIRegex would skip or
+// truncate: fewer than three segments, characters outside the allowed set, or
+// extra path segments (e.g. op://vault/item/section/field). Each fragment runs
+// from "op://" to the next whitespace or quote.
+func MalformedRefs(value string) []string {
+	var bad []string
+	for i := 0; ; {
+		idx := strings.Index(value[i:], opScheme)
+		if idx < 0 {
+			break
+		}
+		start := i + idx
+		rest := value[start:]
+		loc := opURIRegex.FindStringIndex(rest)
+		switch {
+		case loc == nil || loc[0] != 0:
+			bad = append(bad, refFragment(rest))
+		case loc[1] < len(rest) && (rest[loc[1]] == '/' || rest[loc[1]] == '.'):
+			bad = append(bad, refFragment(rest))
+		}
+		i = start + len(opScheme)
+	}
+	return bad
+}
+
+func refFragment(s string) string {
+	if end := strings.IndexAny(s, " \t\"'"); end >= 0 {
+		return s[:end]
+	}
+	return s
+}
+
+// RefKeys maps each op:// URI in env content to the names of the variables
+// that reference it, in file order.
+func RefKeys(content string) map[string][]string {
+	keys := make(map[string][]string)
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(strings.TrimPrefix(key, "export "))
+		for _, uri := range opURIRegex.FindAllString(value, -1) {
+			keys[uri] = appendUnique(keys[uri], key)
+		}
+	}
+	return keys
+}
+
+// DisableUnresolved comments out every non-comment line that still contains
+// an op:// URI after resolution, so a partially resolved file never hands a
+// literal op:// string to a container. The comment names the key and refs but
+// not the rest of the line, which may hold resolved values.
+func DisableUnresolved(content string) string {
+	var sb strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || !opURIRegex.MatchString(line) {
+			sb.WriteString(line + "\n")
+			continue
+		}
+		key, _, _ := strings.Cut(trimmed, "=")
+		refs := strings.Join(opURIRegex.FindAllString(line, -1), ", ")
+		sb.WriteString("# herald: " + strings.TrimSpace(key) + " unresolved (" + refs + ")\n")
 	}
 	return sb.String()
 }
+
+// annotationPrefix starts a per-ref option comment.
+const annotationPrefix = "# herald:"
+
+// RefOption is a per-ref cache override taken from an annotation comment on
+// the line above a variable, e.g. "# herald: policy=none ttl=60". Empty
+// Policy and zero TTL mean the server defaults apply.
+type RefOption struct {
+	Policy string `json:"policy,omitempty"`
+	TTL    int    `json:"ttl,omitempty"` // seconds
+}
+
+// RefOptions returns the annotation options for each raw op:// URI. An
+// annotation applies to the next variable line only. Comments starting with
+// "# herald:" that are not all key=value pairs (such as the markers written
+// by DisableUnresolved) are ignored; unknown keys, bad TTLs and a URI given
+// different options on different lines are errors. Policy names are not
+// validated here.
+func RefOptions(content string) (map[string]RefOption, error) {
+	opts := make(map[string]RefOption)
+	var (
+		pending *RefOption
+		lineNo  int
+	)
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			opt, ok, err := parseAnnotation(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			if ok {
+				pending = &opt
+			}
+			continue
+		}
+		opt := pending
+		pending = nil
+		_, value, ok := strings.Cut(line, "=")
+		if !ok || opt == nil {
+			continue
+		}
+		for _, uri := range opURIRegex.FindAllString(value, -1) {
+			if prev, seen := opts[uri]; seen && prev != *opt {
+				return nil, fmt.Errorf("line %d: %s has conflicting herald annotations", lineNo, uri)
+			}
+			opts[uri] = *opt
+		}
+	}
+	return opts, scanner.Err()
+}
+
+// parseAnnotation parses a "# herald: k=v ..." comment. ok is false for
+// comments that are not option annotations.
+func parseAnnotation(line string) (opt RefOption, ok bool, err error) {
+	if !strings.HasPrefix(line, annotationPrefix) {
+		return opt, false, nil
+	}
+	fields := strings.Fields(strings.TrimPrefix(line, annotationPrefix))
+	if len(fields) == 0 {
+		return opt, false, nil
+	}
+	for _, f := range fields {
+		if !strings.Contains(f, "=") {
+			return RefOption{}, false, nil
+		}
+	}
+	for _, f := range fields {
+		k, v, _ := strings.Cut(f, "=")
+		switch k {
+		case "policy":
+			opt.Policy = v
+		case "ttl":
+			ttl, err := strconv.Atoi(v)
+			if err != nil || ttl <= 0 {
+				return RefOption{}, false, fmt.Errorf("herald annotation: ttl must be a positive number of seconds, got %q", v)
+			}
+			opt.TTL = ttl
+		default:
+			return RefOption{}, false, fmt.Errorf("herald annotation: unknown option %q", k)
+		}
+	}
+	return opt, true, nil
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
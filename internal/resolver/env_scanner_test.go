@@ -107,3 +107,61 @@ DATABASE_URL=postgresql+asyncpg://user:op://Vault/app/password@postgres:5432/db`
 		t.Errorf("got %d refs, want 1 (deduplicated)", len(refs))
 	}
 }
+
+func TestMalformedRefs(t *testing.T) {
+	cases := map[string]int{
+		"op://Vault/item/field":                     0,
+		"postgres://u:op://Vault/item/field@db/app": 0,
+		"op://Vault/item":                           1,
+		"op://Vault/My Item/field":                  1,
+		"op://Vault/item/section/field":             1,
+		"op://Vault/item.name/field":                1,
+		"a=op://V/i/f b=op://V/i":                   1,
+	}
+	for value, want := range cases {
+		if got := resolver.MalformedRefs(value); len(got) != want {
+			t.Errorf("MalformedRefs(%q) = %v, want %d fragment(s)", value, got, want)
+		}
+	}
+}
+
+func TestRefKeysAndDisableUnresolved(t *testing.T) {
+	content := "A=op://V/i/f\nexport B=x:op://V/i/f@h\nC=plain\n"
+	keys := resolver.RefKeys(content)
+	if got := strings.Join(keys["op://V/i/f"], ","); got != "A,B" {
+		t.Errorf("RefKeys = %q, want A,B", got)
+	}
+
+	got := resolver.DisableUnresolved("# note\nA=op://V/i/f\nC=plain\n")
+	want := "# note\n# herald: A unresolved (op://V/i/f)\nC=plain\n"
+	if got != want {
+		t.Errorf("DisableUnresolved() = %q, want %q", got, want)
+	}
+}
+
+func TestRefOptions(t *testing.T) {
+	content := "# herald: policy=none ttl=60\nROOT_PW=op://V/db/root\n# herald: policy=tmpfs\n\nAPI=op://V/api/key\nPLAIN=op://V/plain/key\n# herald: KEY unresolved (op://V/x/y)\n"
+	opts, err := resolver.RefOptions(content)
+	if err != nil {
+		t.Fatalf("RefOptions() error = %v", err)
+	}
+	if got := opts["op://V/db/root"]; got.Policy != "none" || got.TTL != 60 {
+		t.Errorf("root option = %+v, want none/60", got)
+	}
+	if got := opts["op://V/api/key"]; got.Policy != "tmpfs" || got.TTL != 0 {
+		t.Errorf("api option = %+v, want tmpfs/0", got)
+	}
+	if _, ok := opts["op://V/plain/key"]; ok {
+		t.Error("annotation leaked onto the following variable")
+	}
+
+	for _, bad := range []string{
+		"# herald: polcy=none\nA=op://V/i/f\n",
+		"# herald: ttl=soon\nA=op://V/i/f\n",
+		"# herald: policy=none\nA=op://V/i/f\n# herald: policy=memory\nB=op://V/i/f\n",
+	} {
+		if _, err := resolver.RefOptions(bad); err == nil {
+			t.Errorf("RefOptions(%q) error = nil, want error", bad)
+		}
+	}
+}